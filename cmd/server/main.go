@@ -1,139 +1,248 @@
 package main
 
 import (
+	"context"
 	"errors"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt/v5"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sokolawesome/chat-server/config"
 	"github.com/sokolawesome/chat-server/internal/database"
+	"github.com/sokolawesome/chat-server/internal/emailverify"
 	"github.com/sokolawesome/chat-server/internal/handlers"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/loginguard"
+	"github.com/sokolawesome/chat-server/internal/mailer"
+	"github.com/sokolawesome/chat-server/internal/metrics"
+	"github.com/sokolawesome/chat-server/internal/migrations"
+	"github.com/sokolawesome/chat-server/internal/oidc"
+	"github.com/sokolawesome/chat-server/internal/origin"
+	"github.com/sokolawesome/chat-server/internal/password"
+	"github.com/sokolawesome/chat-server/internal/ratelimit"
 	"github.com/sokolawesome/chat-server/internal/repository"
 	"github.com/sokolawesome/chat-server/internal/router"
+	"github.com/sokolawesome/chat-server/internal/tracing"
+	"github.com/sokolawesome/chat-server/internal/username"
+	"github.com/sokolawesome/chat-server/internal/ws"
 )
 
-func handleWebSocket(ctx *gin.Context, cfg *config.Config, wsUpgrader *websocket.Upgrader) {
-	tokenString := ctx.Query("token")
-	if tokenString == "" {
-		log.Println("missing token in query parameters")
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Missing authentication token"})
-		ctx.Abort()
-		return
-	}
+func main() {
+	logger.Init(slog.LevelInfo)
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(cfg.JwtSecret), nil
-	})
+	cfg, err := config.Load()
+	if err != nil {
+		fatal("failed to load configuration", err)
+	}
+	logger.Init(logger.LevelFromString(cfg.LogLevel))
 
+	originMatcher, err := origin.NewMatcher(cfg.AllowedOrigins)
 	if err != nil {
-		log.Printf("jwt parsing/validation error: %v", err)
-		errMsg := "invalid or expired token"
-		if errors.Is(err, jwt.ErrTokenExpired) {
-			errMsg = "token has expired"
-		}
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": errMsg})
-		ctx.Abort()
-		return
+		fatal("failed to parse allowed origins", err)
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userIDF64, okSub := claims["sub"].(float64)
-		if !okSub {
-			log.Println("invalid token payload (missing/invalid sub claim)")
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token payload"})
-			ctx.Abort()
-			return
-		}
-		userID := int64(userIDF64)
-		log.Printf("user %d authorized for websocket connection", userID)
-	} else {
-		log.Println("invalid token (claims invalid or token marked invalid)")
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
-		ctx.Abort()
-		return
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.TracingExporter, cfg.TracingServiceName)
+	if err != nil {
+		fatal("failed to initialize tracing", err)
 	}
 
-	conn, err := wsUpgrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	db, err := database.Connect(cfg)
 	if err != nil {
-		log.Printf("failed to upgrade connection from %s: %v", ctx.Request.RemoteAddr, err)
-		return
+		fatal("failed to connect to database", err)
 	}
+
 	defer func() {
-		log.Println("closing connection for client:", conn.RemoteAddr())
-		if err := conn.Close(); err != nil {
-			log.Printf("error closing websocket connection for %s: %v", conn.RemoteAddr(), err)
-		} else {
-			log.Println("websocket connection closed successfully for:", conn.RemoteAddr())
+		slog.Info("closing database connection pool...")
+		if err := db.Close(); err != nil {
+			slog.Error("error closing database connection", logger.Err(err))
 		}
 	}()
 
-	log.Println("websocket client connected:", conn.RemoteAddr())
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(context.Background(), db, os.Args[2:]); err != nil {
+			fatal("migration failed", err)
+		}
+		return
+	}
 
-	for {
-		messageType, p, err := conn.ReadMessage()
+	if cfg.DbMigrateOnStartup {
+		migrator, err := migrations.New(db)
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("websocket error for client %s: %v", conn.RemoteAddr(), err)
-			} else {
-				log.Println("websocket client disconnected:", conn.RemoteAddr())
-			}
-			break
+			fatal("failed to load migrations", err)
 		}
+		if _, err := migrator.Up(context.Background()); err != nil {
+			fatal("failed to apply migrations", err)
+		}
+	}
 
-		log.Printf("received message (type %d) from %s: %s", messageType, conn.RemoteAddr(), string(p))
-
-		if err := conn.WriteMessage(messageType, p); err != nil {
-			log.Printf("failed to write message to client %s: %v", conn.RemoteAddr(), err)
-			break
+	passwordHasher, err := password.New(cfg.PasswordHashAlgorithm, cfg.BcryptCost, password.Argon2Params{
+		Memory:      uint32(cfg.Argon2Memory),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Parallelism),
+		SaltLength:  16,
+		KeyLength:   32,
+	})
+	if err != nil {
+		fatal("failed to configure password hashing", err)
+	}
+	var breachedPasswords password.BreachedList
+	if cfg.PasswordBreachedPath != "" {
+		breachedPasswords, err = password.NewBreachedList(cfg.PasswordBreachedPath, cfg.PasswordBreachedMin)
+		if err != nil {
+			fatal("failed to open breached password list", err)
 		}
 	}
+	passwordPolicy, err := password.NewPolicy(cfg.PasswordMinLength, cfg.PasswordMaxBytes, cfg.PasswordBannedFile, breachedPasswords)
+	if err != nil {
+		fatal("failed to load password policy", err)
+	}
+	usernamePolicy := username.NewPolicy(cfg.UsernameReserved)
+	userRepository := repository.NewUserRepository(db, passwordHasher)
+	var loginAttemptRepository repository.LoginAttemptRepository
+	if cfg.LoginAttemptStore == "memory" {
+		loginAttemptRepository = repository.NewInMemoryLoginAttemptRepository(cfg.LoginAttemptWindow)
+	} else {
+		loginAttemptRepository = repository.NewLoginAttemptRepository(db)
+	}
+	loginGuard := loginguard.New(loginAttemptRepository, loginguard.Options{
+		MaxAttemptsPerUser: cfg.LoginMaxAttemptsUser,
+		MaxAttemptsPerIP:   cfg.LoginMaxAttemptsIP,
+		BackoffBase:        cfg.LoginBackoffBase,
+		BackoffMax:         cfg.LoginBackoffMax,
+		LockoutDuration:    cfg.LoginLockoutDuration,
+		Window:             cfg.LoginAttemptWindow,
+	})
 
-	log.Printf("handler finished for client: %s", conn.RemoteAddr())
-}
+	var mail mailer.Mailer = mailer.NewLogMailer()
+	if cfg.MailerDriver == "smtp" {
+		mail = mailer.NewSMTPMailer(cfg.SmtpHost, cfg.SmtpPort, cfg.SmtpUsername, cfg.SmtpPassword, cfg.MailFrom)
+	}
+	emailVerifier := emailverify.New(cfg.JwtSecret, cfg.EmailVerifyTTL, mail, cfg.EmailVerifyURL)
+	hub := ws.NewHub()
+	metrics.RegisterQueueDepth(hub.QueueDepth)
+	metrics.RegisterDBStats(db)
+
+	mfaRepository := repository.NewMFARepository(db)
+	sessionRepository := repository.NewSessionRepository(db)
+	authHandler := handlers.NewAuthHandler(userRepository, mfaRepository, sessionRepository, hub, passwordHasher, passwordPolicy, usernamePolicy, loginGuard, emailVerifier, cfg.RequireVerifiedEmail, cfg.JwtSecret, cfg.JwtExpirationDuration, cfg.JwtIssuer)
+	emailVerificationHandler := handlers.NewEmailVerificationHandler(userRepository, emailVerifier)
+	mfaHandler := handlers.NewMFAHandler(userRepository, mfaRepository, loginGuard, cfg.TotpIssuer)
+	apiKeyRepository := repository.NewAPIKeyRepository(db)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyRepository, hub, cfg.APIKeysMaxPerUser)
+	sessionHandler := handlers.NewSessionHandler(sessionRepository, hub)
+	profileHandler := handlers.NewProfileHandler(repository.NewProfileRepository(db), hub, cfg.AvatarMaxUploadBytes, cfg.AvatarBaseURL)
+
+	// SSO is optional; without an issuer the routes are not registered.
+	var oidcHandler *handlers.OIDCHandler
+	if cfg.OidcIssuerURL != "" {
+		oidcProvider := oidc.NewProvider(oidc.Config{
+			IssuerURL:    cfg.OidcIssuerURL,
+			ClientID:     cfg.OidcClientID,
+			ClientSecret: cfg.OidcClientSecret,
+			RedirectURL:  cfg.OidcRedirectURL,
+			Scopes:       cfg.OidcScopes,
+		})
+		identityRepository := repository.NewIdentityRepository(db)
+		oidcHandler = handlers.NewOIDCHandler(oidcProvider, oidc.NewFlowCodec(cfg.JwtSecret), userRepository, identityRepository, authHandler, cfg.OidcPostLoginURL, cfg.OidcJITProvisioning, cfg.AppEnv == "production")
+	}
+	passwordResetRepository := repository.NewPasswordResetRepository(db)
+	passwordResetHandler := handlers.NewPasswordResetHandler(userRepository, passwordResetRepository, sessionRepository, hub, passwordPolicy, mail, loginGuard, cfg.PasswordResetURL, cfg.PasswordResetTTL)
+	wsUpgrader := websocket.Upgrader{
+		CheckOrigin:       ws.CheckOrigin(originMatcher),
+		ReadBufferSize:    cfg.WsReadBufferSize,
+		WriteBufferSize:   cfg.WsWriteBufferSize,
+		EnableCompression: cfg.WsEnableCompression,
+		Subprotocols:      ws.Subprotocols(),
+	}
 
-func main() {
-	cfg, err := config.Load()
+	clientOptions := ws.Options{
+		SendQueueSize: cfg.WsSendQueueSize,
+		Policy:        ws.Policy(cfg.WsSlowConsumerPolicy),
+		WriteTimeout:  cfg.WsWriteTimeout,
+
+		CompressionMinSize: cfg.WsCompressionMinSize,
+		CompressionLevel:   cfg.WsCompressionLevel,
+	}
+	if cfg.RateLimitEnabled {
+		clientOptions.MessageLimiter = ratelimit.NewLimiter(cfg.WsMessageRate)
+		clientOptions.MaxRateLimitStrikes = cfg.WsRateLimitStrikes
+	}
+	wsHandler := handlers.NewWsHandler(cfg.JwtSecret, userRepository, apiKeyRepository, sessionRepository, cfg.RequireVerifiedEmail, &wsUpgrader, hub, clientOptions)
+	healthHandler := handlers.NewHealthHandler(cfg.HealthCheckTimeout, handlers.HealthCheck{
+		Name:  "database",
+		Check: db.PingContext,
+	})
+	ginRouter, err := router.SetupRouter(cfg, originMatcher, userRepository, apiKeyRepository, sessionRepository, authHandler, passwordResetHandler, emailVerificationHandler, mfaHandler, apiKeyHandler, sessionHandler, profileHandler, oidcHandler, wsHandler, healthHandler)
 	if err != nil {
-		log.Fatalf("FATAL: Failed to load configuration: %v", err)
+		fatal("failed to set up router", err)
 	}
 
-	db, err := database.Connect(cfg)
-	if err != nil {
-		log.Fatalf("FATAL: Failed to connect to database: %v", err)
+	server := &http.Server{
+		Addr:    ":" + cfg.ServerPort,
+		Handler: ginRouter,
 	}
 
-	defer func() {
-		log.Println("closing database connection pool...")
-		if err := db.Close(); err != nil {
-			log.Printf("error closing database connection: %v", err)
+	var metricsServer *http.Server
+	if cfg.MetricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", promhttp.Handler())
+		metricsServer = &http.Server{
+			Addr:    cfg.MetricsAddr,
+			Handler: mux,
 		}
-	}()
+	}
 
-	userRepository := repository.NewUserRepository(db, cfg.BcryptCost)
-	authHandler := handlers.NewAuthHandler(userRepository, cfg.JwtSecret, cfg.JwtExpirationDuration, cfg.JwtIssuer)
-	ginRouter := router.SetupRouter(cfg, authHandler)
-	wsUpgrader := websocket.Upgrader{
-		CheckOrigin: func(r *http.Request) bool {
-			// origin check later
-			return true
-		},
-		ReadBufferSize:  cfg.WsReadBufferSize,
-		WriteBufferSize: cfg.WsWriteBufferSize,
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		slog.Info("server listening", slog.String("addr", "http://localhost:"+cfg.ServerPort))
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("failed to start server", err)
+		}
+	}()
+	if metricsServer != nil {
+		go func() {
+			slog.Info("metrics listening", slog.String("addr", cfg.MetricsAddr))
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				fatal("failed to start metrics server", err)
+			}
+		}()
 	}
 
-	ginRouter.GET("/ws", func(ctx *gin.Context) {
-		handleWebSocket(ctx, cfg, &wsUpgrader)
-	})
+	<-ctx.Done()
+	stop()
+	healthHandler.SetDraining()
+	slog.Info("shutdown signal received, draining connections...", slog.Duration("timeout", cfg.ShutdownTimeout))
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
 
-	log.Printf("server listening on http://localhost:%s", cfg.ServerPort)
-	if err := ginRouter.Run(":" + cfg.ServerPort); err != nil {
-		log.Fatalf("failed to start server: %v", err)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("error shutting down http server", logger.Err(err))
 	}
+	if err := hub.Shutdown(shutdownCtx, cfg.WsReconnectHint); err != nil {
+		slog.Error("error draining websocket connections", logger.Err(err))
+	}
+	// metrics stay up until the drain finishes so it can be observed.
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("error shutting down metrics server", logger.Err(err))
+		}
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("error flushing traces", logger.Err(err))
+	}
+
+	slog.Info("server stopped")
+}
+
+func fatal(msg string, err error) {
+	slog.Error("FATAL: "+msg, logger.Err(err))
+	os.Exit(1)
 }
@@ -2,14 +2,32 @@ package config
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/password"
+	"github.com/sokolawesome/chat-server/internal/ratelimit"
 )
 
 type Config struct {
+	AppEnv                string
 	ServerPort            string
+	MetricsAddr           string
+	TrustedProxies        []string
+	LogLevel              string
+	ShutdownTimeout       time.Duration
+	HealthCheckTimeout    time.Duration
+	AllowedOrigins        []string
+	CorsAllowMethods      []string
+	CorsAllowHeaders      []string
+	CorsAllowCredentials  bool
+	CorsMaxAge            time.Duration
 	DatabaseURL           string
 	JwtSecret             string
 	JwtExpirationDuration time.Duration
@@ -17,34 +35,243 @@ type Config struct {
 	DbMaxOpenConns        int
 	DbMaxIdleConns        int
 	DbConnMaxLifetime     time.Duration
+	DbMigrateOnStartup    bool
 	BcryptCost            int
+	PasswordHashAlgorithm string
+	Argon2Memory          int
+	Argon2Iterations      int
+	Argon2Parallelism     int
+	PasswordMinLength     int
+	PasswordMaxBytes      int
+	PasswordBannedFile    string
+	PasswordBreachedPath  string
+	PasswordBreachedMin   int
+	UsernameReserved      []string
+	LoginMaxAttemptsUser  int
+	LoginMaxAttemptsIP    int
+	LoginBackoffBase      time.Duration
+	LoginBackoffMax       time.Duration
+	LoginLockoutDuration  time.Duration
+	LoginAttemptWindow    time.Duration
+	LoginAttemptStore     string
 	WsReadBufferSize      int
 	WsWriteBufferSize     int
+	WsSendQueueSize       int
+	WsSlowConsumerPolicy  string
+	WsWriteTimeout        time.Duration
+	WsEnableCompression   bool
+	WsCompressionMinSize  int
+	WsCompressionLevel    int
+	WsReconnectHint       time.Duration
+	WsMessageRate         ratelimit.Rate
+	WsRateLimitStrikes    int
+	RateLimitEnabled      bool
+	RateLimitAuth         ratelimit.Rate
+	RateLimitAPI          ratelimit.Rate
+	RateLimitWsConnect    ratelimit.Rate
+	MailerDriver          string
+	SmtpHost              string
+	SmtpPort              int
+	SmtpUsername          string
+	SmtpPassword          string
+	MailFrom              string
+	PasswordResetURL      string
+	PasswordResetTTL      time.Duration
+	EmailVerifyURL        string
+	EmailVerifyTTL        time.Duration
+	RequireVerifiedEmail  bool
+	TotpIssuer            string
+	APIKeysMaxPerUser     int
+	AvatarMaxUploadBytes  int
+	AvatarBaseURL         string
+	OidcIssuerURL         string
+	OidcClientID          string
+	OidcClientSecret      string
+	OidcRedirectURL       string
+	OidcScopes            []string
+	OidcPostLoginURL      string
+	OidcJITProvisioning   bool
+	TracingExporter       string
+	TracingServiceName    string
 }
 
 func Load() (*Config, error) {
+	appEnv := getEnv("APP_ENV", "development")
+	if appEnv != "development" && appEnv != "production" {
+		return nil, fmt.Errorf("config error: APP_ENV must be 'development' or 'production', got '%s'", appEnv)
+	}
 	serverPort := getEnv("SERVER_PORT", "8080")
+	// metrics are served on their own listener so the public port never
+	// exposes them; an empty address turns the listener off.
+	metricsAddr := getEnv("METRICS_ADDR", ":9090")
+	// X-Forwarded-For is only believed from these addresses; otherwise any
+	// client could pick the IP that login throttling and rate limits see.
+	trustedProxies := getEnvAsSlice("TRUSTED_PROXIES", nil)
+	logLevel := strings.ToUpper(getEnv("LOG_LEVEL", "INFO"))
+	shutdownTimeout, err := time.ParseDuration(getEnv("SHUTDOWN_TIMEOUT", "15s"))
+	if err != nil {
+		slog.Warn("could not parse SHUTDOWN_TIMEOUT, using default", slog.String("value", os.Getenv("SHUTDOWN_TIMEOUT")), slog.String("default", "15s"), logger.Err(err))
+		shutdownTimeout = 15 * time.Second
+	}
+
+	// development trusts the usual local frontend dev servers; production
+	// allows no cross-origin browsers until ALLOWED_ORIGINS is set explicitly.
+	defaultAllowedOrigins := []string{"http://localhost:3000", "http://localhost:5173"}
+	defaultCorsMaxAge := "10m"
+	if appEnv == "production" {
+		defaultAllowedOrigins = nil
+		defaultCorsMaxAge = "12h"
+	}
+	allowedOrigins := getEnvAsSlice("ALLOWED_ORIGINS", defaultAllowedOrigins)
+	corsAllowMethods := getEnvAsSlice("CORS_ALLOW_METHODS", []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"})
+	corsAllowHeaders := getEnvAsSlice("CORS_ALLOW_HEADERS", []string{"Origin", "Content-Length", "Content-Type", "Authorization", "X-Request-ID"})
+	corsAllowCredentials := getEnvAsBool("CORS_ALLOW_CREDENTIALS", true)
+	corsMaxAge, err := time.ParseDuration(getEnv("CORS_MAX_AGE", defaultCorsMaxAge))
+	if err != nil {
+		return nil, fmt.Errorf("config error: could not parse CORS_MAX_AGE '%s': %w", os.Getenv("CORS_MAX_AGE"), err)
+	}
+	healthCheckTimeout, err := time.ParseDuration(getEnv("HEALTH_CHECK_TIMEOUT", "2s"))
+	if err != nil {
+		slog.Warn("could not parse HEALTH_CHECK_TIMEOUT, using default", slog.String("value", os.Getenv("HEALTH_CHECK_TIMEOUT")), slog.String("default", "2s"), logger.Err(err))
+		healthCheckTimeout = 2 * time.Second
+	}
+	tracingExporter := getEnv("TRACING_EXPORTER", "none")
+	tracingServiceName := getEnv("TRACING_SERVICE_NAME", "chat-server")
 	databaseURL := getEnv("DATABASE_URL", "")
 	jwtSecret := getEnv("JWT_SECRET", "")
 	jwtIssuer := getEnv("JWT_ISSUER", "chat-app")
 	jwtExpirationDuration, err := time.ParseDuration(getEnv("JWT_EXPIRATION_DURATION", "1h"))
 	if err != nil {
-		log.Printf("warning: could not parse JWT_EXPIRATION_DURATION '%s', using default 1h: %v", jwtExpirationDuration, err)
+		slog.Warn("could not parse JWT_EXPIRATION_DURATION, using default", slog.String("value", os.Getenv("JWT_EXPIRATION_DURATION")), slog.String("default", "1h"), logger.Err(err))
 		jwtExpirationDuration = time.Hour
 	}
 	dbMaxOpenConns := getEnvAsInt("DB_MAX_OPEN_CONNS", 10)
 	dbMaxIdleConns := getEnvAsInt("DB_MAX_IDLE_CONNS", 10)
 	dbConnMaxLifetime, err := time.ParseDuration(getEnv("DB_CONN_MAX_LIFETIME", "5m"))
 	if err != nil {
-		log.Printf("warning: could not parse DB_CONN_MAX_LIFETIME '%s', using default 5m: %v", dbConnMaxLifetime, err)
+		slog.Warn("could not parse DB_CONN_MAX_LIFETIME, using default", slog.String("value", os.Getenv("DB_CONN_MAX_LIFETIME")), slog.String("default", "5m"), logger.Err(err))
 		dbConnMaxLifetime = 5 * time.Minute
 	}
+	dbMigrateOnStartup := getEnvAsBool("DB_MIGRATE_ON_STARTUP", true)
 	bcryptCost := getEnvAsInt("BCRYPT_COST", 12)
+	passwordHashAlgorithm := getEnv("PASSWORD_HASH_ALGORITHM", "bcrypt")
+	argon2Memory := getEnvAsInt("ARGON2_MEMORY_KIB", 19456)
+	argon2Iterations := getEnvAsInt("ARGON2_ITERATIONS", 2)
+	argon2Parallelism := getEnvAsInt("ARGON2_PARALLELISM", 1)
+	passwordMinLength := getEnvAsInt("PASSWORD_MIN_LENGTH", 8)
+	passwordMaxBytes := getEnvAsInt("PASSWORD_MAX_BYTES", password.BcryptMaxBytes)
+	passwordBannedFile := getEnv("PASSWORD_BANNED_FILE", "")
+	passwordBreachedPath := getEnv("PASSWORD_BREACHED_PATH", "")
+	passwordBreachedMin := getEnvAsInt("PASSWORD_BREACHED_MIN_COUNT", 1)
+	usernameReserved := getEnvAsSlice("USERNAME_RESERVED", nil)
+	loginMaxAttemptsUser := getEnvAsInt("LOGIN_MAX_ATTEMPTS_PER_USER", 5)
+	loginMaxAttemptsIP := getEnvAsInt("LOGIN_MAX_ATTEMPTS_PER_IP", 20)
+	loginBackoffBase, err := time.ParseDuration(getEnv("LOGIN_BACKOFF_BASE", "1s"))
+	if err != nil {
+		slog.Warn("could not parse LOGIN_BACKOFF_BASE, using default", slog.String("value", os.Getenv("LOGIN_BACKOFF_BASE")), slog.String("default", "1s"), logger.Err(err))
+		loginBackoffBase = time.Second
+	}
+	loginBackoffMax, err := time.ParseDuration(getEnv("LOGIN_BACKOFF_MAX", "30s"))
+	if err != nil {
+		slog.Warn("could not parse LOGIN_BACKOFF_MAX, using default", slog.String("value", os.Getenv("LOGIN_BACKOFF_MAX")), slog.String("default", "30s"), logger.Err(err))
+		loginBackoffMax = 30 * time.Second
+	}
+	loginLockoutDuration, err := time.ParseDuration(getEnv("LOGIN_LOCKOUT_DURATION", "15m"))
+	if err != nil {
+		slog.Warn("could not parse LOGIN_LOCKOUT_DURATION, using default", slog.String("value", os.Getenv("LOGIN_LOCKOUT_DURATION")), slog.String("default", "15m"), logger.Err(err))
+		loginLockoutDuration = 15 * time.Minute
+	}
+	loginAttemptWindow, err := time.ParseDuration(getEnv("LOGIN_ATTEMPT_WINDOW", "15m"))
+	if err != nil {
+		slog.Warn("could not parse LOGIN_ATTEMPT_WINDOW, using default", slog.String("value", os.Getenv("LOGIN_ATTEMPT_WINDOW")), slog.String("default", "15m"), logger.Err(err))
+		loginAttemptWindow = 15 * time.Minute
+	}
+	loginAttemptStore := getEnv("LOGIN_ATTEMPT_STORE", "postgres")
 	wsReadBufferSize := getEnvAsInt("WS_READ_BUFFER_SIZE", 1024)
 	wsWriteBufferSize := getEnvAsInt("WS_WRITE_BUFFER_SIZE", 1024)
+	wsSendQueueSize := getEnvAsInt("WS_SEND_QUEUE_SIZE", 256)
+	wsSlowConsumerPolicy := getEnv("WS_SLOW_CONSUMER_POLICY", "drop_oldest")
+	wsWriteTimeout, err := time.ParseDuration(getEnv("WS_WRITE_TIMEOUT", "10s"))
+	if err != nil {
+		slog.Warn("could not parse WS_WRITE_TIMEOUT, using default", slog.String("value", os.Getenv("WS_WRITE_TIMEOUT")), slog.String("default", "10s"), logger.Err(err))
+		wsWriteTimeout = 10 * time.Second
+	}
+	wsEnableCompression := getEnvAsBool("WS_ENABLE_COMPRESSION", true)
+	wsCompressionMinSize := getEnvAsInt("WS_COMPRESSION_MIN_SIZE", 512)
+	wsCompressionLevel := getEnvAsInt("WS_COMPRESSION_LEVEL", 1)
+	wsReconnectHint, err := time.ParseDuration(getEnv("WS_RECONNECT_HINT", "5s"))
+	if err != nil {
+		slog.Warn("could not parse WS_RECONNECT_HINT, using default", slog.String("value", os.Getenv("WS_RECONNECT_HINT")), slog.String("default", "5s"), logger.Err(err))
+		wsReconnectHint = 5 * time.Second
+	}
+	wsMessageRate, err := ratelimit.ParseRate(getEnv("WS_MESSAGE_RATE", "20/1s"))
+	if err != nil {
+		return nil, fmt.Errorf("config error: WS_MESSAGE_RATE: %w", err)
+	}
+	wsRateLimitStrikes := getEnvAsInt("WS_RATE_LIMIT_STRIKES", 10)
+	rateLimitEnabled := getEnvAsBool("RATE_LIMIT_ENABLED", true)
+	rateLimitAuth, err := ratelimit.ParseRate(getEnv("RATE_LIMIT_AUTH", "10/1m"))
+	if err != nil {
+		return nil, fmt.Errorf("config error: RATE_LIMIT_AUTH: %w", err)
+	}
+	rateLimitAPI, err := ratelimit.ParseRate(getEnv("RATE_LIMIT_API", "120/1m"))
+	if err != nil {
+		return nil, fmt.Errorf("config error: RATE_LIMIT_API: %w", err)
+	}
+	rateLimitWsConnect, err := ratelimit.ParseRate(getEnv("RATE_LIMIT_WS_CONNECT", "20/1m"))
+	if err != nil {
+		return nil, fmt.Errorf("config error: RATE_LIMIT_WS_CONNECT: %w", err)
+	}
+	// the log driver writes reset and verification links to the logs, so
+	// production has to send real mail.
+	defaultMailerDriver := "log"
+	if appEnv == "production" {
+		defaultMailerDriver = "smtp"
+	}
+	mailerDriver := getEnv("MAILER_DRIVER", defaultMailerDriver)
+	smtpHost := getEnv("SMTP_HOST", "")
+	smtpPort := getEnvAsInt("SMTP_PORT", 587)
+	smtpUsername := getEnv("SMTP_USERNAME", "")
+	smtpPassword := getEnv("SMTP_PASSWORD", "")
+	mailFrom := getEnv("MAIL_FROM", "no-reply@localhost")
+	passwordResetURL := getEnv("PASSWORD_RESET_URL", "http://localhost:5173/reset-password")
+	passwordResetTTL, err := time.ParseDuration(getEnv("PASSWORD_RESET_TTL", "1h"))
+	if err != nil {
+		slog.Warn("could not parse PASSWORD_RESET_TTL, using default", slog.String("value", os.Getenv("PASSWORD_RESET_TTL")), slog.String("default", "1h"), logger.Err(err))
+		passwordResetTTL = time.Hour
+	}
+	emailVerifyURL := getEnv("EMAIL_VERIFY_URL", "http://localhost:5173/verify-email")
+	emailVerifyTTL, err := time.ParseDuration(getEnv("EMAIL_VERIFY_TTL", "48h"))
+	if err != nil {
+		slog.Warn("could not parse EMAIL_VERIFY_TTL, using default", slog.String("value", os.Getenv("EMAIL_VERIFY_TTL")), slog.String("default", "48h"), logger.Err(err))
+		emailVerifyTTL = 48 * time.Hour
+	}
+	requireVerifiedEmail := getEnvAsBool("REQUIRE_VERIFIED_EMAIL", false)
+	totpIssuer := getEnv("TOTP_ISSUER", "Chat Server")
+	apiKeysMaxPerUser := getEnvAsInt("API_KEYS_MAX_PER_USER", 25)
+	avatarMaxUploadBytes := getEnvAsInt("AVATAR_MAX_UPLOAD_BYTES", 5<<20)
+	avatarBaseURL := strings.TrimSuffix(getEnv("AVATAR_BASE_URL", ""), "/")
+	oidcIssuerURL := getEnv("OIDC_ISSUER_URL", "")
+	oidcClientID := getEnv("OIDC_CLIENT_ID", "")
+	oidcClientSecret := getEnv("OIDC_CLIENT_SECRET", "")
+	oidcRedirectURL := getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/api/auth/oidc/callback")
+	oidcScopes := getEnvAsSlice("OIDC_SCOPES", []string{"openid", "email", "profile"})
+	oidcPostLoginURL := getEnv("OIDC_POST_LOGIN_URL", "http://localhost:5173/login/sso")
+	oidcJITProvisioning := getEnvAsBool("OIDC_JIT_PROVISIONING", true)
 
 	cfg := &Config{
+		AppEnv:                appEnv,
 		ServerPort:            serverPort,
+		MetricsAddr:           metricsAddr,
+		TrustedProxies:        trustedProxies,
+		LogLevel:              logLevel,
+		ShutdownTimeout:       shutdownTimeout,
+		HealthCheckTimeout:    healthCheckTimeout,
+		AllowedOrigins:        allowedOrigins,
+		CorsAllowMethods:      corsAllowMethods,
+		CorsAllowHeaders:      corsAllowHeaders,
+		CorsAllowCredentials:  corsAllowCredentials,
+		CorsMaxAge:            corsMaxAge,
 		DatabaseURL:           databaseURL,
 		JwtSecret:             jwtSecret,
 		JwtExpirationDuration: jwtExpirationDuration,
@@ -52,22 +279,169 @@ func Load() (*Config, error) {
 		DbMaxOpenConns:        dbMaxOpenConns,
 		DbMaxIdleConns:        dbMaxIdleConns,
 		DbConnMaxLifetime:     dbConnMaxLifetime,
+		DbMigrateOnStartup:    dbMigrateOnStartup,
 		BcryptCost:            bcryptCost,
+		PasswordHashAlgorithm: passwordHashAlgorithm,
+		Argon2Memory:          argon2Memory,
+		Argon2Iterations:      argon2Iterations,
+		Argon2Parallelism:     argon2Parallelism,
+		PasswordMinLength:     passwordMinLength,
+		PasswordMaxBytes:      passwordMaxBytes,
+		PasswordBannedFile:    passwordBannedFile,
+		PasswordBreachedPath:  passwordBreachedPath,
+		PasswordBreachedMin:   passwordBreachedMin,
+		UsernameReserved:      usernameReserved,
+		LoginMaxAttemptsUser:  loginMaxAttemptsUser,
+		LoginMaxAttemptsIP:    loginMaxAttemptsIP,
+		LoginBackoffBase:      loginBackoffBase,
+		LoginBackoffMax:       loginBackoffMax,
+		LoginLockoutDuration:  loginLockoutDuration,
+		LoginAttemptWindow:    loginAttemptWindow,
+		LoginAttemptStore:     loginAttemptStore,
 		WsReadBufferSize:      wsReadBufferSize,
 		WsWriteBufferSize:     wsWriteBufferSize,
+		WsSendQueueSize:       wsSendQueueSize,
+		WsSlowConsumerPolicy:  wsSlowConsumerPolicy,
+		WsWriteTimeout:        wsWriteTimeout,
+		WsEnableCompression:   wsEnableCompression,
+		WsCompressionMinSize:  wsCompressionMinSize,
+		WsCompressionLevel:    wsCompressionLevel,
+		WsReconnectHint:       wsReconnectHint,
+		WsMessageRate:         wsMessageRate,
+		WsRateLimitStrikes:    wsRateLimitStrikes,
+		RateLimitEnabled:      rateLimitEnabled,
+		RateLimitAuth:         rateLimitAuth,
+		RateLimitAPI:          rateLimitAPI,
+		RateLimitWsConnect:    rateLimitWsConnect,
+		MailerDriver:          mailerDriver,
+		SmtpHost:              smtpHost,
+		SmtpPort:              smtpPort,
+		SmtpUsername:          smtpUsername,
+		SmtpPassword:          smtpPassword,
+		MailFrom:              mailFrom,
+		PasswordResetURL:      passwordResetURL,
+		PasswordResetTTL:      passwordResetTTL,
+		EmailVerifyURL:        emailVerifyURL,
+		EmailVerifyTTL:        emailVerifyTTL,
+		RequireVerifiedEmail:  requireVerifiedEmail,
+		TotpIssuer:            totpIssuer,
+		APIKeysMaxPerUser:     apiKeysMaxPerUser,
+		AvatarMaxUploadBytes:  avatarMaxUploadBytes,
+		AvatarBaseURL:         avatarBaseURL,
+		OidcIssuerURL:         oidcIssuerURL,
+		OidcClientID:          oidcClientID,
+		OidcClientSecret:      oidcClientSecret,
+		OidcRedirectURL:       oidcRedirectURL,
+		OidcScopes:            oidcScopes,
+		OidcPostLoginURL:      oidcPostLoginURL,
+		OidcJITProvisioning:   oidcJITProvisioning,
+		TracingExporter:       tracingExporter,
+		TracingServiceName:    tracingServiceName,
 	}
 
+	if !slices.Contains([]string{"DEBUG", "INFO", "WARN", "ERROR"}, cfg.LogLevel) {
+		return nil, fmt.Errorf("config error: LOG_LEVEL must be one of DEBUG, INFO, WARN, ERROR, got '%s'", cfg.LogLevel)
+	}
+	if cfg.MetricsAddr != "" {
+		_, metricsPort, err := net.SplitHostPort(cfg.MetricsAddr)
+		if err != nil {
+			return nil, fmt.Errorf("config error: METRICS_ADDR must be host:port or :port, got '%s'", cfg.MetricsAddr)
+		}
+		if metricsPort == cfg.ServerPort {
+			return nil, fmt.Errorf("config error: METRICS_ADDR must not use SERVER_PORT (%s)", cfg.ServerPort)
+		}
+	}
+	for _, proxy := range cfg.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return nil, fmt.Errorf("config error: TRUSTED_PROXIES entries must be IP addresses or CIDR ranges, got '%s'", proxy)
+		}
+	}
 	if cfg.DatabaseURL == "" {
 		return nil, fmt.Errorf("config error: DATABASE_URL environment variable is required")
 	}
 	if cfg.JwtSecret == "" {
 		return nil, fmt.Errorf("config error: JWT_SECRET environment variable is required")
 	}
+	if cfg.TracingExporter != "none" && cfg.TracingExporter != "stdout" && cfg.TracingExporter != "otlp" {
+		return nil, fmt.Errorf("config error: TRACING_EXPORTER must be 'none', 'stdout' or 'otlp', got '%s'", cfg.TracingExporter)
+	}
 	if cfg.BcryptCost < 4 || cfg.BcryptCost > 31 {
 		return nil, fmt.Errorf("config error: BCRYPT_COST must be between 4 and 31, got %d", cfg.BcryptCost)
 	}
 
-	log.Println("[Config] Loaded successfully.")
+	if cfg.PasswordHashAlgorithm != "bcrypt" && cfg.PasswordHashAlgorithm != "argon2id" {
+		return nil, fmt.Errorf("config error: PASSWORD_HASH_ALGORITHM must be 'bcrypt' or 'argon2id', got '%s'", cfg.PasswordHashAlgorithm)
+	}
+	if cfg.Argon2Memory < 8*cfg.Argon2Parallelism || cfg.Argon2Iterations < 1 || cfg.Argon2Parallelism < 1 || cfg.Argon2Parallelism > 255 {
+		return nil, fmt.Errorf("config error: ARGON2_ITERATIONS and ARGON2_PARALLELISM (1-255) must be positive and ARGON2_MEMORY_KIB at least 8 per lane")
+	}
+
+	if cfg.PasswordMinLength < 1 || cfg.PasswordMinLength > cfg.PasswordMaxBytes {
+		return nil, fmt.Errorf("config error: PASSWORD_MIN_LENGTH must be between 1 and PASSWORD_MAX_BYTES (%d), got %d", cfg.PasswordMaxBytes, cfg.PasswordMinLength)
+	}
+	// bcrypt cannot hash more than 72 bytes, so longer passwords would be
+	// accepted by the policy and then fail or be only partly checked.
+	if cfg.PasswordHashAlgorithm == "bcrypt" && cfg.PasswordMaxBytes > password.BcryptMaxBytes {
+		return nil, fmt.Errorf("config error: PASSWORD_MAX_BYTES must not exceed %d with bcrypt, got %d", password.BcryptMaxBytes, cfg.PasswordMaxBytes)
+	}
+	if cfg.PasswordBreachedMin < 1 {
+		return nil, fmt.Errorf("config error: PASSWORD_BREACHED_MIN_COUNT must be positive, got %d", cfg.PasswordBreachedMin)
+	}
+	if cfg.LoginMaxAttemptsUser < 1 || cfg.LoginMaxAttemptsIP < 1 {
+		return nil, fmt.Errorf("config error: LOGIN_MAX_ATTEMPTS_PER_USER and LOGIN_MAX_ATTEMPTS_PER_IP must be positive")
+	}
+	if cfg.LoginBackoffBase < 0 || cfg.LoginBackoffMax < cfg.LoginBackoffBase {
+		return nil, fmt.Errorf("config error: LOGIN_BACKOFF_MAX (%s) must not be less than LOGIN_BACKOFF_BASE (%s)", cfg.LoginBackoffMax, cfg.LoginBackoffBase)
+	}
+	if cfg.LoginAttemptStore != "memory" && cfg.LoginAttemptStore != "postgres" {
+		return nil, fmt.Errorf("config error: LOGIN_ATTEMPT_STORE must be 'memory' or 'postgres', got '%s'", cfg.LoginAttemptStore)
+	}
+	if cfg.MailerDriver != "log" && cfg.MailerDriver != "smtp" {
+		return nil, fmt.Errorf("config error: MAILER_DRIVER must be 'log' or 'smtp', got '%s'", cfg.MailerDriver)
+	}
+	if cfg.MailerDriver == "log" && cfg.AppEnv == "production" {
+		return nil, fmt.Errorf("config error: MAILER_DRIVER 'log' is not allowed in production, since it logs password reset links")
+	}
+	if cfg.MailerDriver == "smtp" && cfg.SmtpHost == "" {
+		return nil, fmt.Errorf("config error: SMTP_HOST is required when MAILER_DRIVER is 'smtp'")
+	}
+	if cfg.APIKeysMaxPerUser <= 0 {
+		return nil, fmt.Errorf("config error: API_KEYS_MAX_PER_USER must be positive, got %d", cfg.APIKeysMaxPerUser)
+	}
+	if cfg.AvatarMaxUploadBytes <= 0 {
+		return nil, fmt.Errorf("config error: AVATAR_MAX_UPLOAD_BYTES must be positive, got %d", cfg.AvatarMaxUploadBytes)
+	}
+
+	if cfg.OidcIssuerURL != "" && cfg.OidcClientID == "" {
+		return nil, fmt.Errorf("config error: OIDC_CLIENT_ID is required when OIDC_ISSUER_URL is set")
+	}
+	if cfg.OidcIssuerURL != "" && !slices.Contains(cfg.OidcScopes, "openid") {
+		return nil, fmt.Errorf("config error: OIDC_SCOPES must include 'openid'")
+	}
+
+	if cfg.CorsAllowCredentials && slices.Contains(cfg.AllowedOrigins, "*") {
+		return nil, fmt.Errorf("config error: ALLOWED_ORIGINS must not contain '*' while CORS_ALLOW_CREDENTIALS is enabled")
+	}
+	if len(cfg.CorsAllowMethods) == 0 {
+		return nil, fmt.Errorf("config error: CORS_ALLOW_METHODS must not be empty")
+	}
+	if cfg.CorsMaxAge < 0 {
+		return nil, fmt.Errorf("config error: CORS_MAX_AGE must not be negative, got %s", cfg.CorsMaxAge)
+	}
+	if cfg.WsSendQueueSize < 1 {
+		return nil, fmt.Errorf("config error: WS_SEND_QUEUE_SIZE must be positive, got %d", cfg.WsSendQueueSize)
+	}
+	if cfg.WsSlowConsumerPolicy != "drop_oldest" && cfg.WsSlowConsumerPolicy != "disconnect" {
+		return nil, fmt.Errorf("config error: WS_SLOW_CONSUMER_POLICY must be 'drop_oldest' or 'disconnect', got '%s'", cfg.WsSlowConsumerPolicy)
+	}
+	if cfg.WsRateLimitStrikes < 0 {
+		return nil, fmt.Errorf("config error: WS_RATE_LIMIT_STRIKES must not be negative, got %d", cfg.WsRateLimitStrikes)
+	}
+	if cfg.WsCompressionLevel < -2 || cfg.WsCompressionLevel > 9 {
+		return nil, fmt.Errorf("config error: WS_COMPRESSION_LEVEL must be between -2 and 9, got %d", cfg.WsCompressionLevel)
+	}
+
+	slog.Info("config loaded successfully")
 
 	return cfg, nil
 }
@@ -87,3 +461,25 @@ func getEnvAsInt(key string, fallback int) int {
 	}
 	return fallback
 }
+
+func getEnvAsBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if value, err := strconv.ParseBool(value); err == nil {
+			return value
+		}
+	}
+	return fallback
+}
+
+func getEnvAsSlice(key string, fallback []string) []string {
+	if value, ok := os.LookupEnv(key); ok {
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		return values
+	}
+	return fallback
+}
@@ -0,0 +1,115 @@
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error is the single error shape returned by the HTTP API. Code is a stable,
+// machine-readable identifier such as "auth.token_expired"; Message is meant
+// for humans and may change.
+type Error struct {
+	Status  int          `json:"-"`
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+
+	cause error
+}
+
+// FieldError describes why a single request field failed validation.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func New(status int, code string, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is matches any *Error with the same code, so errors.Is(err, ErrTokenExpired)
+// holds for copies created by Wrap or WithDetails.
+func (e *Error) Is(target error) bool {
+	var t *Error
+	return errors.As(target, &t) && t.Code == e.Code
+}
+
+// Wrap returns a copy of e that records cause for logging without exposing it
+// to the client.
+func (e *Error) Wrap(cause error) *Error {
+	clone := *e
+	clone.cause = cause
+	return &clone
+}
+
+func (e *Error) WithDetails(details ...FieldError) *Error {
+	clone := *e
+	clone.Details = details
+	return &clone
+}
+
+var (
+	ErrInvalidBody      = New(http.StatusBadRequest, "request.invalid_body", "request body is malformed")
+	ErrValidationFailed = New(http.StatusBadRequest, "request.validation_failed", "request validation failed")
+
+	ErrMissingToken       = New(http.StatusUnauthorized, "auth.missing_token", "authentication token is not provided")
+	ErrMalformedHeader    = New(http.StatusUnauthorized, "auth.malformed_header", "authorization header format is invalid")
+	ErrUnsupportedAuth    = New(http.StatusUnauthorized, "auth.unsupported_type", "authorization type is not supported")
+	ErrTokenExpired       = New(http.StatusUnauthorized, "auth.token_expired", "token has expired")
+	ErrInvalidToken       = New(http.StatusUnauthorized, "auth.invalid_token", "token is invalid")
+	ErrInvalidCredentials = New(http.StatusUnauthorized, "auth.invalid_credentials", "invalid username or password")
+	ErrTooManyAttempts    = New(http.StatusTooManyRequests, "auth.too_many_attempts", "too many failed login attempts, try again later")
+	ErrTokenRevoked       = New(http.StatusUnauthorized, "auth.token_revoked", "token has been revoked")
+	ErrWrongPassword      = New(http.StatusForbidden, "auth.wrong_password", "current password is incorrect")
+	ErrResetTokenInvalid  = New(http.StatusBadRequest, "auth.reset_token_invalid", "password reset token is invalid or has expired")
+	ErrMFATokenInvalid    = New(http.StatusUnauthorized, "auth.mfa_token_invalid", "mfa challenge is invalid or has expired")
+	ErrInvalidMFACode     = New(http.StatusUnauthorized, "auth.invalid_mfa_code", "authentication code is invalid")
+	ErrSSOFlowInvalid     = New(http.StatusBadRequest, "auth.sso_flow_invalid", "single sign-on request is invalid or has expired, please start again")
+	ErrSSODenied          = New(http.StatusUnauthorized, "auth.sso_denied", "identity provider did not authorize the sign-in")
+	ErrSSOFailed          = New(http.StatusUnauthorized, "auth.sso_failed", "single sign-on could not be completed")
+	ErrSSONoAccount       = New(http.StatusForbidden, "auth.sso_no_account", "no account is linked to this identity")
+	ErrSSOUnavailable     = New(http.StatusBadGateway, "auth.sso_unavailable", "identity provider is unavailable")
+	ErrInvalidAPIKey      = New(http.StatusUnauthorized, "auth.invalid_api_key", "api key is invalid or has been revoked")
+	ErrAPIKeyExpired      = New(http.StatusUnauthorized, "auth.api_key_expired", "api key has expired")
+	ErrInsufficientScope  = New(http.StatusForbidden, "auth.insufficient_scope", "api key does not grant access to this operation")
+	ErrSessionRequired    = New(http.StatusForbidden, "auth.session_required", "this operation cannot be performed with an api key")
+	ErrVerifyTokenInvalid = New(http.StatusBadRequest, "auth.verification_token_invalid", "email verification token is invalid or has expired")
+
+	ErrUserNotFound     = New(http.StatusNotFound, "user.not_found", "user not found")
+	ErrUsernameTaken    = New(http.StatusConflict, "user.username_taken", "username is already taken")
+	ErrEmailTaken       = New(http.StatusConflict, "user.email_taken", "email is already in use")
+	ErrNoEmail          = New(http.StatusBadRequest, "user.no_email", "account has no email address")
+	ErrEmailNotVerified = New(http.StatusForbidden, "user.email_not_verified", "email address must be verified first")
+	ErrWeakPassword     = New(http.StatusBadRequest, "user.weak_password", "password does not meet the password policy")
+	ErrInvalidUsername  = New(http.StatusBadRequest, "user.invalid_username", "username does not meet the username policy")
+
+	ErrMFAAlreadyEnabled = New(http.StatusConflict, "mfa.already_enabled", "two-factor authentication is already enabled")
+	ErrMFANotPending     = New(http.StatusConflict, "mfa.not_pending", "no two-factor enrollment is waiting for confirmation")
+
+	ErrAvatarNotFound = New(http.StatusNotFound, "avatar.not_found", "avatar not found")
+	ErrInvalidAvatar  = New(http.StatusBadRequest, "avatar.invalid", "avatar must be a PNG, JPEG, GIF or WebP image of at most 4096x4096 pixels")
+	ErrAvatarTooLarge = New(http.StatusRequestEntityTooLarge, "avatar.too_large", "avatar file is too large")
+
+	ErrSessionNotFound = New(http.StatusNotFound, "session.not_found", "session not found")
+
+	ErrAPIKeyNotFound = New(http.StatusNotFound, "api_key.not_found", "api key not found")
+	ErrAPIKeyLimit    = New(http.StatusConflict, "api_key.limit_reached", "maximum number of api keys reached, revoke one first")
+
+	ErrRateLimited = New(http.StatusTooManyRequests, "request.rate_limited", "too many requests, slow down")
+
+	ErrDraining = New(http.StatusServiceUnavailable, "server.draining", "server is shutting down")
+	ErrInternal = New(http.StatusInternalServerError, "server.internal", "internal server error")
+)
@@ -0,0 +1,146 @@
+package apierror
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+type signupRequest struct {
+	Username string `json:"username" binding:"required,min=3,max=20"`
+	Email    string `json:"email" binding:"omitempty,email"`
+	Age      int    `json:"age"`
+	Role     string `json:"role" binding:"omitempty,oneof=member admin"`
+	Nickname string `binding:"omitempty,alphanum"`
+}
+
+func bind(body string) error {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	var req signupRequest
+	return binding.JSON.Bind(r, &req)
+}
+
+func TestFromBinding(t *testing.T) {
+	UseJSONFieldNames()
+
+	tests := []struct {
+		name    string
+		body    string
+		want    *Error
+		details []FieldError
+	}{
+		{
+			name:    "required",
+			body:    `{}`,
+			want:    ErrValidationFailed,
+			details: []FieldError{{Field: "username", Rule: "required", Message: "is required"}},
+		},
+		{
+			name:    "min",
+			body:    `{"username":"al"}`,
+			want:    ErrValidationFailed,
+			details: []FieldError{{Field: "username", Rule: "min", Message: "must be at least 3 characters long"}},
+		},
+		{
+			name:    "max",
+			body:    `{"username":"` + strings.Repeat("a", 21) + `"}`,
+			want:    ErrValidationFailed,
+			details: []FieldError{{Field: "username", Rule: "max", Message: "must be at most 20 characters long"}},
+		},
+		{
+			name:    "email",
+			body:    `{"username":"alice","email":"alice.example.com"}`,
+			want:    ErrValidationFailed,
+			details: []FieldError{{Field: "email", Rule: "email", Message: "must be a valid email address"}},
+		},
+		{
+			name:    "oneof",
+			body:    `{"username":"alice","role":"owner"}`,
+			want:    ErrValidationFailed,
+			details: []FieldError{{Field: "role", Rule: "oneof", Message: "must be one of: member admin"}},
+		},
+		{
+			name:    "other rule without a json tag",
+			body:    `{"username":"alice","Nickname":"a b"}`,
+			want:    ErrValidationFailed,
+			details: []FieldError{{Field: "Nickname", Rule: "alphanum", Message: "failed the 'alphanum' rule"}},
+		},
+		{
+			name: "every failing field",
+			body: `{"email":"nope","role":"owner"}`,
+			want: ErrValidationFailed,
+			details: []FieldError{
+				{Field: "username", Rule: "required", Message: "is required"},
+				{Field: "email", Rule: "email", Message: "must be a valid email address"},
+				{Field: "role", Rule: "oneof", Message: "must be one of: member admin"},
+			},
+		},
+		{
+			name:    "wrong json type",
+			body:    `{"username":"alice","age":"ten"}`,
+			want:    ErrValidationFailed,
+			details: []FieldError{{Field: "age", Rule: "type", Message: "must be of type int"}},
+		},
+		{
+			name: "json syntax error",
+			body: `{"username":"alice",}`,
+			want: ErrInvalidBody,
+		},
+		{
+			name: "truncated json",
+			body: `{"username":`,
+			want: ErrInvalidBody,
+		},
+		{
+			name: "empty body",
+			body: ``,
+			want: ErrInvalidBody,
+		},
+		{
+			name:    "object in a number field",
+			body:    `{"username":"alice","age":{"years":30}}`,
+			want:    ErrValidationFailed,
+			details: []FieldError{{Field: "age", Rule: "type", Message: "must be of type int"}},
+		},
+		{
+			name: "not an object",
+			body: `["alice"]`,
+			want: ErrInvalidBody,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bindErr := bind(tt.body)
+			if bindErr == nil {
+				t.Fatal("binding succeeded, want an error")
+			}
+
+			got := FromBinding(bindErr)
+			if !errors.Is(got, tt.want) {
+				t.Fatalf("FromBinding = %v, want %s", got, tt.want.Code)
+			}
+			if got.Status != tt.want.Status {
+				t.Fatalf("status = %d, want %d", got.Status, tt.want.Status)
+			}
+			if !reflect.DeepEqual(got.Details, tt.details) {
+				t.Fatalf("details = %+v, want %+v", got.Details, tt.details)
+			}
+			if got.Unwrap() == nil {
+				t.Fatal("FromBinding dropped the binding error")
+			}
+		})
+	}
+}
+
+func TestFromBindingValidRequest(t *testing.T) {
+	UseJSONFieldNames()
+
+	if err := bind(`{"username":"alice","email":"alice@example.com","age":30,"role":"admin"}`); err != nil {
+		t.Fatalf("binding a valid request: %v", err)
+	}
+}
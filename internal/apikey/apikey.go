@@ -0,0 +1,63 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Keys look like "chk_<prefix>_<secret>". The "chk_" marker lets callers
+// tell a key from a JWT without parsing it, and the short prefix is stored
+// in clear so users can recognise a key in listings.
+const (
+	marker      = "chk_"
+	prefixBytes = 6
+	secretBytes = 32
+)
+
+// Scopes a key can be granted. Keys never reach account-management
+// endpoints (password, MFA, API keys) regardless of scope.
+const (
+	ScopeRead      = "api:read"
+	ScopeWrite     = "api:write"
+	ScopeWebSocket = "ws"
+)
+
+var Scopes = []string{ScopeRead, ScopeWrite, ScopeWebSocket}
+
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// Generate returns a new key, its display prefix and the hash to store.
+// The key itself is shown to the user once and never persisted.
+func Generate() (key string, prefix string, hash string, err error) {
+	prefixRaw := make([]byte, prefixBytes)
+	if _, err := rand.Read(prefixRaw); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	secret := make([]byte, secretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	prefix = marker + hex.EncodeToString(prefixRaw)
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, Hash(key), nil
+}
+
+// Hash returns the hex SHA-256 of key. Keys carry 256 bits of entropy, so
+// a fast hash is enough to make a leaked table useless.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsKey reports whether token looks like an API key rather than a JWT.
+func IsKey(token string) bool {
+	return strings.HasPrefix(token, marker)
+}
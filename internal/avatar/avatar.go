@@ -0,0 +1,78 @@
+package avatar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"slices"
+
+	// decoders for the accepted upload formats.
+	_ "image/gif"
+	_ "image/jpeg"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// Sizes are the square edge lengths, in pixels, every avatar is stored at.
+var Sizes = []int{64, 128, 256}
+
+// ContentType is the format every stored size is encoded in.
+const ContentType = "image/png"
+
+// MaxDimension caps the width and height of an upload. It is checked from the
+// header before any pixels are decoded, so a small file cannot expand into a
+// huge bitmap.
+const MaxDimension = 4096
+
+var (
+	ErrUnsupportedFormat = errors.New("avatar must be a PNG, JPEG, GIF or WebP image")
+	ErrTooLarge          = fmt.Errorf("avatar must be at most %dx%d pixels", MaxDimension, MaxDimension)
+)
+
+// Process decodes an uploaded image, crops it to a centered square and
+// returns it re-encoded at each of Sizes, keyed by size. Re-encoding also
+// strips metadata such as EXIF location that the original might carry.
+func Process(data []byte) (map[int][]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	if config.Width > MaxDimension || config.Height > MaxDimension {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	square := centerSquare(src.Bounds())
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+
+	images := make(map[int][]byte, len(Sizes))
+	for _, size := range Sizes {
+		dst := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.CatmullRom.Scale(dst, dst.Bounds(), src, square, draw.Src, nil)
+
+		var buf bytes.Buffer
+		if err := encoder.Encode(&buf, dst); err != nil {
+			return nil, fmt.Errorf("avatar: failed to encode %dpx image: %w", size, err)
+		}
+		images[size] = buf.Bytes()
+	}
+	return images, nil
+}
+
+// ValidSize reports whether size is one of Sizes.
+func ValidSize(size int) bool {
+	return slices.Contains(Sizes, size)
+}
+
+func centerSquare(r image.Rectangle) image.Rectangle {
+	side := min(r.Dx(), r.Dy())
+	x := r.Min.X + (r.Dx()-side)/2
+	y := r.Min.Y + (r.Dy()-side)/2
+	return image.Rect(x, y, x+side, y+side)
+}
@@ -3,14 +3,15 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/sokolawesome/chat-server/config"
+	"github.com/sokolawesome/chat-server/internal/logger"
 )
 
 func Connect(cfg *config.Config) (*sql.DB, error) {
-	log.Println("connecting to database...")
+	slog.Info("connecting to database...")
 
 	db, err := sql.Open("pgx", cfg.DatabaseURL)
 	if err != nil {
@@ -23,11 +24,11 @@ func Connect(cfg *config.Config) (*sql.DB, error) {
 
 	if err = db.Ping(); err != nil {
 		if closeErr := db.Close(); closeErr != nil {
-			log.Printf("database.Connect: error closing connection after ping failed: %v", closeErr)
+			slog.Error("database.Connect: error closing connection after ping failed", logger.Err(closeErr))
 		}
 		return nil, fmt.Errorf("database.Connect: failed to ping database: %w", err)
 	}
 
-	log.Println("database connection established successfully")
+	slog.Info("database connection established successfully")
 	return db, nil
 }
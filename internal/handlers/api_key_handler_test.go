@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/middleware"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/repository"
+)
+
+type fakeAPIKeyRepository struct {
+	repository.APIKeyRepository
+	created []string
+}
+
+func (r *fakeAPIKeyRepository) CountAPIKeys(ctx context.Context, userID int64) (int, error) {
+	return 0, nil
+}
+
+func (r *fakeAPIKeyRepository) CreateAPIKey(ctx context.Context, userID int64, name string, prefix string, keyHash string, scopes []string, expiresAt *time.Time) (*models.APIKey, error) {
+	r.created = scopes
+	return &models.APIKey{ID: 1, UserID: userID, Name: name, Prefix: prefix, Scopes: scopes}, nil
+}
+
+func TestCreateAPIKeyScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  string
+		want    []string
+		details []apierror.FieldError
+	}{
+		{name: "known scopes", scopes: `["api:read","ws","api:read"]`, want: []string{"api:read", "ws"}},
+		{
+			name:   "unknown scopes",
+			scopes: `["api:read","admin","api:*"]`,
+			details: []apierror.FieldError{
+				{Field: "scopes[1]", Rule: "oneof", Message: "must be one of: api:read api:write ws"},
+				{Field: "scopes[2]", Rule: "oneof", Message: "must be one of: api:read api:write ws"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			repo := &fakeAPIKeyRepository{}
+			h := NewAPIKeyHandler(repo, nil, 25)
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler())
+			router.POST("/api-keys", func(ctx *gin.Context) {
+				ctx.Set(middleware.AuthorizationPayloadKey, int64(7))
+			}, h.Create)
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api-keys", strings.NewReader(`{"name":"bot","scopes":`+tt.scopes+`}`))
+			request.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(recorder, request)
+
+			if tt.details == nil {
+				if recorder.Code != http.StatusCreated {
+					t.Fatalf("create returned %d: %s", recorder.Code, recorder.Body)
+				}
+				if !reflect.DeepEqual(repo.created, tt.want) {
+					t.Fatalf("stored scopes = %v, want %v", repo.created, tt.want)
+				}
+				return
+			}
+
+			assertAPIError(t, recorder, apierror.ErrValidationFailed)
+			var body struct {
+				Error struct {
+					Details []apierror.FieldError `json:"details"`
+				} `json:"error"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+				t.Fatalf("decoding %s: %v", recorder.Body, err)
+			}
+			if !reflect.DeepEqual(body.Error.Details, tt.details) {
+				t.Fatalf("details = %+v, want %+v", body.Error.Details, tt.details)
+			}
+			if repo.created != nil {
+				t.Fatal("a key with unknown scopes was stored")
+			}
+		})
+	}
+}
@@ -1,73 +1,145 @@
 package handlers
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/emailverify"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/loginguard"
+	"github.com/sokolawesome/chat-server/internal/metrics"
+	"github.com/sokolawesome/chat-server/internal/middleware"
 	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/password"
 	"github.com/sokolawesome/chat-server/internal/repository"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/sokolawesome/chat-server/internal/username"
+	"github.com/sokolawesome/chat-server/internal/ws"
+)
+
+// mfaChallengeTTL is how long a user has to enter their second factor
+// after the password was accepted.
+const (
+	mfaChallengeTTL = 5 * time.Minute
+	// mfaChallengeMaxAttempts caps code guesses per challenge; after that
+	// the user has to enter their password again.
+	mfaChallengeMaxAttempts = 5
 )
 
 type AuthHandler struct {
 	UserRepository        repository.UserRepository
+	MFARepository         repository.MFARepository
+	SessionRepository     repository.SessionRepository
+	Hub                   *ws.Hub
+	PasswordHasher        password.Hasher
+	PasswordPolicy        *password.Policy
+	UsernamePolicy        *username.Policy
+	LoginGuard            *loginguard.Guard
+	EmailVerifier         *emailverify.Verifier
+	RequireVerifiedEmail  bool
 	JwtSecret             string
 	JwtExpirationDuration time.Duration
 	JwtIssuer             string
+
+	// mfaKey signs MFA challenge tokens. It is derived from JwtSecret but
+	// differs from it, so a challenge can never pass as an access token.
+	mfaKey []byte
 }
 
-func NewAuthHandler(userRepository repository.UserRepository, jwtSecret string, jwtExpirationDuration time.Duration, jwtIssuer string) *AuthHandler {
+func NewAuthHandler(userRepository repository.UserRepository, mfaRepository repository.MFARepository, sessionRepository repository.SessionRepository, hub *ws.Hub, passwordHasher password.Hasher, passwordPolicy *password.Policy, usernamePolicy *username.Policy, loginGuard *loginguard.Guard, emailVerifier *emailverify.Verifier, requireVerifiedEmail bool, jwtSecret string, jwtExpirationDuration time.Duration, jwtIssuer string) *AuthHandler {
+	mac := hmac.New(sha256.New, []byte(jwtSecret))
+	mac.Write([]byte("mfa-challenge"))
+
 	return &AuthHandler{
 		UserRepository:        userRepository,
+		MFARepository:         mfaRepository,
+		SessionRepository:     sessionRepository,
+		Hub:                   hub,
+		PasswordHasher:        passwordHasher,
+		PasswordPolicy:        passwordPolicy,
+		UsernamePolicy:        usernamePolicy,
+		LoginGuard:            loginGuard,
+		EmailVerifier:         emailVerifier,
+		RequireVerifiedEmail:  requireVerifiedEmail,
 		JwtSecret:             jwtSecret,
 		JwtExpirationDuration: jwtExpirationDuration,
 		JwtIssuer:             jwtIssuer,
+
+		mfaKey: mac.Sum(nil),
 	}
 }
 
 type RegisterRequest struct {
-	Username string `json:"username" binding:"required,min=3,max=20"`
-	Password string `json:"password" binding:"required,min=8"`
+	// Username length and characters are checked by UsernamePolicy.
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"omitempty,email,max=320"`
+	// Password length and content are checked by PasswordPolicy.
+	Password string `json:"password" binding:"required"`
 }
 
 func (h *AuthHandler) Register(ctx *gin.Context) {
 	var req RegisterRequest
 
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		log.Printf("register validation error: %v", err)
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		slog.InfoContext(ctx.Request.Context(), "register validation error", logger.Err(err))
+		_ = ctx.Error(apierror.FromBinding(err))
+		return
+	}
+	if h.RequireVerifiedEmail && req.Email == "" {
+		_ = ctx.Error(apierror.ErrValidationFailed.WithDetails(apierror.FieldError{
+			Field:   "email",
+			Rule:    "required",
+			Message: "is required",
+		}))
+		return
+	}
+	if !checkUsernamePolicy(ctx, h.UsernamePolicy, req.Username) {
+		return
+	}
+	if !checkPasswordPolicy(ctx, h.PasswordPolicy, "password", req.Password) {
 		return
 	}
 
 	_, err := h.UserRepository.GetUserByUsername(ctx.Request.Context(), req.Username)
 	if err == nil {
-		log.Printf("registration attempt with existing username: %s", req.Username)
-		ctx.JSON(http.StatusConflict, gin.H{"error": "Username already taken"})
+		slog.InfoContext(ctx.Request.Context(), "registration attempt with existing username", slog.String("username", req.Username))
+		_ = ctx.Error(apierror.ErrUsernameTaken)
 		return
 	}
 	if !errors.Is(err, repository.ErrUserNotFound) {
-		log.Printf("error checking existing user '%s' during registration: %v", req.Username, err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process registration"})
+		slog.ErrorContext(ctx.Request.Context(), "error checking existing user during registration", slog.String("username", req.Username), logger.Err(err))
+		_ = ctx.Error(err)
 		return
 	}
 
-	newUser, err := h.UserRepository.CreateUser(ctx.Request.Context(), req.Username, req.Password)
+	newUser, err := h.UserRepository.CreateUser(ctx.Request.Context(), req.Username, req.Email, req.Password)
 	if err != nil {
 		if errors.Is(err, repository.ErrUsernameTaken) {
-			log.Printf("failed to create user, username '%s' already taken: %v", req.Username, err)
-			ctx.JSON(http.StatusConflict, gin.H{"error": "Username already taken"})
-			return
+			slog.InfoContext(ctx.Request.Context(), "failed to create user, username already taken", slog.String("username", req.Username))
+		} else {
+			slog.ErrorContext(ctx.Request.Context(), "error creating user", slog.String("username", req.Username), logger.Err(err))
 		}
-		log.Printf("error creating user '%s': %v", req.Username, err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
+		_ = ctx.Error(err)
 		return
 	}
 
-	log.Printf("user registered successfully: id=%d, username=%s", newUser.ID, newUser.Username)
+	slog.InfoContext(ctx.Request.Context(), "user registered successfully", logger.UserID(newUser.ID), slog.String("username", newUser.Username))
+	if newUser.Email != "" {
+		go sendVerificationEmail(context.WithoutCancel(ctx.Request.Context()), h.EmailVerifier, newUser)
+	}
 	ctx.JSON(http.StatusCreated, gin.H{
 		"message": "User registered successfully",
 		"user_id": newUser.ID,
@@ -75,8 +147,12 @@ func (h *AuthHandler) Register(ctx *gin.Context) {
 }
 
 type LoginRequest struct {
+	// Username also accepts the account's verified email address.
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
+	// DeviceName labels the session in the session list; when empty it is
+	// derived from the User-Agent.
+	DeviceName string `json:"device_name" binding:"max=100"`
 }
 
 type LoginResponse struct {
@@ -88,48 +164,202 @@ func (h *AuthHandler) Login(ctx *gin.Context) {
 	var req LoginRequest
 
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		log.Printf("login validation error: %v", err)
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		slog.InfoContext(ctx.Request.Context(), "login validation error", logger.Err(err))
+		_ = ctx.Error(apierror.FromBinding(err))
+		return
+	}
+
+	clientIP := ctx.ClientIP()
+	user, err := h.findLoginUser(ctx.Request.Context(), req.Username)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		slog.ErrorContext(ctx.Request.Context(), "error fetching user during login", slog.String("username", req.Username), logger.Err(err))
+		_ = ctx.Error(err)
+		return
+	}
+
+	account := loginguard.UnknownAccount(req.Username)
+	if user != nil {
+		account = loginguard.UserAccount(user.ID)
+	}
+	if h.throttled(ctx, account, req.Username, clientIP) {
+		return
+	}
+
+	// from here on the attempt counts as failed unless it is released.
+	if user == nil {
+		slog.InfoContext(ctx.Request.Context(), "login attempt for non-existent username", slog.String("username", req.Username))
+		metrics.AuthFailures.WithLabelValues("unknown_user").Inc()
+		h.LoginGuard.Failure(ctx.Request.Context(), account, clientIP)
+		_ = ctx.Error(apierror.ErrInvalidCredentials)
+		return
+	}
+
+	matches, err := h.PasswordHasher.Verify(user.HashedPassword, req.Password)
+	if err != nil {
+		slog.ErrorContext(ctx.Request.Context(), "error verifying password hash", logger.UserID(user.ID), logger.Err(err))
+		_ = ctx.Error(err)
+		return
+	}
+	if !matches {
+		slog.InfoContext(ctx.Request.Context(), "invalid password attempt", logger.UserID(user.ID), slog.String("username", req.Username))
+		metrics.AuthFailures.WithLabelValues("wrong_password").Inc()
+		h.LoginGuard.Failure(ctx.Request.Context(), account, clientIP)
+		_ = ctx.Error(apierror.ErrInvalidCredentials)
+		return
+	}
+
+	h.upgradePasswordHash(ctx.Request.Context(), user, req.Password)
+
+	totp, err := h.MFARepository.GetTOTP(ctx.Request.Context(), user.ID)
+	if err != nil && !errors.Is(err, repository.ErrTOTPNotFound) {
+		_ = ctx.Error(err)
+		return
+	}
+	if totp.Enabled() {
+		// the password was right, but the account's failures are only
+		// cleared once the second factor is verified, so re-entering the
+		// password cannot reset them between code guesses.
+		h.LoginGuard.Release(ctx.Request.Context(), account, clientIP)
+		challenge, err := h.issueMFAChallenge(ctx.Request.Context(), user)
+		if err != nil {
+			slog.ErrorContext(ctx.Request.Context(), "error issuing mfa challenge", logger.UserID(user.ID), logger.Err(err))
+			_ = ctx.Error(err)
+			return
+		}
+		slog.InfoContext(ctx.Request.Context(), "password accepted, mfa required", logger.UserID(user.ID))
+		ctx.JSON(http.StatusOK, MFAChallengeResponse{MFARequired: true, MFAToken: challenge})
+		return
+	}
+
+	h.LoginGuard.Success(ctx.Request.Context(), account, clientIP)
+	h.respondWithToken(ctx, user, req.DeviceName)
+}
+
+type MFAChallengeResponse struct {
+	MFARequired bool   `json:"mfa_required"`
+	MFAToken    string `json:"mfa_token"`
+}
+
+type LoginMFARequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	// Code is a current TOTP code or one of the user's recovery codes.
+	Code       string `json:"code" binding:"required"`
+	DeviceName string `json:"device_name" binding:"max=100"`
+}
+
+// LoginMFA completes a login that Login answered with mfa_required by
+// exchanging the challenge token and a second-factor code for an access
+// token. Wrong codes count towards the same lockout as wrong passwords, and
+// a challenge is spent by a successful sign-in or mfaChallengeMaxAttempts
+// codes, whichever comes first.
+func (h *AuthHandler) LoginMFA(ctx *gin.Context) {
+	var req LoginMFARequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		slog.InfoContext(ctx.Request.Context(), "mfa login validation error", logger.Err(err))
+		_ = ctx.Error(apierror.FromBinding(err))
 		return
 	}
 
-	user, err := h.UserRepository.GetUserByUsername(ctx.Request.Context(), req.Username)
+	userID, challengeID, err := h.parseMFAChallenge(req.MFAToken)
+	if err != nil {
+		slog.InfoContext(ctx.Request.Context(), "invalid mfa challenge", logger.Err(err))
+		metrics.AuthFailures.WithLabelValues("invalid_mfa_token").Inc()
+		_ = ctx.Error(apierror.ErrMFATokenInvalid.Wrap(err))
+		return
+	}
+
+	user, err := h.UserRepository.GetUserByID(ctx.Request.Context(), userID)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
-			log.Printf("login attempt for non-existent username: %s", req.Username)
-			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+			_ = ctx.Error(apierror.ErrMFATokenInvalid.Wrap(err))
 			return
 		}
-		log.Printf("error fetching user '%s' during login: %v", req.Username, err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed due to server error"})
+		_ = ctx.Error(err)
 		return
 	}
 
-	if err = bcrypt.CompareHashAndPassword([]byte(user.HashedPassword), []byte(req.Password)); err != nil {
-		log.Printf("invalid password attempt for username: %s", req.Username)
-		ctx.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	clientIP := ctx.ClientIP()
+	account := loginguard.UserAccount(user.ID)
+	if h.throttled(ctx, account, user.Username, clientIP) {
 		return
 	}
 
-	now := time.Now()
-	claims := jwt.MapClaims{
-		"iss": h.JwtIssuer,
-		"sub": user.ID,
-		"usr": user.Username,
-		"iat": now.Unix(),
-		"exp": now.Add(h.JwtExpirationDuration).Unix(),
+	usable, err := h.MFARepository.UseMFAChallengeAttempt(ctx.Request.Context(), user.ID, challengeID, mfaChallengeMaxAttempts)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	if !usable {
+		slog.InfoContext(ctx.Request.Context(), "spent or exhausted mfa challenge used", logger.UserID(user.ID))
+		metrics.AuthFailures.WithLabelValues("invalid_mfa_token").Inc()
+		h.LoginGuard.Failure(ctx.Request.Context(), account, clientIP)
+		_ = ctx.Error(apierror.ErrMFATokenInvalid)
+		return
+	}
+
+	ok, err := verifySecondFactor(ctx.Request.Context(), h.MFARepository, user.ID, req.Code)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	if !ok {
+		slog.InfoContext(ctx.Request.Context(), "invalid mfa code attempt", logger.UserID(user.ID))
+		metrics.AuthFailures.WithLabelValues("wrong_mfa_code").Inc()
+		h.LoginGuard.Failure(ctx.Request.Context(), account, clientIP)
+		_ = ctx.Error(apierror.ErrInvalidMFACode)
+		return
+	}
+
+	// deleting the challenge makes it single-use even if two requests with
+	// valid codes race each other.
+	consumed, err := h.MFARepository.ConsumeMFAChallenge(ctx.Request.Context(), user.ID, challengeID)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	if !consumed {
+		_ = ctx.Error(apierror.ErrMFATokenInvalid)
+		return
+	}
+
+	h.LoginGuard.Success(ctx.Request.Context(), account, clientIP)
+	h.respondWithToken(ctx, user, req.DeviceName)
+}
+
+// throttled runs guardAttempt for a login. identifier is only logged.
+func (h *AuthHandler) throttled(ctx *gin.Context, account loginguard.Account, identifier string, clientIP string) bool {
+	return guardAttempt(ctx, h.LoginGuard, account, clientIP, slog.String("username", identifier))
+}
+
+// guardAttempt starts an attempt on account from clientIP and reports
+// whether guard is holding it back, in which case it renders the 429 with
+// Retry-After. attrs identify the account in the log line.
+func guardAttempt(ctx *gin.Context, guard *loginguard.Guard, account loginguard.Account, clientIP string, attrs ...any) bool {
+	retryAfter, err := guard.Attempt(ctx.Request.Context(), account, clientIP)
+	if err != nil {
+		_ = ctx.Error(err)
+		return true
+	}
+	if retryAfter <= 0 {
+		return false
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	slog.InfoContext(ctx.Request.Context(), "login attempt throttled", append(attrs, slog.String("ip", clientIP), slog.Duration("retry_after", retryAfter))...)
+	metrics.AuthFailures.WithLabelValues("throttled").Inc()
+	ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	_ = ctx.Error(apierror.ErrTooManyAttempts)
+	return true
+}
 
-	tokenSigned, err := token.SignedString([]byte(h.JwtSecret))
+func (h *AuthHandler) respondWithToken(ctx *gin.Context, user *models.User, deviceName string) {
+	tokenSigned, _, err := h.startSession(ctx, user, deviceName)
 	if err != nil {
-		log.Printf("error signing jwt for user %s: %v", user.Username, err)
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		_ = ctx.Error(err)
 		return
 	}
 
-	log.Printf("User %d (%s) logged in successfully", user.ID, user.Username)
+	slog.InfoContext(ctx.Request.Context(), "user logged in successfully", logger.UserID(user.ID), slog.String("username", user.Username))
 
 	response := LoginResponse{
 		Token: tokenSigned,
@@ -138,3 +368,237 @@ func (h *AuthHandler) Login(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, response)
 }
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type ChangePasswordResponse struct {
+	Token string `json:"token"`
+}
+
+// ChangePassword replaces the caller's password after checking the current
+// one. Every previously issued token is revoked and all other sessions are
+// signed out, so the response carries a fresh token for the calling session.
+func (h *AuthHandler) ChangePassword(ctx *gin.Context) {
+	userID := ctx.GetInt64(middleware.AuthorizationPayloadKey)
+
+	var req ChangePasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		slog.InfoContext(ctx.Request.Context(), "change password validation error", logger.Err(err))
+		_ = ctx.Error(apierror.FromBinding(err))
+		return
+	}
+	if !checkPasswordPolicy(ctx, h.PasswordPolicy, "new_password", req.NewPassword) {
+		return
+	}
+
+	user, err := h.UserRepository.GetUserByID(ctx.Request.Context(), userID)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	matches, err := h.PasswordHasher.Verify(user.HashedPassword, req.CurrentPassword)
+	if err != nil {
+		slog.ErrorContext(ctx.Request.Context(), "error verifying password hash", logger.UserID(userID), logger.Err(err))
+		_ = ctx.Error(err)
+		return
+	}
+	if !matches {
+		slog.InfoContext(ctx.Request.Context(), "password change with wrong current password", logger.UserID(userID))
+		metrics.AuthFailures.WithLabelValues("wrong_password").Inc()
+		_ = ctx.Error(apierror.ErrWrongPassword)
+		return
+	}
+
+	version, err := h.UserRepository.UpdatePassword(ctx.Request.Context(), userID, req.NewPassword)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	user.TokenVersion = version
+
+	sessionID := ctx.GetInt64(middleware.SessionPayloadKey)
+	var tokenSigned string
+	if sessionID == 0 {
+		// a token without a session gets one, and that is the session kept.
+		tokenSigned, sessionID, err = h.startSession(ctx, user, "")
+	} else {
+		tokenSigned, err = h.renewSession(ctx, user, sessionID)
+	}
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	if _, err := revokeOtherSessions(ctx.Request.Context(), h.SessionRepository, h.Hub, user.ID, sessionID); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	slog.InfoContext(ctx.Request.Context(), "password changed, other sessions revoked", logger.UserID(userID))
+	ctx.JSON(http.StatusOK, ChangePasswordResponse{Token: tokenSigned})
+}
+
+// findLoginUser resolves a login identifier. Anything that looks like an
+// email is tried against verified addresses first, then as a username, since
+// older usernames may contain '@'.
+func (h *AuthHandler) findLoginUser(ctx context.Context, identifier string) (*models.User, error) {
+	if strings.Contains(identifier, "@") {
+		user, err := h.UserRepository.GetUserByEmail(ctx, identifier)
+		if err == nil && user.EmailVerified() {
+			return user, nil
+		}
+		if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+			return nil, err
+		}
+	}
+	return h.UserRepository.GetUserByUsername(ctx, identifier)
+}
+
+// checkPasswordPolicy reports whether candidate satisfies policy. If it does
+// not, it records ErrWeakPassword with one detail per broken rule for field.
+func checkPasswordPolicy(ctx *gin.Context, policy *password.Policy, field string, candidate string) bool {
+	violations := policy.Check(ctx.Request.Context(), candidate)
+	if len(violations) == 0 {
+		return true
+	}
+
+	details := make([]apierror.FieldError, len(violations))
+	rules := make([]string, len(violations))
+	for i, v := range violations {
+		details[i] = apierror.FieldError{Field: field, Rule: v.Rule, Message: v.Message}
+		rules[i] = v.Rule
+	}
+	slog.InfoContext(ctx.Request.Context(), "password rejected by policy", slog.Any("rules", rules))
+	_ = ctx.Error(apierror.ErrWeakPassword.WithDetails(details...))
+	return false
+}
+
+func checkUsernamePolicy(ctx *gin.Context, policy *username.Policy, candidate string) bool {
+	violations := policy.Check(candidate)
+	if len(violations) == 0 {
+		return true
+	}
+
+	details := make([]apierror.FieldError, len(violations))
+	rules := make([]string, len(violations))
+	for i, v := range violations {
+		details[i] = apierror.FieldError{Field: "username", Rule: v.Rule, Message: v.Message}
+		rules[i] = v.Rule
+	}
+	slog.InfoContext(ctx.Request.Context(), "username rejected by policy", slog.String("username", candidate), slog.Any("rules", rules))
+	_ = ctx.Error(apierror.ErrInvalidUsername.WithDetails(details...))
+	return false
+}
+
+// upgradePasswordHash re-encodes the just-verified password when its stored
+// hash uses an outdated algorithm or cost. Failing to upgrade is logged and
+// otherwise ignored; the old hash keeps working and is retried next login.
+func (h *AuthHandler) upgradePasswordHash(ctx context.Context, user *models.User, plaintext string) {
+	if !h.PasswordHasher.NeedsRehash(user.HashedPassword) {
+		return
+	}
+	if err := h.UserRepository.RehashPassword(ctx, user.ID, user.HashedPassword, plaintext); err != nil {
+		slog.WarnContext(ctx, "could not upgrade password hash", logger.UserID(user.ID), logger.Err(err))
+	}
+}
+
+// startSession records a new session for the request's device and returns
+// an access token bound to it along with the session's ID.
+func (h *AuthHandler) startSession(ctx *gin.Context, user *models.User, deviceName string) (string, int64, error) {
+	userAgent := cleanUserAgent(ctx.Request.UserAgent(), maxUserAgentRunes)
+	if deviceName == "" {
+		deviceName = describeUserAgent(userAgent)
+	}
+
+	expiresAt := time.Now().Add(h.JwtExpirationDuration)
+	session, err := h.SessionRepository.CreateSession(ctx.Request.Context(), user.ID, deviceName, userAgent, ctx.ClientIP(), expiresAt)
+	if err != nil {
+		return "", 0, err
+	}
+
+	tokenSigned, err := h.issueToken(user, session.ID, expiresAt)
+	if err != nil {
+		slog.ErrorContext(ctx.Request.Context(), "error signing jwt", logger.UserID(user.ID), logger.Err(err))
+		return "", 0, err
+	}
+	return tokenSigned, session.ID, nil
+}
+
+// renewSession issues a fresh token for an existing session and pushes the
+// session's expiry out to match it.
+func (h *AuthHandler) renewSession(ctx *gin.Context, user *models.User, sessionID int64) (string, error) {
+	expiresAt := time.Now().Add(h.JwtExpirationDuration)
+	if err := h.SessionRepository.ExtendSession(ctx.Request.Context(), user.ID, sessionID, expiresAt); err != nil {
+		return "", err
+	}
+
+	tokenSigned, err := h.issueToken(user, sessionID, expiresAt)
+	if err != nil {
+		slog.ErrorContext(ctx.Request.Context(), "error signing jwt", logger.UserID(user.ID), logger.Err(err))
+		return "", err
+	}
+	return tokenSigned, nil
+}
+
+// issueToken signs an access token for user. "ver" ties the token to the
+// user's current token version so a password change revokes it, and "sid"
+// ties it to a session that can be signed out remotely.
+func (h *AuthHandler) issueToken(user *models.User, sessionID int64, expiresAt time.Time) (string, error) {
+	claims := jwt.MapClaims{
+		"iss": h.JwtIssuer,
+		"sub": user.ID,
+		"usr": user.Username,
+		"ver": user.TokenVersion,
+		"sid": sessionID,
+		"iat": time.Now().Unix(),
+		"exp": expiresAt.Unix(),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(h.JwtSecret))
+}
+
+// issueMFAChallenge stores a new challenge and returns it as a signed
+// token. The token names the stored row, which LoginMFA spends.
+func (h *AuthHandler) issueMFAChallenge(ctx context.Context, user *models.User) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate mfa challenge id: %w", err)
+	}
+	challengeID := hex.EncodeToString(id)
+
+	now := time.Now()
+	expiresAt := now.Add(mfaChallengeTTL)
+	if err := h.MFARepository.CreateMFAChallenge(ctx, user.ID, challengeID, expiresAt); err != nil {
+		return "", err
+	}
+
+	claims := jwt.RegisteredClaims{
+		ID:        challengeID,
+		Issuer:    h.JwtIssuer,
+		Subject:   strconv.FormatInt(user.ID, 10),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(h.mfaKey)
+}
+
+// parseMFAChallenge returns the user and challenge ID a challenge token
+// was issued for.
+func (h *AuthHandler) parseMFAChallenge(tokenString string) (int64, string, error) {
+	var claims jwt.RegisteredClaims
+	_, err := jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		return h.mfaKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired(), jwt.WithIssuer(h.JwtIssuer))
+	if err != nil {
+		return 0, "", err
+	}
+	if claims.ID == "" {
+		return 0, "", errors.New("mfa challenge has no id")
+	}
+	userID, err := strconv.ParseInt(claims.Subject, 10, 64)
+	return userID, claims.ID, err
+}
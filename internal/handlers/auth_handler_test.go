@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sokolawesome/chat-server/internal/middleware"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/password"
+	"github.com/sokolawesome/chat-server/internal/repository"
+	"github.com/sokolawesome/chat-server/internal/username"
+	"github.com/sokolawesome/chat-server/internal/ws"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testHasher = func() password.Hasher {
+	hasher, err := password.New(password.AlgorithmBcrypt, bcrypt.MinCost, password.Argon2Params{})
+	if err != nil {
+		panic(err)
+	}
+	return hasher
+}()
+
+func testPasswordPolicy(t *testing.T) *password.Policy {
+	t.Helper()
+	policy, err := password.NewPolicy(8, password.BcryptMaxBytes, "", nil)
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+	return policy
+}
+
+// userWithPassword returns a user whose stored hash matches plaintext.
+func userWithPassword(t *testing.T, id int64, name string, plaintext string) *models.User {
+	t.Helper()
+	hash, err := testHasher.Hash(plaintext)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	return &models.User{ID: id, Username: name, HashedPassword: hash}
+}
+
+func (r *fakeUserRepository) UpdatePassword(ctx context.Context, id int64, plaintext string) (int, error) {
+	hash, err := testHasher.Hash(plaintext)
+	if err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return 0, repository.ErrUserNotFound
+	}
+	user.HashedPassword = hash
+	user.TokenVersion++
+	return user.TokenVersion, nil
+}
+
+// memorySessionRepository keeps which user each session belongs to.
+type memorySessionRepository struct {
+	repository.SessionRepository
+
+	mu       sync.Mutex
+	sessions map[int64]int64
+	nextID   int64
+}
+
+func newMemorySessionRepository() *memorySessionRepository {
+	return &memorySessionRepository{sessions: map[int64]int64{}, nextID: 100}
+}
+
+func (r *memorySessionRepository) add(userID int64) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	r.sessions[r.nextID] = userID
+	return r.nextID
+}
+
+func (r *memorySessionRepository) CreateSession(ctx context.Context, userID int64, deviceName string, userAgent string, ipAddress string, expiresAt time.Time) (*models.Session, error) {
+	return &models.Session{ID: r.add(userID), UserID: userID, DeviceName: deviceName, ExpiresAt: expiresAt}, nil
+}
+
+func (r *memorySessionRepository) ExtendSession(ctx context.Context, userID int64, id int64, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sessions[id] != userID {
+		return repository.ErrSessionNotFound
+	}
+	return nil
+}
+
+func (r *memorySessionRepository) DeleteOtherSessions(ctx context.Context, userID int64, keepID int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	deleted := 0
+	for id, owner := range r.sessions {
+		if owner == userID && id != keepID {
+			delete(r.sessions, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// of returns the IDs of the user's sessions in ascending order.
+func (r *memorySessionRepository) of(userID int64) []int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []int64
+	for id, owner := range r.sessions {
+		if owner == userID {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// tokenSessionID returns the "sid" claim of an access token signed with
+// jwt-secret.
+func tokenSessionID(t *testing.T, token string) int64 {
+	t.Helper()
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) { return []byte("jwt-secret"), nil }); err != nil {
+		t.Fatalf("parsing token: %v", err)
+	}
+	sid, ok := claims["sid"].(float64)
+	if !ok {
+		t.Fatalf("token has no sid claim: %v", claims)
+	}
+	return int64(sid)
+}
+
+func TestChangePasswordKeepsCallingSession(t *testing.T) {
+	tests := []struct {
+		name string
+		// tokenSession is false for a token issued before sessions existed.
+		tokenSession bool
+	}{
+		{"token with a session", true},
+		{"token without a session", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			users := newFakeUserRepository(userWithPassword(t, 7, "alice", "old-password"))
+			sessions := newMemorySessionRepository()
+			other := sessions.add(7)
+			var current int64
+			if tt.tokenSession {
+				current = sessions.add(7)
+			}
+			stranger := sessions.add(8)
+
+			h := NewAuthHandler(users, fakeMFARepository{}, sessions, ws.NewHub(), testHasher, testPasswordPolicy(t), username.NewPolicy(nil), nil, nil, false, "jwt-secret", time.Hour, "chat-server")
+			router := gin.New()
+			router.Use(middleware.ErrorHandler())
+			router.POST("/me/password", func(ctx *gin.Context) {
+				ctx.Set(middleware.AuthorizationPayloadKey, int64(7))
+				if current != 0 {
+					ctx.Set(middleware.SessionPayloadKey, current)
+				}
+			}, h.ChangePassword)
+
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/me/password", strings.NewReader(`{"current_password":"old-password","new_password":"new-password-2"}`))
+			request.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(recorder, request)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("change password returned %d: %s", recorder.Code, recorder.Body)
+			}
+
+			var resp ChangePasswordResponse
+			if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding %s: %v", recorder.Body, err)
+			}
+			kept := tokenSessionID(t, resp.Token)
+			if tt.tokenSession && kept != current {
+				t.Fatalf("new token is bound to session %d, want the caller's %d", kept, current)
+			}
+			if got := sessions.of(7); !slices.Equal(got, []int64{kept}) {
+				t.Fatalf("sessions left = %v, want only %d (other session was %d)", got, kept, other)
+			}
+			if got := sessions.of(8); !slices.Equal(got, []int64{stranger}) {
+				t.Fatalf("another user's sessions = %v, want %v", got, []int64{stranger})
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/emailverify"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/middleware"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/repository"
+)
+
+type EmailVerificationHandler struct {
+	UserRepository repository.UserRepository
+	Verifier       *emailverify.Verifier
+}
+
+func NewEmailVerificationHandler(userRepository repository.UserRepository, verifier *emailverify.Verifier) *EmailVerificationHandler {
+	return &EmailVerificationHandler{
+		UserRepository: userRepository,
+		Verifier:       verifier,
+	}
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// Verify confirms the address named by a token from a verification email.
+func (h *EmailVerificationHandler) Verify(ctx *gin.Context) {
+	var req VerifyEmailRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		slog.InfoContext(ctx.Request.Context(), "verify email validation error", logger.Err(err))
+		_ = ctx.Error(apierror.FromBinding(err))
+		return
+	}
+
+	userID, email, err := h.Verifier.Parse(req.Token)
+	if err != nil {
+		slog.InfoContext(ctx.Request.Context(), "invalid email verification token", logger.Err(err))
+		_ = ctx.Error(err)
+		return
+	}
+
+	if err := h.UserRepository.MarkEmailVerified(ctx.Request.Context(), userID, email); err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			// the account is gone or its address changed since the link was sent.
+			slog.InfoContext(ctx.Request.Context(), "email verification token no longer matches account", logger.UserID(userID))
+			_ = ctx.Error(apierror.ErrVerifyTokenInvalid.Wrap(err))
+			return
+		}
+		_ = ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Email address verified"})
+}
+
+// Resend emails a new verification link to the caller's current address.
+func (h *EmailVerificationHandler) Resend(ctx *gin.Context) {
+	userID := ctx.GetInt64(middleware.AuthorizationPayloadKey)
+
+	user, err := h.UserRepository.GetUserByID(ctx.Request.Context(), userID)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	if user.Email == "" {
+		_ = ctx.Error(apierror.ErrNoEmail)
+		return
+	}
+	if user.EmailVerified() {
+		ctx.JSON(http.StatusOK, gin.H{"message": "Email address is already verified"})
+		return
+	}
+
+	if err := h.Verifier.Send(ctx.Request.Context(), user); err != nil {
+		slog.ErrorContext(ctx.Request.Context(), "failed to send verification email", logger.UserID(user.ID), logger.Err(err))
+		_ = ctx.Error(err)
+		return
+	}
+
+	slog.InfoContext(ctx.Request.Context(), "verification email sent", logger.UserID(user.ID))
+	ctx.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
+
+func sendVerificationEmail(ctx context.Context, verifier *emailverify.Verifier, user *models.User) {
+	ctx, cancel := context.WithTimeout(ctx, mailTimeout)
+	defer cancel()
+
+	if err := verifier.Send(ctx, user); err != nil {
+		slog.ErrorContext(ctx, "failed to send verification email", logger.UserID(user.ID), logger.Err(err))
+		return
+	}
+	slog.InfoContext(ctx, "verification email sent", logger.UserID(user.ID))
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/logger"
+)
+
+// HealthCheck is a named dependency probe run by /readyz, e.g. the database
+// or a pub/sub connection.
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type HealthHandler struct {
+	Checks       []HealthCheck
+	CheckTimeout time.Duration
+
+	draining atomic.Bool
+}
+
+func NewHealthHandler(checkTimeout time.Duration, checks ...HealthCheck) *HealthHandler {
+	return &HealthHandler{
+		Checks:       checks,
+		CheckTimeout: checkTimeout,
+	}
+}
+
+type checkResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// SetDraining makes /readyz fail so load balancers stop routing new traffic
+// while the server shuts down.
+func (h *HealthHandler) SetDraining() {
+	h.draining.Store(true)
+}
+
+func (h *HealthHandler) Liveness(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (h *HealthHandler) Readiness(ctx *gin.Context) {
+	ready := true
+	results := make(map[string]checkResult, len(h.Checks)+1)
+
+	if h.draining.Load() {
+		ready = false
+		results["draining"] = checkResult{Status: "fail", Error: "server is shutting down"}
+	} else {
+		results["draining"] = checkResult{Status: "ok"}
+	}
+
+	for _, check := range h.Checks {
+		checkCtx, cancel := context.WithTimeout(ctx.Request.Context(), h.CheckTimeout)
+		start := time.Now()
+		err := check.Check(checkCtx)
+		cancel()
+
+		result := checkResult{Status: "ok", DurationMs: time.Since(start).Milliseconds()}
+		if err != nil {
+			// probes are unauthenticated, so the cause stays in the logs.
+			ready = false
+			result.Status = "fail"
+			result.Error = "unavailable"
+			slog.WarnContext(ctx.Request.Context(), "readiness check failed", slog.String("check", check.Name), logger.Err(err))
+		}
+		results[check.Name] = result
+	}
+
+	status, code := "ok", http.StatusOK
+	if !ready {
+		status, code = "fail", http.StatusServiceUnavailable
+	}
+	ctx.JSON(code, gin.H{
+		"status": status,
+		"checks": results,
+	})
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func readiness(t *testing.T, h *HealthHandler) (int, string) {
+	t.Helper()
+	router := gin.New()
+	router.GET("/readyz", h.Readiness)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return recorder.Code, recorder.Body.String()
+}
+
+func TestReadinessHidesCheckErrors(t *testing.T) {
+	h := NewHealthHandler(time.Second, HealthCheck{
+		Name: "database",
+		Check: func(ctx context.Context) error {
+			return errors.New(`failed to connect to host=10.0.3.7 user=chat database=chat: password authentication failed`)
+		},
+	})
+
+	code, body := readiness(t, h)
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+	for _, leak := range []string{"10.0.3.7", "user=chat", "password"} {
+		if strings.Contains(body, leak) {
+			t.Errorf("body %s leaks %q", body, leak)
+		}
+	}
+
+	var resp struct {
+		Status string                 `json:"status"`
+		Checks map[string]checkResult `json:"checks"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("decoding %s: %v", body, err)
+	}
+	if got := resp.Checks["database"]; resp.Status != "fail" || got.Status != "fail" || got.Error != "unavailable" {
+		t.Fatalf("readiness = %s, want database failing as unavailable", body)
+	}
+}
+
+func TestReadinessDraining(t *testing.T) {
+	h := NewHealthHandler(time.Second, HealthCheck{
+		Name:  "database",
+		Check: func(ctx context.Context) error { return nil },
+	})
+
+	if code, body := readiness(t, h); code != http.StatusOK {
+		t.Fatalf("status = %d (%s), want %d", code, body, http.StatusOK)
+	}
+	h.SetDraining()
+	if code, _ := readiness(t, h); code != http.StatusServiceUnavailable {
+		t.Fatalf("status while draining = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/loginguard"
+	"github.com/sokolawesome/chat-server/internal/metrics"
+	"github.com/sokolawesome/chat-server/internal/mfa"
+	"github.com/sokolawesome/chat-server/internal/middleware"
+	"github.com/sokolawesome/chat-server/internal/repository"
+)
+
+// totpSkew is how many 30-second steps of clock drift either way are
+// tolerated when checking a code.
+const totpSkew = 1
+
+type MFAHandler struct {
+	UserRepository repository.UserRepository
+	MFARepository  repository.MFARepository
+	// LoginGuard counts wrong codes against the same lockout as sign-in, so
+	// a stolen session cannot guess codes at will.
+	LoginGuard *loginguard.Guard
+	Issuer     string
+}
+
+func NewMFAHandler(userRepository repository.UserRepository, mfaRepository repository.MFARepository, loginGuard *loginguard.Guard, issuer string) *MFAHandler {
+	return &MFAHandler{
+		UserRepository: userRepository,
+		MFARepository:  mfaRepository,
+		LoginGuard:     loginGuard,
+		Issuer:         issuer,
+	}
+}
+
+type EnrollTOTPResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+// Enroll starts TOTP enrollment with a fresh secret. The enrollment has no
+// effect on login until it is confirmed with a first code; calling Enroll
+// again before that replaces the secret.
+func (h *MFAHandler) Enroll(ctx *gin.Context) {
+	userID := ctx.GetInt64(middleware.AuthorizationPayloadKey)
+
+	existing, err := h.MFARepository.GetTOTP(ctx.Request.Context(), userID)
+	if err != nil && !errors.Is(err, repository.ErrTOTPNotFound) {
+		_ = ctx.Error(err)
+		return
+	}
+	if existing.Enabled() {
+		_ = ctx.Error(apierror.ErrMFAAlreadyEnabled)
+		return
+	}
+
+	user, err := h.UserRepository.GetUserByID(ctx.Request.Context(), userID)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	if err := h.MFARepository.SaveTOTPSecret(ctx.Request.Context(), userID, secret); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	slog.InfoContext(ctx.Request.Context(), "totp enrollment started", logger.UserID(userID))
+	ctx.JSON(http.StatusOK, EnrollTOTPResponse{
+		Secret:     secret,
+		OtpauthURI: mfa.URI(h.Issuer, user.Username, secret),
+	})
+}
+
+type ConfirmTOTPRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type ConfirmTOTPResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Confirm enables TOTP once the user proves their authenticator works, and
+// returns one-time recovery codes. This is the only time they are shown.
+func (h *MFAHandler) Confirm(ctx *gin.Context) {
+	userID := ctx.GetInt64(middleware.AuthorizationPayloadKey)
+
+	var req ConfirmTOTPRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		_ = ctx.Error(apierror.FromBinding(err))
+		return
+	}
+
+	totp, err := h.MFARepository.GetTOTP(ctx.Request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrTOTPNotFound) {
+			_ = ctx.Error(apierror.ErrMFANotPending)
+			return
+		}
+		_ = ctx.Error(err)
+		return
+	}
+	if totp.Enabled() {
+		_ = ctx.Error(apierror.ErrMFAAlreadyEnabled)
+		return
+	}
+
+	clientIP := ctx.ClientIP()
+	account := loginguard.UserAccount(userID)
+	if guardAttempt(ctx, h.LoginGuard, account, clientIP, logger.UserID(userID)) {
+		return
+	}
+
+	step, ok := mfa.Validate(totp.Secret, req.Code, time.Now(), totpSkew)
+	if !ok {
+		slog.InfoContext(ctx.Request.Context(), "invalid code during totp confirmation", logger.UserID(userID))
+		metrics.AuthFailures.WithLabelValues("wrong_mfa_code").Inc()
+		h.LoginGuard.Failure(ctx.Request.Context(), account, clientIP)
+		_ = ctx.Error(apierror.ErrInvalidMFACode)
+		return
+	}
+	h.LoginGuard.Success(ctx.Request.Context(), account, clientIP)
+
+	codes, err := mfa.GenerateRecoveryCodes()
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	hashes := make([]string, len(codes))
+	for i, code := range codes {
+		hashes[i] = mfa.HashRecoveryCode(code)
+	}
+
+	if err := h.MFARepository.EnableTOTP(ctx.Request.Context(), userID, step, hashes); err != nil {
+		if errors.Is(err, repository.ErrTOTPNotPending) {
+			_ = ctx.Error(apierror.ErrMFANotPending.Wrap(err))
+			return
+		}
+		_ = ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, ConfirmTOTPResponse{RecoveryCodes: codes})
+}
+
+type DisableTOTPRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// Disable turns TOTP off after checking a current code or a recovery code,
+// and discards the remaining recovery codes.
+func (h *MFAHandler) Disable(ctx *gin.Context) {
+	userID := ctx.GetInt64(middleware.AuthorizationPayloadKey)
+
+	var req DisableTOTPRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		_ = ctx.Error(apierror.FromBinding(err))
+		return
+	}
+
+	clientIP := ctx.ClientIP()
+	account := loginguard.UserAccount(userID)
+	if guardAttempt(ctx, h.LoginGuard, account, clientIP, logger.UserID(userID)) {
+		return
+	}
+
+	ok, err := verifySecondFactor(ctx.Request.Context(), h.MFARepository, userID, req.Code)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	if !ok {
+		slog.InfoContext(ctx.Request.Context(), "invalid code while disabling totp", logger.UserID(userID))
+		metrics.AuthFailures.WithLabelValues("wrong_mfa_code").Inc()
+		h.LoginGuard.Failure(ctx.Request.Context(), account, clientIP)
+		_ = ctx.Error(apierror.ErrInvalidMFACode)
+		return
+	}
+	h.LoginGuard.Success(ctx.Request.Context(), account, clientIP)
+
+	if err := h.MFARepository.DisableTOTP(ctx.Request.Context(), userID); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// verifySecondFactor accepts either a current TOTP code or an unused
+// recovery code. TOTP codes are single use: replaying one within its
+// validity window fails. Users without enabled TOTP never verify.
+func verifySecondFactor(ctx context.Context, mfaRepository repository.MFARepository, userID int64, code string) (bool, error) {
+	totp, err := mfaRepository.GetTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, repository.ErrTOTPNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !totp.Enabled() {
+		return false, nil
+	}
+
+	if step, ok := mfa.Validate(totp.Secret, code, time.Now(), totpSkew); ok {
+		used, err := mfaRepository.UseTOTPStep(ctx, userID, step)
+		if err == nil && !used {
+			metrics.AuthFailures.WithLabelValues("mfa_code_replayed").Inc()
+		}
+		return used, err
+	}
+
+	return mfaRepository.UseRecoveryCode(ctx, userID, mfa.HashRecoveryCode(code))
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/loginguard"
+	"github.com/sokolawesome/chat-server/internal/mfa"
+	"github.com/sokolawesome/chat-server/internal/middleware"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/repository"
+)
+
+const testRecoveryCode = "abcde-fghij"
+
+// totpMFARepository holds one TOTP enrollment, enabled or pending, with a
+// single recovery code.
+type totpMFARepository struct {
+	repository.MFARepository
+
+	mu       sync.Mutex
+	totp     *models.TOTP
+	recovery string
+}
+
+func newTOTPMFARepository(t *testing.T, enabled bool) *totpMFARepository {
+	t.Helper()
+	secret, err := mfa.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	totp := &models.TOTP{UserID: 7, Secret: secret}
+	if enabled {
+		enabledAt := time.Now()
+		totp.EnabledAt = &enabledAt
+	}
+	return &totpMFARepository{totp: totp, recovery: mfa.HashRecoveryCode(testRecoveryCode)}
+}
+
+func (r *totpMFARepository) GetTOTP(ctx context.Context, userID int64) (*models.TOTP, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.totp == nil || r.totp.UserID != userID {
+		return nil, repository.ErrTOTPNotFound
+	}
+	totp := *r.totp
+	return &totp, nil
+}
+
+func (r *totpMFARepository) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.recovery == "" || codeHash != r.recovery {
+		return false, nil
+	}
+	r.recovery = ""
+	return true, nil
+}
+
+func (r *totpMFARepository) DisableTOTP(ctx context.Context, userID int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.totp = nil
+	return nil
+}
+
+const mfaMaxAttempts = 3
+
+func newMFATestRouter(repo *totpMFARepository) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	guard := loginguard.New(repository.NewInMemoryLoginAttemptRepository(time.Hour), loginguard.Options{
+		MaxAttemptsPerUser: mfaMaxAttempts,
+		LockoutDuration:    time.Hour,
+		Window:             time.Hour,
+	})
+	h := NewMFAHandler(nil, repo, guard, "Chat Server")
+
+	router := gin.New()
+	router.Use(middleware.ErrorHandler())
+	signedIn := func(ctx *gin.Context) { ctx.Set(middleware.AuthorizationPayloadKey, int64(7)) }
+	router.POST("/mfa/totp/confirm", signedIn, h.Confirm)
+	router.POST("/mfa/totp/disable", signedIn, h.Disable)
+	return router
+}
+
+func postCode(router *gin.Engine, path string, code string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{"code":"`+code+`"}`))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestMFACodeChecksAreThrottled(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		enabled bool
+	}{
+		{"confirm", "/mfa/totp/confirm", false},
+		{"disable", "/mfa/totp/disable", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newMFATestRouter(newTOTPMFARepository(t, tt.enabled))
+
+			for i := 0; i < mfaMaxAttempts; i++ {
+				assertAPIError(t, postCode(router, tt.path, "not-a-code"), apierror.ErrInvalidMFACode)
+			}
+			recorder := postCode(router, tt.path, testRecoveryCode)
+			assertAPIError(t, recorder, apierror.ErrTooManyAttempts)
+			if recorder.Header().Get("Retry-After") == "" {
+				t.Fatal("throttled response has no Retry-After")
+			}
+		})
+	}
+}
+
+func TestDisableTOTPSuccessClearsFailures(t *testing.T) {
+	repo := newTOTPMFARepository(t, true)
+	router := newMFATestRouter(repo)
+
+	for i := 0; i < mfaMaxAttempts-1; i++ {
+		assertAPIError(t, postCode(router, "/mfa/totp/disable", "not-a-code"), apierror.ErrInvalidMFACode)
+	}
+	if recorder := postCode(router, "/mfa/totp/disable", testRecoveryCode); recorder.Code != http.StatusOK {
+		t.Fatalf("disable with a recovery code returned %d: %s", recorder.Code, recorder.Body)
+	}
+	if totp, _ := repo.GetTOTP(context.Background(), 7); totp.Enabled() {
+		t.Fatal("TOTP is still enabled")
+	}
+
+	// the account's count started over, so one more failure does not lock it.
+	assertAPIError(t, postCode(router, "/mfa/totp/disable", "not-a-code"), apierror.ErrInvalidMFACode)
+	assertAPIError(t, postCode(router, "/mfa/totp/disable", "not-a-code"), apierror.ErrInvalidMFACode)
+}
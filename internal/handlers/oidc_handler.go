@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/metrics"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/oidc"
+	"github.com/sokolawesome/chat-server/internal/repository"
+)
+
+const (
+	oidcFlowCookie     = "oidc_flow"
+	oidcFlowCookiePath = "/api/auth/oidc"
+	oidcFlowTTL        = 10 * time.Minute
+
+	// provisionAttempts bounds retries when a generated username is taken.
+	provisionAttempts = 5
+	// fallbackUsername is used when the provider's name fails UsernamePolicy.
+	fallbackUsername = "sso_user"
+)
+
+var usernameDisallowed = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+type OIDCHandler struct {
+	Provider           *oidc.Provider
+	Flows              *oidc.FlowCodec
+	UserRepository     repository.UserRepository
+	IdentityRepository repository.IdentityRepository
+	Auth               *AuthHandler
+	// PostLoginURL is the frontend page that receives the outcome in its URL
+	// fragment, which browsers never send to servers or write to logs.
+	PostLoginURL    string
+	JITProvisioning bool
+	SecureCookies   bool
+}
+
+func NewOIDCHandler(provider *oidc.Provider, flows *oidc.FlowCodec, userRepository repository.UserRepository, identityRepository repository.IdentityRepository, auth *AuthHandler, postLoginURL string, jitProvisioning bool, secureCookies bool) *OIDCHandler {
+	return &OIDCHandler{
+		Provider:           provider,
+		Flows:              flows,
+		UserRepository:     userRepository,
+		IdentityRepository: identityRepository,
+		Auth:               auth,
+		PostLoginURL:       postLoginURL,
+		JITProvisioning:    jitProvisioning,
+		SecureCookies:      secureCookies,
+	}
+}
+
+// Login starts the authorization-code flow. State, nonce and the PKCE
+// verifier travel in a signed, short-lived cookie scoped to the callback.
+func (h *OIDCHandler) Login(ctx *gin.Context) {
+	flow, err := oidc.NewFlow(oidcFlowTTL)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	value, err := h.Flows.Encode(flow)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	authURL, err := h.Provider.AuthCodeURL(ctx.Request.Context(), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		slog.ErrorContext(ctx.Request.Context(), "oidc provider unavailable", logger.Err(err))
+		_ = ctx.Error(apierror.ErrSSOUnavailable.Wrap(err))
+		return
+	}
+
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oidcFlowCookie, value, int(oidcFlowTTL.Seconds()), oidcFlowCookiePath, "", h.SecureCookies, true)
+	ctx.Redirect(http.StatusFound, authURL)
+}
+
+// Callback finishes the flow, resolves or provisions the local user and
+// redirects to PostLoginURL with either an access token or, for users with
+// TOTP enabled, an MFA challenge for AuthHandler.LoginMFA.
+func (h *OIDCHandler) Callback(ctx *gin.Context) {
+	value, cookieErr := ctx.Cookie(oidcFlowCookie)
+	ctx.SetSameSite(http.SameSiteLaxMode)
+	ctx.SetCookie(oidcFlowCookie, "", -1, oidcFlowCookiePath, "", h.SecureCookies, true)
+	if cookieErr != nil {
+		_ = ctx.Error(apierror.ErrSSOFlowInvalid.Wrap(cookieErr))
+		return
+	}
+
+	flow, err := h.Flows.Decode(value)
+	if err != nil {
+		_ = ctx.Error(apierror.ErrSSOFlowInvalid.Wrap(err))
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(ctx.Query("state")), []byte(flow.State)) != 1 {
+		_ = ctx.Error(apierror.ErrSSOFlowInvalid.Wrap(errors.New("state mismatch")))
+		return
+	}
+	if providerErr := ctx.Query("error"); providerErr != "" {
+		slog.InfoContext(ctx.Request.Context(), "oidc provider returned an error", slog.String("error", providerErr), slog.String("description", ctx.Query("error_description")))
+		metrics.AuthFailures.WithLabelValues("sso_denied").Inc()
+		_ = ctx.Error(apierror.ErrSSODenied)
+		return
+	}
+	code := ctx.Query("code")
+	if code == "" {
+		_ = ctx.Error(apierror.ErrSSOFlowInvalid.Wrap(errors.New("missing code")))
+		return
+	}
+
+	identity, err := h.Provider.Exchange(ctx.Request.Context(), code, flow.Verifier, flow.Nonce)
+	if err != nil {
+		slog.WarnContext(ctx.Request.Context(), "oidc code exchange failed", logger.Err(err))
+		metrics.AuthFailures.WithLabelValues("sso_failed").Inc()
+		_ = ctx.Error(apierror.ErrSSOFailed.Wrap(err))
+		return
+	}
+
+	user, err := h.resolveUser(ctx.Request.Context(), identity)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	totp, err := h.Auth.MFARepository.GetTOTP(ctx.Request.Context(), user.ID)
+	if err != nil && !errors.Is(err, repository.ErrTOTPNotFound) {
+		_ = ctx.Error(err)
+		return
+	}
+
+	fragment := url.Values{}
+	if totp.Enabled() {
+		challenge, err := h.Auth.issueMFAChallenge(ctx.Request.Context(), user)
+		if err != nil {
+			_ = ctx.Error(err)
+			return
+		}
+		fragment.Set("mfa_token", challenge)
+	} else {
+		token, _, err := h.Auth.startSession(ctx, user, "")
+		if err != nil {
+			_ = ctx.Error(err)
+			return
+		}
+		fragment.Set("token", token)
+	}
+
+	slog.InfoContext(ctx.Request.Context(), "user signed in with sso", logger.UserID(user.ID), slog.String("issuer", identity.Issuer), slog.Bool("mfa_required", totp.Enabled()))
+	ctx.Redirect(http.StatusFound, h.PostLoginURL+"#"+fragment.Encode())
+}
+
+// resolveUser finds the local account for identity: an existing link first,
+// then an account whose verified email matches the provider's verified
+// email, and finally a newly provisioned account when JIT provisioning is on.
+func (h *OIDCHandler) resolveUser(ctx context.Context, identity *oidc.Identity) (*models.User, error) {
+	userID, err := h.IdentityRepository.LoginIdentity(ctx, identity.Issuer, identity.Subject)
+	if err == nil {
+		return h.UserRepository.GetUserByID(ctx, userID)
+	}
+	if !errors.Is(err, repository.ErrIdentityNotFound) {
+		return nil, err
+	}
+
+	// linking on email is only safe when both sides proved ownership of it.
+	if identity.Email != "" && identity.EmailVerified {
+		user, err := h.UserRepository.GetUserByEmail(ctx, identity.Email)
+		if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+			return nil, err
+		}
+		if err == nil && user.EmailVerified() {
+			if err := h.link(ctx, user.ID, identity); err != nil {
+				return nil, err
+			}
+			return user, nil
+		}
+	}
+
+	if !h.JITProvisioning {
+		slog.InfoContext(ctx, "sso login for unknown identity with provisioning disabled", slog.String("issuer", identity.Issuer))
+		return nil, apierror.ErrSSONoAccount
+	}
+
+	user, err := h.provisionUser(ctx, identity)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.link(ctx, user.ID, identity); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (h *OIDCHandler) link(ctx context.Context, userID int64, identity *oidc.Identity) error {
+	err := h.IdentityRepository.LinkIdentity(ctx, userID, identity.Issuer, identity.Subject, identity.Email)
+	if errors.Is(err, repository.ErrIdentityLinked) {
+		// a concurrent callback for the same identity got there first.
+		return apierror.ErrSSOFailed.Wrap(err)
+	}
+	return err
+}
+
+// provisionUser creates an account for a first-time SSO user. The account
+// gets a random password nobody knows, so it can only sign in through SSO
+// until its owner sets one with the reset flow.
+func (h *OIDCHandler) provisionUser(ctx context.Context, identity *oidc.Identity) (*models.User, error) {
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return nil, fmt.Errorf("failed to generate password: %w", err)
+	}
+
+	email := ""
+	if identity.EmailVerified {
+		email = identity.Email
+	}
+
+	base := usernameCandidate(identity)
+	if len(h.Auth.UsernamePolicy.Check(base)) > 0 {
+		// e.g. a preferred_username of "admin"; the suffix below keeps it unique.
+		base = fallbackUsername
+	}
+	username := base
+	for attempt := 0; attempt < provisionAttempts; attempt++ {
+		user, err := h.UserRepository.CreateUser(ctx, username, email, hex.EncodeToString(password))
+		switch {
+		case err == nil:
+			if email != "" {
+				if err := h.UserRepository.MarkEmailVerified(ctx, user.ID, email); err != nil {
+					return nil, err
+				}
+			}
+			slog.InfoContext(ctx, "provisioned user from sso", logger.UserID(user.ID), slog.String("username", user.Username), slog.String("issuer", identity.Issuer))
+			return user, nil
+		case errors.Is(err, repository.ErrEmailTaken):
+			// the address belongs to an unverified local account; do not claim it.
+			email = ""
+		case errors.Is(err, repository.ErrUsernameTaken):
+			suffix, err := rand.Int(rand.Reader, big.NewInt(10000))
+			if err != nil {
+				return nil, err
+			}
+			username = fmt.Sprintf("%s_%04d", base, suffix.Int64())
+		default:
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("could not find a free username for sso user based on '%s'", base)
+}
+
+func usernameCandidate(identity *oidc.Identity) string {
+	base := identity.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(identity.Email, "@")
+	}
+	base = strings.Trim(usernameDisallowed.ReplaceAllString(base, ""), "_.-")
+	// leave room for the "_NNNN" suffix within the 20 character limit.
+	if len(base) > 15 {
+		base = base[:15]
+	}
+	if len(base) < 3 {
+		base = "user" + base
+	}
+	return base
+}
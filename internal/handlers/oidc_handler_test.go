@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/middleware"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/oidc"
+	"github.com/sokolawesome/chat-server/internal/oidc/oidctest"
+	"github.com/sokolawesome/chat-server/internal/repository"
+	"github.com/sokolawesome/chat-server/internal/username"
+)
+
+const testPostLoginURL = "http://chat.test/sso/done"
+
+// fakeUserRepository keeps users in memory. Methods the OIDC flow does not
+// use panic through the embedded nil interface.
+type fakeUserRepository struct {
+	repository.UserRepository
+
+	mu     sync.Mutex
+	users  map[int64]*models.User
+	nextID int64
+}
+
+func newFakeUserRepository(users ...*models.User) *fakeUserRepository {
+	r := &fakeUserRepository{users: map[int64]*models.User{}, nextID: 100}
+	for _, user := range users {
+		r.users[user.ID] = user
+	}
+	return r
+}
+
+func (r *fakeUserRepository) CreateUser(ctx context.Context, name string, email string, password string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if username.Normalize(user.Username) == username.Normalize(name) {
+			return nil, repository.ErrUsernameTaken
+		}
+		if email != "" && strings.EqualFold(user.Email, email) {
+			return nil, repository.ErrEmailTaken
+		}
+	}
+	r.nextID++
+	user := &models.User{ID: r.nextID, Username: name, Email: email, HashedPassword: password, CreatedAt: time.Now()}
+	r.users[user.ID] = user
+	return user, nil
+}
+
+func (r *fakeUserRepository) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok {
+		return nil, repository.ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (r *fakeUserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if strings.EqualFold(user.Email, email) {
+			return user, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+func (r *fakeUserRepository) MarkEmailVerified(ctx context.Context, id int64, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, ok := r.users[id]
+	if !ok || user.Email != email {
+		return repository.ErrUserNotFound
+	}
+	now := time.Now()
+	user.EmailVerifiedAt = &now
+	return nil
+}
+
+func (r *fakeUserRepository) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.users)
+}
+
+type fakeIdentityRepository struct {
+	mu    sync.Mutex
+	links map[string]int64
+}
+
+func newFakeIdentityRepository() *fakeIdentityRepository {
+	return &fakeIdentityRepository{links: map[string]int64{}}
+}
+
+func (r *fakeIdentityRepository) LoginIdentity(ctx context.Context, issuer string, subject string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	userID, ok := r.links[issuer+"|"+subject]
+	if !ok {
+		return 0, repository.ErrIdentityNotFound
+	}
+	return userID, nil
+}
+
+func (r *fakeIdentityRepository) LinkIdentity(ctx context.Context, userID int64, issuer string, subject string, email string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := issuer + "|" + subject
+	if _, ok := r.links[key]; ok {
+		return repository.ErrIdentityLinked
+	}
+	r.links[key] = userID
+	return nil
+}
+
+func (r *fakeIdentityRepository) linkedUser(issuer string, subject string) (int64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	userID, ok := r.links[issuer+"|"+subject]
+	return userID, ok
+}
+
+type fakeMFARepository struct {
+	repository.MFARepository
+}
+
+func (fakeMFARepository) GetTOTP(ctx context.Context, userID int64) (*models.TOTP, error) {
+	return nil, repository.ErrTOTPNotFound
+}
+
+type fakeSessionRepository struct {
+	repository.SessionRepository
+}
+
+func (fakeSessionRepository) CreateSession(ctx context.Context, userID int64, deviceName string, userAgent string, ipAddress string, expiresAt time.Time) (*models.Session, error) {
+	return &models.Session{ID: 1, UserID: userID, DeviceName: deviceName, UserAgent: userAgent, IPAddress: ipAddress, ExpiresAt: expiresAt}, nil
+}
+
+type oidcTestServer struct {
+	idp        *oidctest.Provider
+	router     *gin.Engine
+	users      *fakeUserRepository
+	identities *fakeIdentityRepository
+}
+
+func newOIDCTestServer(t *testing.T, jit bool, users ...*models.User) *oidcTestServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	idp := oidctest.New(t)
+	provider := oidc.NewProvider(oidc.Config{
+		IssuerURL:    idp.Issuer(),
+		ClientID:     oidctest.ClientID,
+		ClientSecret: oidctest.ClientSecret,
+		RedirectURL:  "http://chat.test/api/auth/oidc/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+	})
+
+	s := &oidcTestServer{idp: idp, users: newFakeUserRepository(users...), identities: newFakeIdentityRepository()}
+	auth := NewAuthHandler(s.users, fakeMFARepository{}, fakeSessionRepository{}, nil, nil, nil, username.NewPolicy(nil), nil, nil, false, "jwt-secret", time.Hour, "chat-server")
+	handler := NewOIDCHandler(provider, oidc.NewFlowCodec("jwt-secret"), s.users, s.identities, auth, testPostLoginURL, jit, false)
+
+	s.router = gin.New()
+	s.router.Use(middleware.ErrorHandler())
+	s.router.GET("/api/auth/oidc/login", handler.Login)
+	s.router.GET("/api/auth/oidc/callback", handler.Callback)
+	return s
+}
+
+// login runs the browser side of a sign-in up to the callback. tamper may
+// change the callback query before it is sent.
+func (s *oidcTestServer) login(t *testing.T, tamper func(query url.Values)) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/login", nil))
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("login returned %d: %s", recorder.Code, recorder.Body)
+	}
+	cookies := recorder.Result().Cookies()
+
+	code, state := s.idp.Authorize(t, recorder.Header().Get("Location"))
+	query := url.Values{"code": {code}, "state": {state}}
+	if tamper != nil {
+		tamper(query)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?"+query.Encode(), nil)
+	for _, cookie := range cookies {
+		request.AddCookie(cookie)
+	}
+	recorder = httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func assertSignedIn(t *testing.T, recorder *httptest.ResponseRecorder) {
+	t.Helper()
+
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("callback returned %d: %s", recorder.Code, recorder.Body)
+	}
+	location := recorder.Header().Get("Location")
+	base, fragment, _ := strings.Cut(location, "#")
+	values, err := url.ParseQuery(fragment)
+	if base != testPostLoginURL || err != nil || values.Get("token") == "" {
+		t.Fatalf("callback redirected to %q, want %s#token=...", location, testPostLoginURL)
+	}
+}
+
+func assertAPIError(t *testing.T, recorder *httptest.ResponseRecorder, want *apierror.Error) {
+	t.Helper()
+
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %d has body %q", recorder.Code, recorder.Body)
+	}
+	if recorder.Code != want.Status || body.Error.Code != want.Code {
+		t.Fatalf("response = %d %s, want %d %s", recorder.Code, body.Error.Code, want.Status, want.Code)
+	}
+}
+
+func verifiedUser(id int64, name string, email string) *models.User {
+	verifiedAt := time.Now()
+	return &models.User{ID: id, Username: name, Email: email, EmailVerifiedAt: &verifiedAt}
+}
+
+func TestOIDCCallbackRejectsStateMismatch(t *testing.T) {
+	s := newOIDCTestServer(t, true)
+
+	recorder := s.login(t, func(query url.Values) { query.Set("state", "forged-state") })
+
+	assertAPIError(t, recorder, apierror.ErrSSOFlowInvalid)
+	if s.users.count() != 0 {
+		t.Error("a user was provisioned despite the state mismatch")
+	}
+}
+
+func TestOIDCCallbackRejectsMissingFlowCookie(t *testing.T) {
+	s := newOIDCTestServer(t, true)
+
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?code=abc&state=xyz", nil))
+
+	assertAPIError(t, recorder, apierror.ErrSSOFlowInvalid)
+}
+
+func TestOIDCCallbackRejectsNonceMismatch(t *testing.T) {
+	s := newOIDCTestServer(t, true)
+	s.idp.OverrideNonce("nonce-of-another-login")
+
+	recorder := s.login(t, nil)
+
+	assertAPIError(t, recorder, apierror.ErrSSOFailed)
+	if s.users.count() != 0 {
+		t.Error("a user was provisioned despite the nonce mismatch")
+	}
+}
+
+func TestOIDCCallbackResolvesUser(t *testing.T) {
+	tests := []struct {
+		name  string
+		jit   bool
+		local *models.User
+		idp   oidctest.User
+		// wantUser is the local user the identity must be linked to; 0
+		// means a newly provisioned one and -1 means no sign-in at all.
+		wantUser  int64
+		wantError *apierror.Error
+	}{
+		{
+			name:     "links verified email to existing user",
+			local:    verifiedUser(1, "alice", "alice@example.com"),
+			idp:      oidctest.User{Subject: "s1", Email: "Alice@example.com", EmailVerified: true, PreferredUsername: "alice"},
+			wantUser: 1,
+		},
+		{
+			name:      "unverified provider email is not linked",
+			local:     verifiedUser(1, "alice", "alice@example.com"),
+			idp:       oidctest.User{Subject: "s1", Email: "alice@example.com", EmailVerified: false},
+			wantUser:  -1,
+			wantError: apierror.ErrSSONoAccount,
+		},
+		{
+			name:     "unverified provider email provisions a separate account",
+			jit:      true,
+			local:    verifiedUser(1, "alice", "alice@example.com"),
+			idp:      oidctest.User{Subject: "s1", Email: "alice@example.com", EmailVerified: false, PreferredUsername: "alice"},
+			wantUser: 0,
+		},
+		{
+			name:      "unverified local email is not linked",
+			local:     &models.User{ID: 1, Username: "alice", Email: "alice@example.com"},
+			idp:       oidctest.User{Subject: "s1", Email: "alice@example.com", EmailVerified: true},
+			wantUser:  -1,
+			wantError: apierror.ErrSSONoAccount,
+		},
+		{
+			name:      "unknown identity without provisioning",
+			idp:       oidctest.User{Subject: "s1", Email: "carol@example.com", EmailVerified: true, PreferredUsername: "carol"},
+			wantUser:  -1,
+			wantError: apierror.ErrSSONoAccount,
+		},
+		{
+			name:     "unknown identity with provisioning",
+			jit:      true,
+			idp:      oidctest.User{Subject: "s1", Email: "carol@example.com", EmailVerified: true, PreferredUsername: "carol"},
+			wantUser: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var locals []*models.User
+			if tt.local != nil {
+				locals = append(locals, tt.local)
+			}
+			s := newOIDCTestServer(t, tt.jit, locals...)
+			s.idp.SignIn(tt.idp)
+
+			recorder := s.login(t, nil)
+
+			linked, ok := s.identities.linkedUser(s.idp.Issuer(), tt.idp.Subject)
+			switch {
+			case tt.wantError != nil:
+				assertAPIError(t, recorder, tt.wantError)
+				if ok {
+					t.Fatalf("identity linked to user %d, want no link", linked)
+				}
+				if s.users.count() != len(locals) {
+					t.Fatal("a user was provisioned")
+				}
+				return
+			case !ok:
+				t.Fatal("identity was not linked")
+			}
+			assertSignedIn(t, recorder)
+
+			if tt.wantUser != 0 {
+				if linked != tt.wantUser {
+					t.Fatalf("identity linked to user %d, want %d", linked, tt.wantUser)
+				}
+				return
+			}
+
+			if tt.local != nil && linked == tt.local.ID {
+				t.Fatal("identity linked to the existing user, want a new one")
+			}
+			user, err := s.users.GetUserByID(context.Background(), linked)
+			if err != nil {
+				t.Fatalf("provisioned user: %v", err)
+			}
+			// a taken name gets a numeric suffix.
+			if !strings.HasPrefix(user.Username, tt.idp.PreferredUsername) {
+				t.Errorf("provisioned username = %q, want it based on %q", user.Username, tt.idp.PreferredUsername)
+			}
+			if tt.idp.EmailVerified {
+				if user.Email != tt.idp.Email || !user.EmailVerified() {
+					t.Errorf("provisioned email = %q (verified %v), want verified %q", user.Email, user.EmailVerified(), tt.idp.Email)
+				}
+			} else if user.Email != "" {
+				t.Errorf("provisioned email = %q, want none for an unverified address", user.Email)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackReusesLinkedIdentity(t *testing.T) {
+	s := newOIDCTestServer(t, true)
+	s.idp.SignIn(oidctest.User{Subject: "s1", Email: "dave@example.com", EmailVerified: true, PreferredUsername: "dave"})
+
+	assertSignedIn(t, s.login(t, nil))
+	first, _ := s.identities.linkedUser(s.idp.Issuer(), "s1")
+	assertSignedIn(t, s.login(t, nil))
+	second, _ := s.identities.linkedUser(s.idp.Issuer(), "s1")
+
+	if first != second || s.users.count() != 1 {
+		t.Fatalf("second sign-in linked user %d (users: %d), want %d and one user", second, s.users.count(), first)
+	}
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/loginguard"
+	"github.com/sokolawesome/chat-server/internal/mailer"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/password"
+	"github.com/sokolawesome/chat-server/internal/repository"
+	"github.com/sokolawesome/chat-server/internal/ws"
+)
+
+// mailTimeout bounds delivery of emails sent in the background after the
+// response has been written.
+const mailTimeout = 30 * time.Second
+
+type PasswordResetHandler struct {
+	UserRepository  repository.UserRepository
+	PasswordResets  repository.PasswordResetRepository
+	Sessions        repository.SessionRepository
+	Hub             *ws.Hub
+	PasswordPolicy  *password.Policy
+	Mailer          mailer.Mailer
+	LoginGuard      *loginguard.Guard
+	ResetURL        string
+	TokenExpiration time.Duration
+}
+
+func NewPasswordResetHandler(userRepository repository.UserRepository, passwordResets repository.PasswordResetRepository, sessions repository.SessionRepository, hub *ws.Hub, passwordPolicy *password.Policy, mailer mailer.Mailer, loginGuard *loginguard.Guard, resetURL string, tokenExpiration time.Duration) *PasswordResetHandler {
+	return &PasswordResetHandler{
+		UserRepository:  userRepository,
+		PasswordResets:  passwordResets,
+		Sessions:        sessions,
+		Hub:             hub,
+		PasswordPolicy:  passwordPolicy,
+		Mailer:          mailer,
+		LoginGuard:      loginGuard,
+		ResetURL:        resetURL,
+		TokenExpiration: tokenExpiration,
+	}
+}
+
+type ForgotPasswordRequest struct {
+	Username string `json:"username" binding:"required"`
+}
+
+// Forgot emails a reset link to the account's verified address. It answers
+// 202 the same way whether or not the account exists or has one, and sends
+// the email in the background, so the response reveals nothing about it.
+func (h *PasswordResetHandler) Forgot(ctx *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		slog.InfoContext(ctx.Request.Context(), "forgot password validation error", logger.Err(err))
+		_ = ctx.Error(apierror.FromBinding(err))
+		return
+	}
+
+	accepted := gin.H{"message": "If the account exists and has a verified email address, a reset link has been sent"}
+
+	user, err := h.UserRepository.GetUserByUsername(ctx.Request.Context(), req.Username)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			slog.InfoContext(ctx.Request.Context(), "password reset requested for non-existent username", slog.String("username", req.Username))
+			ctx.JSON(http.StatusAccepted, accepted)
+			return
+		}
+		_ = ctx.Error(err)
+		return
+	}
+	// an unverified address may be a typo pointing at someone else's inbox.
+	if !user.EmailVerified() {
+		slog.InfoContext(ctx.Request.Context(), "password reset requested for account without verified email", logger.UserID(user.ID))
+		ctx.JSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	token, tokenHash, err := newResetToken()
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	if err := h.PasswordResets.CreateToken(ctx.Request.Context(), user.ID, tokenHash, time.Now().Add(h.TokenExpiration)); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	go h.sendResetEmail(context.WithoutCancel(ctx.Request.Context()), user, token)
+
+	ctx.JSON(http.StatusAccepted, accepted)
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+// Reset sets a new password using a token from Forgot. The token is single
+// use, and the change revokes every token and session of the account.
+func (h *PasswordResetHandler) Reset(ctx *gin.Context) {
+	var req ResetPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		slog.InfoContext(ctx.Request.Context(), "reset password validation error", logger.Err(err))
+		_ = ctx.Error(apierror.FromBinding(err))
+		return
+	}
+	// checked before the token is spent, so a rejected password can be retried.
+	if !checkPasswordPolicy(ctx, h.PasswordPolicy, "new_password", req.NewPassword) {
+		return
+	}
+
+	userID, err := h.PasswordResets.ConsumeToken(ctx.Request.Context(), hashResetToken(req.Token))
+	if err != nil {
+		if errors.Is(err, repository.ErrResetTokenInvalid) {
+			slog.InfoContext(ctx.Request.Context(), "invalid password reset token used")
+		}
+		_ = ctx.Error(err)
+		return
+	}
+
+	if _, err := h.UserRepository.UpdatePassword(ctx.Request.Context(), userID, req.NewPassword); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	if _, err := revokeOtherSessions(ctx.Request.Context(), h.Sessions, h.Hub, userID, 0); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	// a successful reset proves ownership, so lift any lockout on the account.
+	h.LoginGuard.Reset(ctx.Request.Context(), loginguard.UserAccount(userID))
+
+	slog.InfoContext(ctx.Request.Context(), "password reset completed", logger.UserID(userID))
+	ctx.JSON(http.StatusOK, gin.H{"message": "Password has been reset"})
+}
+
+func (h *PasswordResetHandler) sendResetEmail(ctx context.Context, user *models.User, token string) {
+	ctx, cancel := context.WithTimeout(ctx, mailTimeout)
+	defer cancel()
+
+	link := h.ResetURL + "?token=" + url.QueryEscape(token)
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password for your account. If it was you, open this link within %s:\n\n%s\n\nIf you did not ask for this, you can ignore this email.\n",
+			user.Username, h.TokenExpiration, link),
+	}
+
+	if err := h.Mailer.Send(ctx, msg); err != nil {
+		slog.ErrorContext(ctx, "failed to send password reset email", logger.UserID(user.ID), logger.Err(err))
+		return
+	}
+	slog.InfoContext(ctx, "password reset email sent", logger.UserID(user.ID))
+}
+
+// newResetToken returns a random URL-safe token and the hash stored for it.
+func newResetToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate reset token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashResetToken(token), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
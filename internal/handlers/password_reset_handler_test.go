@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/loginguard"
+	"github.com/sokolawesome/chat-server/internal/mailer"
+	"github.com/sokolawesome/chat-server/internal/middleware"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/repository"
+	"github.com/sokolawesome/chat-server/internal/ws"
+)
+
+func (r *fakeUserRepository) GetUserByUsername(ctx context.Context, name string) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, user := range r.users {
+		if user.Username == name {
+			return user, nil
+		}
+	}
+	return nil, repository.ErrUserNotFound
+}
+
+type resetToken struct {
+	userID    int64
+	expiresAt time.Time
+	used      bool
+}
+
+// fakePasswordResetRepository follows the postgres repository: a token is
+// good once, until it expires, and using it spends the user's other tokens.
+type fakePasswordResetRepository struct {
+	mu     sync.Mutex
+	tokens map[string]*resetToken
+	now    time.Time
+}
+
+func newFakePasswordResetRepository() *fakePasswordResetRepository {
+	return &fakePasswordResetRepository{tokens: map[string]*resetToken{}, now: time.Now()}
+}
+
+func (r *fakePasswordResetRepository) CreateToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens[tokenHash] = &resetToken{userID: userID, expiresAt: expiresAt}
+	return nil
+}
+
+func (r *fakePasswordResetRepository) ConsumeToken(ctx context.Context, tokenHash string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, ok := r.tokens[tokenHash]
+	if !ok || token.used || !token.expiresAt.After(r.now) {
+		return 0, repository.ErrResetTokenInvalid
+	}
+	for _, other := range r.tokens {
+		if other.userID == token.userID {
+			other.used = true
+		}
+	}
+	return token.userID, nil
+}
+
+func (r *fakePasswordResetRepository) advance(d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.now = r.now.Add(d)
+}
+
+// fakeMailer hands sent messages to the test; Forgot sends in the background.
+type fakeMailer struct {
+	sent chan mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.sent <- msg
+	return nil
+}
+
+const testResetURL = "http://chat.test/reset-password"
+
+type resetTestServer struct {
+	router   *gin.Engine
+	users    *fakeUserRepository
+	resets   *fakePasswordResetRepository
+	sessions *memorySessionRepository
+	guard    *loginguard.Guard
+	mail     *fakeMailer
+}
+
+func newResetTestServer(t *testing.T) *resetTestServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	alice := userWithPassword(t, 7, "alice", "old-password")
+	alice.Email = "alice@example.com"
+	verifiedAt := time.Now()
+	alice.EmailVerifiedAt = &verifiedAt
+
+	s := &resetTestServer{
+		users:    newFakeUserRepository(alice),
+		resets:   newFakePasswordResetRepository(),
+		sessions: newMemorySessionRepository(),
+		guard: loginguard.New(repository.NewInMemoryLoginAttemptRepository(time.Hour), loginguard.Options{
+			MaxAttemptsPerUser: 1,
+			LockoutDuration:    time.Hour,
+			Window:             time.Hour,
+		}),
+		mail: &fakeMailer{sent: make(chan mailer.Message, 1)},
+	}
+	h := NewPasswordResetHandler(s.users, s.resets, s.sessions, ws.NewHub(), testPasswordPolicy(t), s.mail, s.guard, testResetURL, time.Hour)
+
+	s.router = gin.New()
+	s.router.Use(middleware.ErrorHandler())
+	s.router.POST("/password/forgot", h.Forgot)
+	s.router.POST("/password/reset", h.Reset)
+	return s
+}
+
+func (s *resetTestServer) post(path string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	s.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// forgot asks for a reset link for alice and returns the token it carries.
+func (s *resetTestServer) forgot(t *testing.T) string {
+	t.Helper()
+
+	if recorder := s.post("/password/forgot", `{"username":"alice"}`); recorder.Code != http.StatusAccepted {
+		t.Fatalf("forgot returned %d: %s", recorder.Code, recorder.Body)
+	}
+
+	var msg mailer.Message
+	select {
+	case msg = <-s.mail.sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reset email was sent")
+	}
+	if msg.To != "alice@example.com" {
+		t.Fatalf("reset email sent to %q, want alice@example.com", msg.To)
+	}
+
+	i := strings.Index(msg.Body, testResetURL+"?")
+	if i < 0 {
+		t.Fatalf("reset email has no link: %q", msg.Body)
+	}
+	link, err := url.Parse(strings.Fields(msg.Body[i:])[0])
+	if err != nil {
+		t.Fatalf("parsing reset link: %v", err)
+	}
+	return link.Query().Get("token")
+}
+
+func (s *resetTestServer) reset(token string, newPassword string) *httptest.ResponseRecorder {
+	return s.post("/password/reset", `{"token":"`+token+`","new_password":"`+newPassword+`"}`)
+}
+
+func (s *resetTestServer) passwordIs(t *testing.T, plaintext string) bool {
+	t.Helper()
+	user, err := s.users.GetUserByID(context.Background(), 7)
+	if err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	matches, err := testHasher.Verify(user.HashedPassword, plaintext)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	return matches
+}
+
+func TestPasswordResetIsSingleUse(t *testing.T) {
+	s := newResetTestServer(t)
+	token := s.forgot(t)
+
+	if recorder := s.reset(token, "new-password-1"); recorder.Code != http.StatusOK {
+		t.Fatalf("reset returned %d: %s", recorder.Code, recorder.Body)
+	}
+	if !s.passwordIs(t, "new-password-1") {
+		t.Fatal("password was not changed")
+	}
+
+	assertAPIError(t, s.reset(token, "new-password-2"), apierror.ErrResetTokenInvalid)
+	if !s.passwordIs(t, "new-password-1") {
+		t.Fatal("a spent token changed the password again")
+	}
+}
+
+func TestPasswordResetTokenExpires(t *testing.T) {
+	s := newResetTestServer(t)
+	token := s.forgot(t)
+
+	s.resets.advance(time.Hour + time.Second)
+	assertAPIError(t, s.reset(token, "new-password-1"), apierror.ErrResetTokenInvalid)
+	if !s.passwordIs(t, "old-password") {
+		t.Fatal("an expired token changed the password")
+	}
+}
+
+func TestPasswordResetRejectedPasswordKeepsToken(t *testing.T) {
+	s := newResetTestServer(t)
+	token := s.forgot(t)
+
+	assertAPIError(t, s.reset(token, "short"), apierror.ErrWeakPassword)
+	if recorder := s.reset(token, "new-password-1"); recorder.Code != http.StatusOK {
+		t.Fatalf("retry with a valid password returned %d: %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestPasswordResetRevokesSessionsAndLockout(t *testing.T) {
+	s := newResetTestServer(t)
+	ctx := context.Background()
+	s.sessions.add(7)
+	s.sessions.add(7)
+	stranger := s.sessions.add(8)
+
+	// an attacker guessed wrong and locked alice out.
+	account := loginguard.UserAccount(7)
+	if wait, err := s.guard.Attempt(ctx, account, "198.51.100.1"); err != nil || wait != 0 {
+		t.Fatalf("Attempt = %v, %v", wait, err)
+	}
+	s.guard.Failure(ctx, account, "198.51.100.1")
+	if wait, _ := s.guard.Attempt(ctx, account, "198.51.100.2"); wait == 0 {
+		t.Fatal("account was not locked")
+	}
+
+	if recorder := s.reset(s.forgot(t), "new-password-1"); recorder.Code != http.StatusOK {
+		t.Fatalf("reset returned %d: %s", recorder.Code, recorder.Body)
+	}
+
+	if got := s.sessions.of(7); len(got) != 0 {
+		t.Fatalf("sessions left after reset = %v, want none", got)
+	}
+	if got := s.sessions.of(8); len(got) != 1 || got[0] != stranger {
+		t.Fatalf("another user's sessions = %v, want [%d]", got, stranger)
+	}
+	if wait, err := s.guard.Attempt(ctx, account, "198.51.100.2"); err != nil || wait != 0 {
+		t.Fatalf("Attempt after reset = %v, %v, want the lockout lifted", wait, err)
+	}
+}
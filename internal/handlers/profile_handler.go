@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/avatar"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/middleware"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/repository"
+	"github.com/sokolawesome/chat-server/internal/ws"
+)
+
+const avatarFormField = "avatar"
+
+type ProfileHandler struct {
+	ProfileRepository repository.ProfileRepository
+	Hub               *ws.Hub
+	MaxAvatarBytes    int
+	// AvatarBaseURL prefixes the avatar URLs in profiles; empty keeps them
+	// relative to this server.
+	AvatarBaseURL string
+}
+
+func NewProfileHandler(profileRepository repository.ProfileRepository, hub *ws.Hub, maxAvatarBytes int, avatarBaseURL string) *ProfileHandler {
+	return &ProfileHandler{
+		ProfileRepository: profileRepository,
+		Hub:               hub,
+		MaxAvatarBytes:    maxAvatarBytes,
+		AvatarBaseURL:     avatarBaseURL,
+	}
+}
+
+func (h *ProfileHandler) GetOwn(ctx *gin.Context) {
+	h.respond(ctx, ctx.GetInt64(middleware.AuthorizationPayloadKey))
+}
+
+func (h *ProfileHandler) Get(ctx *gin.Context) {
+	userID, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		_ = ctx.Error(apierror.ErrUserNotFound)
+		return
+	}
+	h.respond(ctx, userID)
+}
+
+func (h *ProfileHandler) respond(ctx *gin.Context, userID int64) {
+	profile, err := h.ProfileRepository.GetProfile(ctx.Request.Context(), userID)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	ctx.JSON(http.StatusOK, h.withAvatarURLs(profile))
+}
+
+// UpdateProfileRequest only changes the fields present in the body; send an
+// empty string to clear one.
+type UpdateProfileRequest struct {
+	DisplayName *string `json:"display_name" binding:"omitempty,max=50"`
+	Bio         *string `json:"bio" binding:"omitempty,max=500"`
+	Pronouns    *string `json:"pronouns" binding:"omitempty,max=40"`
+	Timezone    *string `json:"timezone" binding:"omitempty,max=64"`
+}
+
+func (h *ProfileHandler) Update(ctx *gin.Context) {
+	userID := ctx.GetInt64(middleware.AuthorizationPayloadKey)
+
+	var req UpdateProfileRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		_ = ctx.Error(apierror.FromBinding(err))
+		return
+	}
+
+	if req.Bio != nil {
+		bio := strings.ReplaceAll(*req.Bio, "\r\n", "\n")
+		req.Bio = &bio
+	}
+	update := models.ProfileUpdate{
+		DisplayName: trimmed(req.DisplayName),
+		Bio:         trimmed(req.Bio),
+		Pronouns:    trimmed(req.Pronouns),
+		Timezone:    trimmed(req.Timezone),
+	}
+	if details := validateProfileUpdate(update); len(details) > 0 {
+		_ = ctx.Error(apierror.ErrValidationFailed.WithDetails(details...))
+		return
+	}
+
+	profile, err := h.ProfileRepository.UpdateProfile(ctx.Request.Context(), userID, update)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, h.announce(ctx, profile))
+}
+
+// UploadAvatar accepts a multipart form with the image in the "avatar" field
+// and stores it at every size in avatar.Sizes.
+func (h *ProfileHandler) UploadAvatar(ctx *gin.Context) {
+	userID := ctx.GetInt64(middleware.AuthorizationPayloadKey)
+
+	data, err := h.readAvatar(ctx)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	images, err := avatar.Process(data)
+	if err != nil {
+		slog.InfoContext(ctx.Request.Context(), "avatar upload rejected", logger.UserID(userID), logger.Err(err))
+		_ = ctx.Error(err)
+		return
+	}
+
+	profile, err := h.ProfileRepository.SetAvatar(ctx.Request.Context(), userID, avatar.ContentType, images)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, h.announce(ctx, profile))
+}
+
+func (h *ProfileHandler) readAvatar(ctx *gin.Context) ([]byte, error) {
+	// leave room for the multipart boundaries and part headers.
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, int64(h.MaxAvatarBytes)+64<<10)
+
+	file, header, err := ctx.Request.FormFile(avatarFormField)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, apierror.ErrAvatarTooLarge
+		}
+		return nil, apierror.ErrValidationFailed.WithDetails(apierror.FieldError{
+			Field:   avatarFormField,
+			Rule:    "required",
+			Message: "is required",
+		}).Wrap(err)
+	}
+	defer file.Close()
+
+	if header.Size > int64(h.MaxAvatarBytes) {
+		return nil, apierror.ErrAvatarTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(file, int64(h.MaxAvatarBytes)+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read avatar upload: %w", err)
+	}
+	if len(data) > h.MaxAvatarBytes {
+		return nil, apierror.ErrAvatarTooLarge
+	}
+	return data, nil
+}
+
+func (h *ProfileHandler) DeleteAvatar(ctx *gin.Context) {
+	userID := ctx.GetInt64(middleware.AuthorizationPayloadKey)
+
+	profile, err := h.ProfileRepository.DeleteAvatar(ctx.Request.Context(), userID)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	h.announce(ctx, profile)
+	ctx.Status(http.StatusNoContent)
+}
+
+// Avatar serves one size of a user's avatar. It needs no authentication so
+// the URLs work in plain <img> tags.
+func (h *ProfileHandler) Avatar(ctx *gin.Context) {
+	userID, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		_ = ctx.Error(apierror.ErrAvatarNotFound)
+		return
+	}
+	size, err := strconv.Atoi(ctx.Param("size"))
+	if err != nil || !avatar.ValidSize(size) {
+		_ = ctx.Error(apierror.ErrAvatarNotFound)
+		return
+	}
+
+	image, err := h.ProfileRepository.GetAvatar(ctx.Request.Context(), userID, size)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	etag := fmt.Sprintf(`"%d-%d-%d"`, userID, size, image.UpdatedAt.UnixNano())
+	ctx.Header("ETag", etag)
+	ctx.Header("Cache-Control", "public, max-age=3600")
+	if ctx.GetHeader("If-None-Match") == etag {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	ctx.Data(http.StatusOK, image.ContentType, image.Data)
+}
+
+// announce fills in the avatar URLs and pushes the profile as a
+// profile_changed frame to the owner's connections.
+func (h *ProfileHandler) announce(ctx *gin.Context, profile *models.Profile) *models.Profile {
+	profile = h.withAvatarURLs(profile)
+	delivered := h.Hub.SendProfile(ctx.Request.Context(), profile)
+
+	slog.InfoContext(ctx.Request.Context(), "profile change announced", logger.UserID(profile.UserID), slog.Int("connections", delivered))
+	return profile
+}
+
+// withAvatarURLs links every stored avatar size. The version parameter
+// changes with each upload so caches never serve a replaced image.
+func (h *ProfileHandler) withAvatarURLs(profile *models.Profile) *models.Profile {
+	profile.Avatars = map[string]string{}
+	if profile.AvatarUpdatedAt == nil {
+		return profile
+	}
+	for _, size := range avatar.Sizes {
+		profile.Avatars[strconv.Itoa(size)] = fmt.Sprintf("%s/api/users/%d/avatar/%d?v=%d", h.AvatarBaseURL, profile.UserID, size, profile.AvatarUpdatedAt.Unix())
+	}
+	return profile
+}
+
+func validateProfileUpdate(update models.ProfileUpdate) []apierror.FieldError {
+	var details []apierror.FieldError
+
+	if update.DisplayName != nil && strings.IndexFunc(*update.DisplayName, unicode.IsControl) >= 0 {
+		details = append(details, apierror.FieldError{Field: "display_name", Rule: "printable", Message: "must not contain control characters"})
+	}
+	if update.Pronouns != nil && strings.IndexFunc(*update.Pronouns, unicode.IsControl) >= 0 {
+		details = append(details, apierror.FieldError{Field: "pronouns", Rule: "printable", Message: "must not contain control characters"})
+	}
+	if update.Bio != nil && strings.IndexFunc(*update.Bio, func(r rune) bool { return unicode.IsControl(r) && r != '\n' }) >= 0 {
+		details = append(details, apierror.FieldError{Field: "bio", Rule: "printable", Message: "must not contain control characters other than line breaks"})
+	}
+	if update.Timezone != nil && *update.Timezone != "" {
+		// "Local" would resolve to whatever zone the server runs in.
+		if _, err := time.LoadLocation(*update.Timezone); err != nil || *update.Timezone == "Local" {
+			details = append(details, apierror.FieldError{Field: "timezone", Rule: "timezone", Message: "must be an IANA time zone such as Europe/Berlin"})
+		}
+	}
+
+	return details
+}
+
+func trimmed(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	s := strings.TrimSpace(*value)
+	return &s
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/middleware"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/repository"
+	"github.com/sokolawesome/chat-server/internal/ws"
+)
+
+const (
+	// maxDeviceNameRunes matches the device_name column.
+	maxDeviceNameRunes = 100
+	// maxUserAgentRunes caps the raw header kept with a session; anything
+	// longer is not useful for recognising a device.
+	maxUserAgentRunes = 512
+)
+
+type SessionHandler struct {
+	SessionRepository repository.SessionRepository
+	Hub               *ws.Hub
+}
+
+func NewSessionHandler(sessionRepository repository.SessionRepository, hub *ws.Hub) *SessionHandler {
+	return &SessionHandler{
+		SessionRepository: sessionRepository,
+		Hub:               hub,
+	}
+}
+
+type SessionResponse struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+func (h *SessionHandler) List(ctx *gin.Context) {
+	userID := ctx.GetInt64(middleware.AuthorizationPayloadKey)
+	currentID := ctx.GetInt64(middleware.SessionPayloadKey)
+
+	sessions, err := h.SessionRepository.ListSessions(ctx.Request.Context(), userID)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	response := make([]SessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = SessionResponse{Session: session, Current: session.ID == currentID}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"sessions": response})
+}
+
+// Revoke signs out one session, which may be the caller's own, and closes
+// its WebSocket connections.
+func (h *SessionHandler) Revoke(ctx *gin.Context) {
+	userID := ctx.GetInt64(middleware.AuthorizationPayloadKey)
+
+	sessionID, err := strconv.ParseInt(ctx.Param("id"), 10, 64)
+	if err != nil {
+		_ = ctx.Error(apierror.ErrSessionNotFound)
+		return
+	}
+
+	if err := h.SessionRepository.DeleteSession(ctx.Request.Context(), userID, sessionID); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+	closed := h.Hub.DisconnectSession(userID, sessionID)
+
+	slog.InfoContext(ctx.Request.Context(), "session revoked by owner", logger.UserID(userID), slog.Int64("session_id", sessionID), slog.Int("connections_closed", closed))
+	ctx.Status(http.StatusNoContent)
+}
+
+// RevokeOthers signs out every session except the caller's.
+func (h *SessionHandler) RevokeOthers(ctx *gin.Context) {
+	userID := ctx.GetInt64(middleware.AuthorizationPayloadKey)
+	currentID := ctx.GetInt64(middleware.SessionPayloadKey)
+
+	revoked, err := revokeOtherSessions(ctx.Request.Context(), h.SessionRepository, h.Hub, userID, currentID)
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
+
+// revokeOtherSessions deletes every session of the user except
+// keepSessionID (all of them when it is 0) and closes their WebSocket
+// connections. It returns the number of sessions deleted.
+func revokeOtherSessions(ctx context.Context, sessionRepository repository.SessionRepository, hub *ws.Hub, userID int64, keepSessionID int64) (int, error) {
+	revoked, err := sessionRepository.DeleteOtherSessions(ctx, userID, keepSessionID)
+	if err != nil {
+		return 0, err
+	}
+	closed := hub.DisconnectOtherSessions(userID, keepSessionID)
+
+	slog.InfoContext(ctx, "sessions revoked", logger.UserID(userID), slog.Int("sessions", revoked), slog.Int("connections_closed", closed))
+	return revoked, nil
+}
+
+// describeUserAgent turns a User-Agent header into a short label such as
+// "Firefox on Windows" for sessions whose client did not name itself.
+func describeUserAgent(userAgent string) string {
+	if userAgent == "" {
+		return "Unknown device"
+	}
+
+	browser := ""
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "OPR/"):
+		browser = "Opera"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	}
+
+	platform := ""
+	switch {
+	case strings.Contains(userAgent, "iPhone"), strings.Contains(userAgent, "iPad"):
+		platform = "iOS"
+	case strings.Contains(userAgent, "Android"):
+		platform = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		platform = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		platform = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		platform = "Linux"
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+
+	// non-browser clients such as "curl/8.5.0" or "chat-bot/1.2": keep the
+	// product token, trimmed to fit the column.
+	product, _, _ := strings.Cut(cleanUserAgent(userAgent, maxDeviceNameRunes), " ")
+	return product
+}
+
+// cleanUserAgent makes a User-Agent header safe to store: headers may carry
+// arbitrary bytes, which Postgres refuses in text columns unless they are
+// valid UTF-8. Invalid sequences become U+FFFD and the result is cut to at
+// most maxRunes characters.
+func cleanUserAgent(userAgent string, maxRunes int) string {
+	userAgent = strings.ToValidUTF8(userAgent, "\uFFFD")
+	if utf8.RuneCountInString(userAgent) <= maxRunes {
+		return userAgent
+	}
+	return string([]rune(userAgent)[:maxRunes])
+}
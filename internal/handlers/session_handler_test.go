@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestDescribeUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{"empty", "", "Unknown device"},
+		{"firefox on windows", "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:124.0) Gecko/20100101 Firefox/124.0", "Firefox on Windows"},
+		{"safari on ios", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Mobile/15E148 Safari/604.1", "Safari on iOS"},
+		{"edge is not chrome", "Mozilla/5.0 (Windows NT 10.0) AppleWebKit/537.36 Chrome/123.0 Safari/537.36 Edg/123.0", "Edge on Windows"},
+		{"cli client", "curl/8.5.0", "curl/8.5.0"},
+		{"product token only", "chat-bot/1.2 (+https://example.com)", "chat-bot/1.2"},
+		{"invalid utf-8", "bot\xff\xfe/1.0", "bot�/1.0"},
+		{"long multibyte product", strings.Repeat("ж", 150), strings.Repeat("ж", maxDeviceNameRunes)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := describeUserAgent(tt.userAgent)
+			if got != tt.want {
+				t.Errorf("describeUserAgent(%q) = %q, want %q", tt.userAgent, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("describeUserAgent(%q) returned invalid UTF-8", tt.userAgent)
+			}
+		})
+	}
+}
+
+func TestCleanUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		maxRunes  int
+		want      string
+	}{
+		{"unchanged", "curl/8.5.0", 20, "curl/8.5.0"},
+		{"invalid bytes replaced", "a\xc3(b", 20, "a�(b"},
+		{"cut by runes not bytes", "日本語のクライアント", 3, "日本語"},
+		{"cut after replacing", "\xff\xff\xffabc", 2, "�a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := cleanUserAgent(tt.userAgent, tt.maxRunes)
+			if got != tt.want {
+				t.Errorf("cleanUserAgent(%q, %d) = %q, want %q", tt.userAgent, tt.maxRunes, got, tt.want)
+			}
+		})
+	}
+}
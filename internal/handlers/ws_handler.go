@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/apikey"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/metrics"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/repository"
+	"github.com/sokolawesome/chat-server/internal/requestid"
+	"github.com/sokolawesome/chat-server/internal/ws"
+)
+
+type WsHandler struct {
+	JwtSecret      string
+	UserRepository repository.UserRepository
+	// APIKeyRepository lets bots connect with an API key holding the ws
+	// scope in place of a JWT.
+	APIKeyRepository  repository.APIKeyRepository
+	SessionRepository repository.SessionRepository
+	Upgrader          *websocket.Upgrader
+	Hub               *ws.Hub
+	ClientOptions     ws.Options
+
+	// RequireVerifiedEmail keeps users out of chat until they confirm
+	// their email address.
+	RequireVerifiedEmail bool
+}
+
+func NewWsHandler(jwtSecret string, userRepository repository.UserRepository, apiKeyRepository repository.APIKeyRepository, sessionRepository repository.SessionRepository, requireVerifiedEmail bool, upgrader *websocket.Upgrader, hub *ws.Hub, clientOptions ws.Options) *WsHandler {
+	return &WsHandler{
+		JwtSecret:         jwtSecret,
+		UserRepository:    userRepository,
+		APIKeyRepository:  apiKeyRepository,
+		SessionRepository: sessionRepository,
+		Upgrader:          upgrader,
+		Hub:               hub,
+		ClientOptions:     clientOptions,
+
+		RequireVerifiedEmail: requireVerifiedEmail,
+	}
+}
+
+func (h *WsHandler) Handle(ctx *gin.Context) {
+	if h.Hub.Draining() {
+		slog.InfoContext(ctx.Request.Context(), "rejecting websocket connection: server is draining", slog.String("remote_addr", ctx.Request.RemoteAddr))
+		_ = ctx.Error(apierror.ErrDraining)
+		ctx.Abort()
+		return
+	}
+
+	tokenString := ctx.Query("token")
+	if tokenString == "" {
+		slog.InfoContext(ctx.Request.Context(), "missing token in query parameters")
+		metrics.AuthFailures.WithLabelValues("missing_token").Inc()
+		_ = ctx.Error(apierror.ErrMissingToken)
+		ctx.Abort()
+		return
+	}
+
+	authenticate := h.authenticateJWT
+	if apikey.IsKey(tokenString) {
+		authenticate = h.authenticateAPIKey
+	}
+	principal, ok := authenticate(ctx, tokenString)
+	if !ok {
+		ctx.Abort()
+		return
+	}
+	userID := principal.userID
+
+	connID := requestid.New()
+	responseHeader := http.Header{"X-Connection-ID": []string{connID}}
+
+	conn, err := h.Upgrader.Upgrade(ctx.Writer, ctx.Request, responseHeader)
+	if err != nil {
+		slog.WarnContext(ctx.Request.Context(), "failed to upgrade connection", logger.UserID(userID), slog.String("remote_addr", ctx.Request.RemoteAddr), logger.Err(err))
+		return
+	}
+
+	slog.InfoContext(ctx.Request.Context(), "websocket client connected", logger.UserID(userID), slog.String(logger.KeyConnID, connID), slog.String("remote_addr", conn.RemoteAddr().String()))
+
+	client := ws.NewClient(ctx.Request.Context(), conn, connID, userID, principal.sessionID, principal.apiKeyID, h.ClientOptions)
+	if !h.Hub.Register(client) {
+		client.Close(websocket.CloseGoingAway, "server shutting down")
+		return
+	}
+	defer h.Hub.Unregister(client)
+
+	client.Serve()
+}
+
+// wsPrincipal is who a connection was authenticated as. The session and API
+// key IDs let the hub close the connection when either is revoked.
+type wsPrincipal struct {
+	userID    int64
+	sessionID int64
+	apiKeyID  int64
+}
+
+// authenticateJWT validates an access token and its version against the
+// user's current one. On failure it records the error and returns false.
+func (h *WsHandler) authenticateJWT(ctx *gin.Context, tokenString string) (wsPrincipal, bool) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return []byte(h.JwtSecret), nil
+	})
+
+	if err != nil {
+		slog.InfoContext(ctx.Request.Context(), "jwt parsing/validation error", logger.Err(err))
+		apiErr, reason := apierror.ErrInvalidToken, "invalid_token"
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			apiErr, reason = apierror.ErrTokenExpired, "token_expired"
+		}
+		metrics.AuthFailures.WithLabelValues(reason).Inc()
+		_ = ctx.Error(apiErr.Wrap(err))
+		return wsPrincipal{}, false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		slog.InfoContext(ctx.Request.Context(), "invalid token (claims invalid or token marked invalid)")
+		metrics.AuthFailures.WithLabelValues("invalid_token").Inc()
+		_ = ctx.Error(apierror.ErrInvalidToken)
+		return wsPrincipal{}, false
+	}
+	userIDF64, okSub := claims["sub"].(float64)
+	if !okSub {
+		slog.InfoContext(ctx.Request.Context(), "invalid token payload (missing/invalid sub claim)")
+		metrics.AuthFailures.WithLabelValues("invalid_claims").Inc()
+		_ = ctx.Error(apierror.ErrInvalidToken)
+		return wsPrincipal{}, false
+	}
+	userID := int64(userIDF64)
+
+	tokenVersion, _ := claims["ver"].(float64)
+	user, err := h.UserRepository.GetUserByID(ctx.Request.Context(), userID)
+	if err != nil && !errors.Is(err, repository.ErrUserNotFound) {
+		_ = ctx.Error(err)
+		return wsPrincipal{}, false
+	}
+	if err != nil || int(tokenVersion) != user.TokenVersion {
+		slog.InfoContext(ctx.Request.Context(), "revoked token used for websocket connection", logger.UserID(userID))
+		metrics.AuthFailures.WithLabelValues("token_revoked").Inc()
+		_ = ctx.Error(apierror.ErrTokenRevoked)
+		return wsPrincipal{}, false
+	}
+	if !h.checkVerifiedEmail(ctx, user) {
+		return wsPrincipal{}, false
+	}
+
+	var sessionID int64
+	if sessionIDF64, hasSession := claims["sid"].(float64); hasSession {
+		sessionID = int64(sessionIDF64)
+		if err := h.SessionRepository.TouchSession(ctx.Request.Context(), userID, sessionID); err != nil {
+			if errors.Is(err, repository.ErrSessionNotFound) {
+				slog.InfoContext(ctx.Request.Context(), "token of revoked session used for websocket connection", logger.UserID(userID))
+				metrics.AuthFailures.WithLabelValues("session_revoked").Inc()
+				_ = ctx.Error(apierror.ErrTokenRevoked)
+				return wsPrincipal{}, false
+			}
+			_ = ctx.Error(err)
+			return wsPrincipal{}, false
+		}
+	}
+
+	slog.DebugContext(ctx.Request.Context(), "user authorized for websocket connection", logger.UserID(userID))
+	return wsPrincipal{userID: userID, sessionID: sessionID}, true
+}
+
+// authenticateAPIKey accepts keys that carry the ws scope.
+func (h *WsHandler) authenticateAPIKey(ctx *gin.Context, rawKey string) (wsPrincipal, bool) {
+	key, err := h.APIKeyRepository.AuthenticateAPIKey(ctx.Request.Context(), apikey.Hash(rawKey))
+	if err != nil {
+		if errors.Is(err, repository.ErrAPIKeyNotFound) {
+			slog.InfoContext(ctx.Request.Context(), "unknown api key used for websocket connection")
+			metrics.AuthFailures.WithLabelValues("invalid_api_key").Inc()
+			_ = ctx.Error(apierror.ErrInvalidAPIKey)
+			return wsPrincipal{}, false
+		}
+		_ = ctx.Error(err)
+		return wsPrincipal{}, false
+	}
+	if key.Expired(time.Now()) {
+		slog.InfoContext(ctx.Request.Context(), "expired api key used for websocket connection", logger.UserID(key.UserID), slog.Int64("api_key_id", key.ID))
+		metrics.AuthFailures.WithLabelValues("api_key_expired").Inc()
+		_ = ctx.Error(apierror.ErrAPIKeyExpired)
+		return wsPrincipal{}, false
+	}
+	if !key.HasScope(apikey.ScopeWebSocket) {
+		slog.InfoContext(ctx.Request.Context(), "api key without ws scope used for websocket connection", logger.UserID(key.UserID), slog.Int64("api_key_id", key.ID))
+		metrics.AuthFailures.WithLabelValues("insufficient_scope").Inc()
+		_ = ctx.Error(apierror.ErrInsufficientScope)
+		return wsPrincipal{}, false
+	}
+
+	user, err := h.UserRepository.GetUserByID(ctx.Request.Context(), key.UserID)
+	if err != nil {
+		_ = ctx.Error(err)
+		return wsPrincipal{}, false
+	}
+	if !h.checkVerifiedEmail(ctx, user) {
+		return wsPrincipal{}, false
+	}
+
+	slog.DebugContext(ctx.Request.Context(), "api key authorized for websocket connection", logger.UserID(key.UserID), slog.Int64("api_key_id", key.ID))
+	return wsPrincipal{userID: key.UserID, apiKeyID: key.ID}, true
+}
+
+func (h *WsHandler) checkVerifiedEmail(ctx *gin.Context, user *models.User) bool {
+	if h.RequireVerifiedEmail && !user.EmailVerified() {
+		slog.InfoContext(ctx.Request.Context(), "websocket connection refused: email not verified", logger.UserID(user.ID))
+		_ = ctx.Error(apierror.ErrEmailNotVerified)
+		return false
+	}
+	return true
+}
@@ -1,8 +1,20 @@
 package logger
 
 import (
+	"context"
 	"log/slog"
 	"os"
+	"slices"
+)
+
+// Attribute keys shared by every package so log lines can be filtered and
+// joined consistently.
+const (
+	KeyUserID    = "user_id"
+	KeyRoomID    = "room_id"
+	KeyConnID    = "conn_id"
+	KeyRequestID = "request_id"
+	KeyError     = "error"
 )
 
 var (
@@ -15,7 +27,7 @@ func Init(level slog.Level) {
 		AddSource: true,
 	}
 
-	handler := slog.NewJSONHandler(os.Stdout, opts)
+	handler := contextHandler{slog.NewJSONHandler(os.Stdout, opts)}
 	Log = slog.New(handler)
 
 	slog.SetDefault(Log)
@@ -35,3 +47,42 @@ func LevelFromString(level string) slog.Level {
 		return slog.LevelInfo
 	}
 }
+
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
+
+func UserID(userID int64) slog.Attr {
+	return slog.Int64(KeyUserID, userID)
+}
+
+type attrsKey struct{}
+
+// WithAttrs returns a context whose log records carry attrs in addition to
+// any attached by parent contexts. Use it for correlation fields such as
+// request_id and conn_id that every log line on a code path should share.
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return context.WithValue(ctx, attrsKey{}, append(slices.Clip(existing), attrs...))
+}
+
+// contextHandler adds the attributes stored by WithAttrs to each record
+// logged through the *Context slog functions.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if attrs, ok := ctx.Value(attrsKey{}).([]slog.Attr); ok {
+		record.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,243 @@
+package loginguard
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/metrics"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/repository"
+	"github.com/sokolawesome/chat-server/internal/username"
+)
+
+const (
+	scopeUser = "user"
+	scopeIP   = "ip"
+)
+
+// errThrottled rolls back the store update when an attempt is refused.
+var errThrottled = errors.New("login attempt throttled")
+
+type Options struct {
+	MaxAttemptsPerUser int
+	MaxAttemptsPerIP   int
+	BackoffBase        time.Duration
+	BackoffMax         time.Duration
+	LockoutDuration    time.Duration
+	// Window is how long a failure counts towards the threshold; a failure
+	// after a quiet period longer than Window starts the count again.
+	Window time.Duration
+}
+
+// Account names the account a login attempt targets. Attempts on an
+// existing account are counted by user ID, so its username and email share
+// one counter; identifiers that match no account are counted by their
+// normalized spelling.
+type Account struct {
+	userID     int64
+	identifier string
+}
+
+func UserAccount(userID int64) Account {
+	return Account{userID: userID}
+}
+
+// UnknownAccount folds identifier the same way the users table does, so
+// look-alike spellings share a single counter.
+func UnknownAccount(identifier string) Account {
+	return Account{identifier: username.Normalize(identifier)}
+}
+
+func (a Account) key() string {
+	if a.userID != 0 {
+		return scopeUser + ":id:" + strconv.FormatInt(a.userID, 10)
+	}
+	return scopeUser + ":name:" + a.identifier
+}
+
+// Guard throttles password attempts per account and per client IP. Every
+// failure delays the next attempt exponentially, and reaching the threshold
+// for either key locks it for LockoutDuration.
+//
+// An attempt is counted towards the threshold when it starts, in the same
+// atomic update that checks it is allowed, so parallel requests cannot all
+// slip through before the first failure is recorded. Attempts that turn out
+// not to have failed are taken back with Release or Success. The backoff
+// delay only starts from a confirmed failure, reported with Failure, so a
+// correct login does not hold up the next one from the same IP.
+type Guard struct {
+	store repository.LoginAttemptRepository
+	opts  Options
+	now   func() time.Time
+}
+
+func New(store repository.LoginAttemptRepository, opts Options) *Guard {
+	return &Guard{store: store, opts: opts, now: time.Now}
+}
+
+type key struct {
+	scope       string
+	value       string
+	maxAttempts int
+}
+
+func (g *Guard) keys(account Account, ip string) []key {
+	return []key{
+		{scope: scopeUser, value: account.key(), maxAttempts: g.opts.MaxAttemptsPerUser},
+		{scope: scopeIP, value: scopeIP + ":" + ip, maxAttempts: g.opts.MaxAttemptsPerIP},
+	}
+}
+
+func values(keys []key) []string {
+	values := make([]string, len(keys))
+	for i, k := range keys {
+		values[i] = k.value
+	}
+	return values
+}
+
+// Attempt starts a login attempt on account from ip. It returns how long the
+// caller has to wait when the attempt is not allowed yet; zero means it may
+// proceed and now counts towards the threshold until released.
+func (g *Guard) Attempt(ctx context.Context, account Account, ip string) (time.Duration, error) {
+	now := g.now()
+	keys := g.keys(account, ip)
+
+	type lockout struct {
+		scope string
+		*models.LoginLockout
+	}
+	var wait time.Duration
+	var lockouts []lockout
+	err := g.store.UpdateAttempts(ctx, values(keys), func(attempts []*models.LoginAttempts) error {
+		wait, lockouts = 0, nil
+		for _, attempts := range attempts {
+			if d := g.allowedAt(attempts, now).Sub(now); d > wait {
+				wait = d
+			}
+		}
+		if wait > 0 {
+			return errThrottled
+		}
+
+		for i, attempts := range attempts {
+			if attempts.LastAttemptAt.Before(now.Add(-g.opts.Window)) {
+				attempts.Failures = 0
+			}
+			attempts.Failures++
+			attempts.LastAttemptAt = now
+
+			if k := keys[i]; k.maxAttempts > 0 && attempts.Failures >= k.maxAttempts {
+				attempts.LockedUntil = now.Add(g.opts.LockoutDuration)
+				lockouts = append(lockouts, lockout{scope: k.scope, LoginLockout: &models.LoginLockout{Key: k.value, Failures: attempts.Failures, LockedUntil: attempts.LockedUntil}})
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, errThrottled) {
+		return wait, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, lockout := range lockouts {
+		if err := g.store.RecordLockout(ctx, lockout.LoginLockout); err != nil {
+			slog.ErrorContext(ctx, "failed to record login lockout", slog.String("key", lockout.Key), logger.Err(err))
+		}
+
+		metrics.LoginLockouts.WithLabelValues(lockout.scope).Inc()
+		slog.WarnContext(ctx, "login temporarily locked",
+			slog.String("key", lockout.Key),
+			slog.Int("failures", lockout.Failures),
+			slog.Time("locked_until", lockout.LockedUntil),
+		)
+	}
+
+	return 0, nil
+}
+
+func (g *Guard) allowedAt(attempts *models.LoginAttempts, now time.Time) time.Time {
+	allowedAt := attempts.LockedUntil
+	if attempts.Failures > 0 && !attempts.LastFailureAt.Before(now.Add(-g.opts.Window)) {
+		if next := attempts.LastFailureAt.Add(g.backoff(attempts.Failures)); next.After(allowedAt) {
+			allowedAt = next
+		}
+	}
+	return allowedAt
+}
+
+// Failure confirms that an attempt failed and starts the backoff delay
+// before the next one. The attempt itself was already counted by Attempt.
+func (g *Guard) Failure(ctx context.Context, account Account, ip string) {
+	now := g.now()
+	keys := g.keys(account, ip)
+
+	err := g.store.UpdateAttempts(ctx, values(keys), func(attempts []*models.LoginAttempts) error {
+		for _, attempts := range attempts {
+			attempts.LastFailureAt = now
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to record login failure", slog.String("key", keys[0].value), logger.Err(err))
+	}
+}
+
+// Release takes back an attempt that did not fail, such as a correct
+// password that still needs a second factor.
+func (g *Guard) Release(ctx context.Context, account Account, ip string) {
+	g.settle(ctx, account, ip, false)
+}
+
+// Success takes back the attempt and clears the account's failures. Earlier
+// failures from the IP still count, so a client cannot reset its counter by
+// logging into an account of its own in between guesses.
+func (g *Guard) Success(ctx context.Context, account Account, ip string) {
+	g.settle(ctx, account, ip, true)
+}
+
+// Reset clears the account's failures outside of a login, e.g. after the
+// owner proved control of the account by resetting its password.
+func (g *Guard) Reset(ctx context.Context, account Account) {
+	k := account.key()
+	if err := g.store.ResetAttempts(ctx, k); err != nil {
+		slog.ErrorContext(ctx, "failed to reset login attempts", slog.String("key", k), logger.Err(err))
+	}
+}
+
+// settle refunds the attempt counted by Attempt. Store errors are logged
+// rather than returned so they never fail a login that already succeeded.
+func (g *Guard) settle(ctx context.Context, account Account, ip string, resetAccount bool) {
+	keys := g.keys(account, ip)
+
+	err := g.store.UpdateAttempts(ctx, values(keys), func(attempts []*models.LoginAttempts) error {
+		for i, attempts := range attempts {
+			if i == 0 && resetAccount {
+				*attempts = models.LoginAttempts{Key: attempts.Key}
+				continue
+			}
+			attempts.Failures = max(attempts.Failures-1, 0)
+			// a lock this attempt triggered no longer has enough failures behind it.
+			if k := keys[i]; k.maxAttempts <= 0 || attempts.Failures < k.maxAttempts {
+				attempts.LockedUntil = time.Time{}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to release login attempt", slog.String("key", keys[0].value), logger.Err(err))
+	}
+}
+
+func (g *Guard) backoff(failures int) time.Duration {
+	delay := g.opts.BackoffBase
+	for i := 1; i < failures && delay < g.opts.BackoffMax; i++ {
+		delay *= 2
+	}
+	return min(delay, g.opts.BackoffMax)
+}
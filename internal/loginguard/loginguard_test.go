@@ -0,0 +1,296 @@
+package loginguard
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sokolawesome/chat-server/internal/repository"
+)
+
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+var testOptions = Options{
+	MaxAttemptsPerUser: 5,
+	MaxAttemptsPerIP:   20,
+	BackoffBase:        time.Second,
+	BackoffMax:         8 * time.Second,
+	LockoutDuration:    15 * time.Minute,
+	Window:             time.Hour,
+}
+
+func newTestGuard(opts Options) (*Guard, *fakeClock) {
+	// the store sweeps with the real clock, so the fake one starts at now
+	// and only moves forward.
+	clock := &fakeClock{now: time.Now()}
+	g := New(repository.NewInMemoryLoginAttemptRepository(opts.Window), opts)
+	g.now = clock.Now
+	return g, clock
+}
+
+func mustAttempt(t *testing.T, g *Guard, account Account, ip string) time.Duration {
+	t.Helper()
+	wait, err := g.Attempt(context.Background(), account, ip)
+	if err != nil {
+		t.Fatalf("Attempt: %v", err)
+	}
+	return wait
+}
+
+// mustFail makes an attempt that turns out to be a wrong guess.
+func mustFail(t *testing.T, g *Guard, account Account, ip string) time.Duration {
+	t.Helper()
+	wait := mustAttempt(t, g, account, ip)
+	if wait == 0 {
+		g.Failure(context.Background(), account, ip)
+	}
+	return wait
+}
+
+func TestBackoff(t *testing.T) {
+	g, _ := newTestGuard(testOptions)
+
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 8 * time.Second, 8 * time.Second}
+	for i, want := range want {
+		if got := g.backoff(i + 1); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestAttemptBacksOffAfterFailures(t *testing.T) {
+	g, clock := newTestGuard(testOptions)
+	account := UserAccount(1)
+
+	if wait := mustFail(t, g, account, "10.0.0.1"); wait != 0 {
+		t.Fatalf("first attempt waits %v, want 0", wait)
+	}
+	if wait := mustAttempt(t, g, account, "10.0.0.1"); wait != time.Second {
+		t.Fatalf("attempt right after a failure waits %v, want 1s", wait)
+	}
+
+	clock.Advance(time.Second)
+	if wait := mustFail(t, g, account, "10.0.0.1"); wait != 0 {
+		t.Fatalf("attempt after the backoff waits %v, want 0", wait)
+	}
+	if wait := mustAttempt(t, g, account, "10.0.0.2"); wait != 2*time.Second {
+		t.Fatalf("attempt from another IP waits %v, want the account's 2s", wait)
+	}
+}
+
+func TestSuccessfulLoginsDoNotBackOff(t *testing.T) {
+	g, clock := newTestGuard(testOptions)
+	ctx := context.Background()
+
+	// an attempt still checking its password does not hold up another one.
+	mustAttempt(t, g, UserAccount(2), "10.0.0.1")
+	if wait := mustAttempt(t, g, UserAccount(3), "10.0.0.1"); wait != 0 {
+		t.Fatalf("attempt beside an unsettled one waits %v, want 0", wait)
+	}
+	g.Success(ctx, UserAccount(2), "10.0.0.1")
+	g.Success(ctx, UserAccount(3), "10.0.0.1")
+
+	// an earlier wrong guess from the IP has served its backoff.
+	mustFail(t, g, UserAccount(9), "10.0.0.1")
+	clock.Advance(time.Second)
+
+	for i := 0; i < 2; i++ {
+		if wait := mustAttempt(t, g, UserAccount(1), "10.0.0.1"); wait != 0 {
+			t.Fatalf("successful login %d waits %v, want 0", i+1, wait)
+		}
+		g.Success(ctx, UserAccount(1), "10.0.0.1")
+	}
+}
+
+func TestAttemptLocksAtThreshold(t *testing.T) {
+	opts := testOptions
+	opts.BackoffBase, opts.BackoffMax = 0, 0
+	g, clock := newTestGuard(opts)
+	account := UserAccount(1)
+
+	for i := 0; i < opts.MaxAttemptsPerUser; i++ {
+		if wait := mustAttempt(t, g, account, "10.0.0.1"); wait != 0 {
+			t.Fatalf("attempt %d waits %v, want 0", i+1, wait)
+		}
+	}
+	if wait := mustAttempt(t, g, account, "10.0.0.2"); wait != opts.LockoutDuration {
+		t.Fatalf("attempt past the threshold waits %v, want %v", wait, opts.LockoutDuration)
+	}
+	if wait := mustAttempt(t, g, UserAccount(2), "10.0.0.2"); wait != 0 {
+		t.Fatalf("another account waits %v, want 0", wait)
+	}
+
+	clock.Advance(opts.LockoutDuration)
+	if wait := mustAttempt(t, g, account, "10.0.0.1"); wait != 0 {
+		t.Fatalf("attempt after the lockout waits %v, want 0", wait)
+	}
+}
+
+func TestAttemptLocksIP(t *testing.T) {
+	opts := testOptions
+	opts.BackoffBase, opts.BackoffMax, opts.MaxAttemptsPerIP = 0, 0, 3
+	g, _ := newTestGuard(opts)
+
+	// spraying one guess each over many accounts still trips the IP limit.
+	for i := int64(1); i <= 3; i++ {
+		mustAttempt(t, g, UserAccount(i), "10.0.0.1")
+	}
+	if wait := mustAttempt(t, g, UserAccount(4), "10.0.0.1"); wait != opts.LockoutDuration {
+		t.Fatalf("attempt from the sprayed IP waits %v, want %v", wait, opts.LockoutDuration)
+	}
+}
+
+func TestFailuresOutsideWindowAreForgotten(t *testing.T) {
+	opts := testOptions
+	opts.BackoffBase, opts.BackoffMax = 0, 0
+	g, clock := newTestGuard(opts)
+	account := UserAccount(1)
+
+	for i := 0; i < opts.MaxAttemptsPerUser-1; i++ {
+		mustAttempt(t, g, account, "10.0.0.1")
+	}
+	clock.Advance(opts.Window + time.Second)
+
+	// the count starts again, so this is failure 1 of 5, not 5 of 5.
+	mustAttempt(t, g, account, "10.0.0.1")
+	if wait := mustAttempt(t, g, account, "10.0.0.1"); wait != 0 {
+		t.Fatalf("attempt waits %v, want 0 after the window restarted the count", wait)
+	}
+}
+
+func TestSuccessResetsAccountButNotIP(t *testing.T) {
+	opts := testOptions
+	opts.BackoffBase, opts.BackoffMax, opts.MaxAttemptsPerIP = 0, 0, 6
+	g, _ := newTestGuard(opts)
+	account := UserAccount(1)
+
+	for i := 0; i < opts.MaxAttemptsPerUser-1; i++ {
+		mustAttempt(t, g, account, "10.0.0.1")
+	}
+	mustAttempt(t, g, account, "10.0.0.1")
+	g.Success(context.Background(), account, "10.0.0.1")
+
+	// the account starts over: four failures do not lock it.
+	for i := 0; i < opts.MaxAttemptsPerUser-1; i++ {
+		if wait := mustAttempt(t, g, account, "10.0.0.2"); wait != 0 {
+			t.Fatalf("attempt %d after success waits %v, want 0", i+1, wait)
+		}
+	}
+
+	// the IP kept its four failures; the successful attempt was refunded,
+	// so two more reach its limit of six.
+	mustAttempt(t, g, UserAccount(2), "10.0.0.1")
+	mustAttempt(t, g, UserAccount(2), "10.0.0.1")
+	if wait := mustAttempt(t, g, UserAccount(3), "10.0.0.1"); wait != opts.LockoutDuration {
+		t.Fatalf("IP waits %v, want %v", wait, opts.LockoutDuration)
+	}
+}
+
+func TestReleaseRefundsWithoutReset(t *testing.T) {
+	opts := testOptions
+	opts.BackoffBase, opts.BackoffMax = 0, 0
+	g, _ := newTestGuard(opts)
+	account := UserAccount(1)
+
+	for i := 0; i < opts.MaxAttemptsPerUser-1; i++ {
+		mustAttempt(t, g, account, "10.0.0.1")
+	}
+	// a correct password needing MFA neither counts nor clears failures.
+	for i := 0; i < 3; i++ {
+		mustAttempt(t, g, account, "10.0.0.1")
+		g.Release(context.Background(), account, "10.0.0.1")
+	}
+
+	mustAttempt(t, g, account, "10.0.0.1")
+	if wait := mustAttempt(t, g, account, "10.0.0.1"); wait != opts.LockoutDuration {
+		t.Fatalf("attempt waits %v, want the lockout after the fifth failure", wait)
+	}
+}
+
+func TestReleaseLiftsLockItTriggered(t *testing.T) {
+	opts := testOptions
+	opts.BackoffBase, opts.BackoffMax = 0, 0
+	g, _ := newTestGuard(opts)
+	account := UserAccount(1)
+
+	for i := 0; i < opts.MaxAttemptsPerUser; i++ {
+		mustAttempt(t, g, account, "10.0.0.1")
+	}
+	// the fifth attempt locked the account but had the right password.
+	g.Release(context.Background(), account, "10.0.0.1")
+
+	if wait := mustAttempt(t, g, account, "10.0.0.1"); wait != 0 {
+		t.Fatalf("attempt waits %v, want 0 after the locking attempt was released", wait)
+	}
+}
+
+func TestResetClearsAccount(t *testing.T) {
+	opts := testOptions
+	opts.BackoffBase, opts.BackoffMax = 0, 0
+	g, _ := newTestGuard(opts)
+	account := UserAccount(1)
+
+	for i := 0; i < opts.MaxAttemptsPerUser; i++ {
+		mustAttempt(t, g, account, "10.0.0.1")
+	}
+	g.Reset(context.Background(), account)
+
+	if wait := mustAttempt(t, g, account, "10.0.0.2"); wait != 0 {
+		t.Fatalf("attempt after reset waits %v, want 0", wait)
+	}
+}
+
+func TestAccountKeys(t *testing.T) {
+	if UserAccount(7).key() == UserAccount(8).key() {
+		t.Error("different user IDs share a key")
+	}
+	if UnknownAccount("Alice").key() != UnknownAccount("аlice").key() {
+		t.Error("look-alike spellings of an unknown name get different keys")
+	}
+	if UnknownAccount("7").key() == UserAccount(7).key() {
+		t.Error("unknown name collides with a user ID key")
+	}
+}
+
+// TestAttemptIsAtomic fires parallel attempts at one account: exactly the
+// threshold gets through, however they interleave.
+func TestAttemptIsAtomic(t *testing.T) {
+	opts := testOptions
+	opts.BackoffBase, opts.BackoffMax = 0, 0
+	g, _ := newTestGuard(opts)
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := g.Attempt(context.Background(), UserAccount(1), "10.0.0.1")
+			if err == nil && wait == 0 {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := int(allowed.Load()); got != opts.MaxAttemptsPerUser {
+		t.Fatalf("%d parallel attempts got through, want %d", got, opts.MaxAttemptsPerUser)
+	}
+}
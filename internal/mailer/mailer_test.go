@@ -0,0 +1,200 @@
+package mailer
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// session is what the fake SMTP server saw from one client.
+type session struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTPServer accepts a single connection on a loopback listener and
+// speaks just enough SMTP for net/smtp. Recipients in reject are refused
+// with a 550.
+func fakeSMTPServer(t *testing.T, reject ...string) (host string, port int, result <-chan session) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	done := make(chan session, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+		var s session
+		defer func() { done <- s }()
+
+		text := textproto.NewConn(conn)
+		reply := func(line string) bool { return text.PrintfLine("%s", line) == nil }
+		if !reply("220 fake ESMTP") {
+			return
+		}
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			verb, arg, _ := strings.Cut(line, " ")
+			switch strings.ToUpper(verb) {
+			case "EHLO", "HELO":
+				reply("250-fake")
+				reply("250 AUTH PLAIN")
+			case "AUTH":
+				s.auth = arg
+				reply("235 accepted")
+			case "MAIL":
+				s.from = arg
+				reply("250 ok")
+			case "RCPT":
+				if rejected(arg, reject) {
+					reply("550 no such user")
+				} else {
+					s.to = append(s.to, arg)
+					reply("250 ok")
+				}
+			case "DATA":
+				reply("354 go ahead")
+				data, err := text.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				s.data = string(data)
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, done
+}
+
+func rejected(arg string, reject []string) bool {
+	for _, r := range reject {
+		if strings.Contains(arg, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func receive(t *testing.T, result <-chan session) session {
+	t.Helper()
+	select {
+	case s := <-result:
+		return s
+	case <-time.After(5 * time.Second):
+		t.Fatal("fake SMTP server saw no session")
+		return session{}
+	}
+}
+
+func TestSMTPMailerSend(t *testing.T) {
+	host, port, result := fakeSMTPServer(t)
+	m := NewSMTPMailer(host, port, "mailer", "s3cret", "no-reply@chat.test")
+
+	err := m.Send(context.Background(), Message{
+		To:      "alice@example.com",
+		Subject: "Réinitialiser",
+		Body:    "line one\nline two\n",
+	})
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	s := receive(t, result)
+	wantAuth := "PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00mailer\x00s3cret"))
+	if s.auth != wantAuth {
+		t.Errorf("AUTH %q, want %q", s.auth, wantAuth)
+	}
+	if s.from != "FROM:<no-reply@chat.test>" {
+		t.Errorf("MAIL %q, want FROM:<no-reply@chat.test>", s.from)
+	}
+	if len(s.to) != 1 || s.to[0] != "TO:<alice@example.com>" {
+		t.Errorf("RCPT %q, want TO:<alice@example.com>", s.to)
+	}
+
+	header, body, ok := strings.Cut(s.data, "\n\n")
+	if !ok {
+		t.Fatalf("message has no blank line between header and body: %q", s.data)
+	}
+	for _, want := range []string{
+		"From: no-reply@chat.test",
+		"To: alice@example.com",
+		"Subject: =?utf-8?q?R=C3=A9initialiser?=",
+		"Content-Type: text/plain; charset=utf-8",
+	} {
+		if !strings.Contains(header, want+"\n") {
+			t.Errorf("header %q lacks %q", header, want)
+		}
+	}
+	if body != "line one\nline two\n" {
+		t.Errorf("body = %q, want both lines", body)
+	}
+}
+
+func TestSMTPMailerRejectedRecipient(t *testing.T) {
+	host, port, result := fakeSMTPServer(t, "nobody@example.com")
+	m := NewSMTPMailer(host, port, "", "", "no-reply@chat.test")
+
+	err := m.Send(context.Background(), Message{To: "nobody@example.com", Subject: "hi", Body: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "RCPT TO rejected") {
+		t.Fatalf("Send error = %v, want the rejected recipient", err)
+	}
+	if s := receive(t, result); s.auth != "" || s.data != "" {
+		t.Fatalf("server saw AUTH %q and DATA %q, want neither", s.auth, s.data)
+	}
+}
+
+func TestSMTPMailerRefusesHeaderInjection(t *testing.T) {
+	// nothing listens on the port: the message must be refused before dialing.
+	m := NewSMTPMailer("127.0.0.1", 1, "", "", "no-reply@chat.test")
+
+	for _, msg := range []Message{
+		{To: "alice@example.com\r\nBcc: everyone@example.com", Subject: "hi"},
+		{To: "alice@example.com", Subject: "hi\nBcc: everyone@example.com"},
+	} {
+		if err := m.Send(context.Background(), msg); !errors.Is(err, ErrInvalidHeader) {
+			t.Errorf("Send(%q, %q) = %v, want ErrInvalidHeader", msg.To, msg.Subject, err)
+		}
+	}
+}
+
+func TestSMTPMailerUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	m := NewSMTPMailer("127.0.0.1", port, "", "", "no-reply@chat.test")
+	err = m.Send(context.Background(), Message{To: "alice@example.com", Subject: "hi", Body: "hi"})
+	if err == nil || !strings.Contains(err.Error(), "failed to connect to 127.0.0.1:"+strconv.Itoa(port)) {
+		t.Fatalf("Send error = %v, want a connection failure", err)
+	}
+}
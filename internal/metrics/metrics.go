@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "chat"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	WsConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ws_active_connections",
+		Help:      "Currently connected WebSocket clients.",
+	})
+
+	WsMessagesReceived = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_messages_received_total",
+		Help:      "WebSocket frames received from clients.",
+	})
+
+	WsMessagesSent = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_messages_sent_total",
+		Help:      "WebSocket frames written to clients.",
+	})
+
+	WsDroppedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_dropped_events_total",
+		Help:      "Droppable outbound events discarded because a client queue was full.",
+	})
+
+	WsOverflowDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_overflow_disconnects_total",
+		Help:      "Clients disconnected because their outbound queue overflowed.",
+	})
+
+	WsRejectedOrigins = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_rejected_origins_total",
+		Help:      "WebSocket upgrades refused by the origin allow-list.",
+	})
+
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limited_total",
+		Help:      "Requests and WebSocket frames rejected by a rate limiter, by scope.",
+	}, []string{"scope"})
+
+	WsRateLimitDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ws_rate_limit_disconnects_total",
+		Help:      "Clients disconnected for repeatedly exceeding the message rate limit.",
+	})
+
+	AuthFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "auth_failures_total",
+		Help:      "Failed authentication attempts by reason.",
+	}, []string{"reason"})
+
+	LoginLockouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_lockouts_total",
+		Help:      "Temporary login lockouts by scope (user or ip).",
+	}, []string{"scope"})
+
+	PasswordHashDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "password_hash_duration_seconds",
+		Help:      "Time spent hashing or comparing passwords, by algorithm (bcrypt or argon2id).",
+		Buckets:   []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+	}, []string{"algorithm", "op"})
+)
+
+// RegisterQueueDepth exposes the total number of frames waiting in client
+// outbound queues, as reported by depth at scrape time.
+func RegisterQueueDepth(depth func() int) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ws_outbound_queue_depth",
+		Help:      "Frames waiting in WebSocket client outbound queues.",
+	}, func() float64 { return float64(depth()) })
+}
+
+// RegisterDBStats exposes the sql.DB connection pool statistics.
+func RegisterDBStats(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// Middleware records request counts and latency labelled by the matched
+// route template, so /api/users/1 and /api/users/2 share a series.
+func Middleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		ctx.Next()
+
+		route := ctx.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(ctx.Writer.Status())
+
+		HTTPRequests.WithLabelValues(route, ctx.Request.Method, status).Inc()
+		HTTPRequestDuration.WithLabelValues(route, ctx.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/logger"
+)
+
+// AccessLog replaces gin's default text logger with one structured slog
+// line per request. Server errors log at ERROR and client errors at WARN.
+func AccessLog() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		start := time.Now()
+		path := ctx.Request.URL.Path
+
+		ctx.Next()
+
+		status := ctx.Writer.Status()
+		attrs := []slog.Attr{
+			slog.String("method", ctx.Request.Method),
+			slog.String("path", path),
+			slog.String("route", ctx.FullPath()),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", ctx.ClientIP()),
+			slog.Int("bytes", ctx.Writer.Size()),
+		}
+		if userID, ok := ctx.Get(AuthorizationPayloadKey); ok {
+			if userID, ok := userID.(int64); ok {
+				attrs = append(attrs, logger.UserID(userID))
+			}
+		}
+		if len(ctx.Errors) > 0 {
+			attrs = append(attrs, slog.String(logger.KeyError, ctx.Errors.String()))
+		}
+
+		level := slog.LevelInfo
+		switch {
+		case status >= 500:
+			level = slog.LevelError
+		case status >= 400:
+			level = slog.LevelWarn
+		}
+
+		slog.LogAttrs(ctx.Request.Context(), level, "http request", attrs...)
+	}
+}
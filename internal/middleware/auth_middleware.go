@@ -3,48 +3,61 @@ package middleware
 import (
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/apikey"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/metrics"
+	"github.com/sokolawesome/chat-server/internal/repository"
 )
 
 const (
 	AuthorizationHeaderKey  = "Authorization"
 	AuthorizationTypeBearer = "bearer"
 	AuthorizationPayloadKey = "authorization_payload"
+	// APIKeyPayloadKey holds the *models.APIKey when the request was
+	// authenticated with an API key instead of a JWT.
+	APIKeyPayloadKey = "api_key_payload"
+	// SessionPayloadKey holds the int64 session ID of a JWT-authenticated
+	// request; it is unset for tokens issued before sessions existed.
+	SessionPayloadKey = "session_payload"
 )
 
-func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
+// AuthMiddleware accepts either a JWT or an API key as the bearer token.
+// API keys need api:read for safe methods and api:write for everything else.
+func AuthMiddleware(jwtSecret string, userRepository repository.UserRepository, apiKeyRepository repository.APIKeyRepository, sessionRepository repository.SessionRepository) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		authorizationHeader := ctx.GetHeader(AuthorizationHeaderKey)
 
 		if len(authorizationHeader) == 0 {
-			err := errors.New("authorization header is not provided")
-			log.Println("auth error: ", err)
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			abortAuth(ctx, apierror.ErrMissingToken, "missing_header", errors.New("authorization header is not provided"))
 			return
 		}
 
 		fields := strings.Fields(authorizationHeader)
 		if len(fields) != 2 {
-			err := errors.New("invalid authorization header format")
-			log.Println("auth error: ", err)
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			abortAuth(ctx, apierror.ErrMalformedHeader, "malformed_header", errors.New("invalid authorization header format"))
 			return
 		}
 
 		authorizationType := strings.ToLower(fields[0])
 		if authorizationType != AuthorizationTypeBearer {
-			err := fmt.Errorf("unsupported auth type %s", authorizationType)
-			log.Println("auth error: ", err)
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			abortAuth(ctx, apierror.ErrUnsupportedAuth, "unsupported_type", fmt.Errorf("unsupported auth type %s", authorizationType))
 			return
 		}
 
 		accessToken := fields[1]
+		if apikey.IsKey(accessToken) {
+			authenticateAPIKey(ctx, apiKeyRepository, accessToken)
+			return
+		}
+
 		token, err := jwt.Parse(accessToken, func(t *jwt.Token) (interface{}, error) {
 			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
 				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
@@ -53,12 +66,11 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 		})
 
 		if err != nil {
-			log.Printf("jwt parsing/validation error: %v", err)
-			errMsg := "invalid token"
 			if errors.Is(err, jwt.ErrTokenExpired) {
-				errMsg = "token has expired"
+				abortAuth(ctx, apierror.ErrTokenExpired, "token_expired", err)
+				return
 			}
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": errMsg})
+			abortAuth(ctx, apierror.ErrInvalidToken, "invalid_token", err)
 			return
 		}
 
@@ -66,28 +78,113 @@ func AuthMiddleware(jwtSecret string) gin.HandlerFunc {
 			userIDF64, okSub := claims["sub"].(float64)
 			username, okUsr := claims["usr"].(string)
 			if !okSub {
-				err := errors.New("invalid token: missing or invalid userid (sub) claim")
-				log.Println("auth error:", err)
-				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token payload"})
+				abortAuth(ctx, apierror.ErrInvalidToken, "invalid_claims", errors.New("invalid token: missing or invalid userid (sub) claim"))
 				return
 			}
 			if !okUsr {
-				err := errors.New("invalid token: missing or invalid username (usr) claim")
-				log.Println("auth error:", err)
-				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token payload"})
+				abortAuth(ctx, apierror.ErrInvalidToken, "invalid_claims", errors.New("invalid token: missing or invalid username (usr) claim"))
 				return
 			}
 
 			userID := int64(userIDF64)
 
+			// tokens issued before the last password change carry an older
+			// version and are rejected; tokens without "ver" predate it.
+			tokenVersion, _ := claims["ver"].(float64)
+			currentVersion, err := userRepository.GetTokenVersion(ctx.Request.Context(), userID)
+			if err != nil {
+				if errors.Is(err, repository.ErrUserNotFound) {
+					abortAuth(ctx, apierror.ErrTokenRevoked, "unknown_user", err)
+					return
+				}
+				_ = ctx.Error(err)
+				ctx.Abort()
+				return
+			}
+			if int(tokenVersion) != currentVersion {
+				abortAuth(ctx, apierror.ErrTokenRevoked, "token_revoked", fmt.Errorf("token version %d is older than %d", int(tokenVersion), currentVersion))
+				return
+			}
+
+			// a token is only as good as its session: signing out remotely
+			// deletes the session. Tokens without "sid" predate sessions.
+			if sessionIDF64, hasSession := claims["sid"].(float64); hasSession {
+				sessionID := int64(sessionIDF64)
+				if err := sessionRepository.TouchSession(ctx.Request.Context(), userID, sessionID); err != nil {
+					if errors.Is(err, repository.ErrSessionNotFound) {
+						abortAuth(ctx, apierror.ErrTokenRevoked, "session_revoked", err)
+						return
+					}
+					_ = ctx.Error(err)
+					ctx.Abort()
+					return
+				}
+				ctx.Set(SessionPayloadKey, sessionID)
+			}
+
 			ctx.Set(AuthorizationPayloadKey, userID)
 
-			log.Printf("auth success: user %d (%s) authorized", userID, username)
+			slog.DebugContext(ctx.Request.Context(), "auth success", logger.UserID(userID), slog.String("username", username))
 
 			ctx.Next()
 		} else {
-			log.Println("auth error: invalid token (claims invalid or token marked invalid)")
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+			abortAuth(ctx, apierror.ErrInvalidToken, "invalid_token", errors.New("invalid token (claims invalid or token marked invalid)"))
+		}
+	}
+}
+
+func authenticateAPIKey(ctx *gin.Context, apiKeyRepository repository.APIKeyRepository, rawKey string) {
+	key, err := apiKeyRepository.AuthenticateAPIKey(ctx.Request.Context(), apikey.Hash(rawKey))
+	if err != nil {
+		if errors.Is(err, repository.ErrAPIKeyNotFound) {
+			abortAuth(ctx, apierror.ErrInvalidAPIKey, "invalid_api_key", err)
+			return
 		}
+		_ = ctx.Error(err)
+		ctx.Abort()
+		return
 	}
+	if key.Expired(time.Now()) {
+		abortAuth(ctx, apierror.ErrAPIKeyExpired, "api_key_expired", fmt.Errorf("api key %d expired at %s", key.ID, key.ExpiresAt))
+		return
+	}
+
+	scope := apikey.ScopeWrite
+	switch ctx.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		scope = apikey.ScopeRead
+	}
+	if !key.HasScope(scope) {
+		abortAuth(ctx, apierror.ErrInsufficientScope, "insufficient_scope", fmt.Errorf("api key %d lacks scope %s", key.ID, scope))
+		return
+	}
+
+	ctx.Set(AuthorizationPayloadKey, key.UserID)
+	ctx.Set(APIKeyPayloadKey, key)
+
+	slog.DebugContext(ctx.Request.Context(), "auth success", logger.UserID(key.UserID), slog.Int64("api_key_id", key.ID))
+
+	ctx.Next()
+}
+
+// RequireSession rejects requests authenticated with an API key. It guards
+// account management, so a leaked bot key cannot change the password,
+// disable MFA or mint more keys.
+func RequireSession() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if _, usedKey := ctx.Get(APIKeyPayloadKey); usedKey {
+			abortAuth(ctx, apierror.ErrSessionRequired, "session_required", errors.New("api key used for account management"))
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// abortAuth records an authentication failure under reason and stops the
+// chain, leaving ErrorHandler to render apiErr.
+func abortAuth(ctx *gin.Context, apiErr *apierror.Error, reason string, err error) {
+	metrics.AuthFailures.WithLabelValues(reason).Inc()
+	slog.InfoContext(ctx.Request.Context(), "auth error", slog.String("reason", reason), logger.Err(err))
+	_ = ctx.Error(apiErr.Wrap(err))
+	ctx.Abort()
 }
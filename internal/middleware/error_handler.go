@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"errors"
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/avatar"
+	"github.com/sokolawesome/chat-server/internal/emailverify"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/repository"
+)
+
+// sentinelErrors maps errors returned by lower layers to the API error the
+// client should see, so handlers can pass them straight to ctx.Error.
+var sentinelErrors = []struct {
+	err    error
+	apiErr *apierror.Error
+}{
+	{repository.ErrUserNotFound, apierror.ErrUserNotFound},
+	{repository.ErrUsernameTaken, apierror.ErrUsernameTaken},
+	{repository.ErrEmailTaken, apierror.ErrEmailTaken},
+	{repository.ErrAPIKeyNotFound, apierror.ErrAPIKeyNotFound},
+	{repository.ErrSessionNotFound, apierror.ErrSessionNotFound},
+	{repository.ErrAvatarNotFound, apierror.ErrAvatarNotFound},
+	{avatar.ErrUnsupportedFormat, apierror.ErrInvalidAvatar},
+	{avatar.ErrTooLarge, apierror.ErrInvalidAvatar},
+	{repository.ErrResetTokenInvalid, apierror.ErrResetTokenInvalid},
+	{emailverify.ErrInvalidToken, apierror.ErrVerifyTokenInvalid},
+	{emailverify.ErrExpiredToken, apierror.ErrVerifyTokenInvalid},
+}
+
+type errorBody struct {
+	*apierror.Error
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// ErrorHandler renders the last error attached with ctx.Error as the
+// standard {"error": {...}} body. Unrecognised errors become a generic 500
+// and are logged with their cause, which is never exposed to the client.
+func ErrorHandler() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Next()
+
+		if len(ctx.Errors) == 0 || ctx.Writer.Written() {
+			return
+		}
+
+		err := ctx.Errors.Last().Err
+		apiErr := toAPIError(err)
+		if apiErr.Status >= 500 {
+			slog.ErrorContext(ctx.Request.Context(), "request failed", slog.String("code", apiErr.Code), logger.Err(err))
+		}
+
+		ctx.JSON(apiErr.Status, gin.H{"error": errorBody{
+			Error:     apiErr,
+			RequestID: ctx.GetString(RequestIDKey),
+		}})
+	}
+}
+
+func toAPIError(err error) *apierror.Error {
+	var apiErr *apierror.Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	for _, sentinel := range sentinelErrors {
+		if errors.Is(err, sentinel.err) {
+			return sentinel.apiErr.Wrap(err)
+		}
+	}
+	return apierror.ErrInternal.Wrap(err)
+}
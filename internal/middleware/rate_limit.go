@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"log/slog"
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/apierror"
+	"github.com/sokolawesome/chat-server/internal/metrics"
+	"github.com/sokolawesome/chat-server/internal/ratelimit"
+)
+
+// RateLimit throttles requests with limiter, keyed by the authenticated user
+// when AuthMiddleware has run earlier in the chain and by client IP
+// otherwise. scope names the route group in metrics and logs.
+func RateLimit(limiter *ratelimit.Limiter, scope string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		key := "ip:" + ctx.ClientIP()
+		if userID, ok := ctx.Get(AuthorizationPayloadKey); ok {
+			key = "user:" + strconv.FormatInt(userID.(int64), 10)
+		}
+
+		allowed, retryAfter := limiter.Allow(key)
+		if !allowed {
+			metrics.RateLimited.WithLabelValues(scope).Inc()
+			slog.InfoContext(ctx.Request.Context(), "request rate limited", slog.String("scope", scope), slog.String("key", key), slog.Duration("retry_after", retryAfter))
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			_ = ctx.Error(apierror.ErrRateLimited)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
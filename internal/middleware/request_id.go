@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/requestid"
+)
+
+const RequestIDKey = "request_id"
+
+// RequestID accepts a well-formed X-Request-ID from the client or generates
+// one, echoes it in the response and attaches it to the request context so
+// every log line for the request carries it.
+func RequestID() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id := ctx.GetHeader(requestid.HeaderKey)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+
+		reqCtx := requestid.WithContext(ctx.Request.Context(), id)
+		reqCtx = logger.WithAttrs(reqCtx, slog.String(logger.KeyRequestID, id))
+		ctx.Request = ctx.Request.WithContext(reqCtx)
+		ctx.Set(RequestIDKey, id)
+		ctx.Header(requestid.HeaderKey, id)
+
+		ctx.Next()
+	}
+}
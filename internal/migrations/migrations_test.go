@@ -0,0 +1,161 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func sqlFS(names ...string) fstest.MapFS {
+	fsys := make(fstest.MapFS, len(names))
+	for _, name := range names {
+		fsys["sql/"+name] = &fstest.MapFile{Data: []byte("-- " + name)}
+	}
+	return fsys
+}
+
+func noopStep(context.Context, *sql.Tx) error { return nil }
+
+func TestLoadOrdersByVersion(t *testing.T) {
+	fsys := sqlFS(
+		"10_add_index.up.sql", "10_add_index.down.sql",
+		"0002_add_column.up.sql", "0002_add_column.down.sql",
+		"0001_create_table.down.sql", "0001_create_table.up.sql",
+	)
+
+	migrations, err := load(fsys, map[int64]Step{2: noopStep})
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	want := []struct {
+		version int64
+		name    string
+		step    bool
+	}{
+		{1, "create_table", false},
+		{2, "add_column", true},
+		{10, "add_index", false},
+	}
+	if len(migrations) != len(want) {
+		t.Fatalf("loaded %d migrations, want %d", len(migrations), len(want))
+	}
+	for i, w := range want {
+		m := migrations[i]
+		if m.Version != w.version || m.Name != w.name {
+			t.Errorf("migration %d = %d_%s, want %d_%s", i, m.Version, m.Name, w.version, w.name)
+		}
+		if !strings.HasSuffix(m.Up, w.name+".up.sql") || !strings.HasSuffix(m.Down, w.name+".down.sql") {
+			t.Errorf("migration %d_%s has up %q and down %q", m.Version, m.Name, m.Up, m.Down)
+		}
+		if (m.UpStep != nil) != w.step {
+			t.Errorf("migration %d_%s has a step = %v, want %v", m.Version, m.Name, m.UpStep != nil, w.step)
+		}
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		fsys  fstest.MapFS
+		steps map[int64]Step
+		want  string
+	}{
+		{
+			name: "no sql directory",
+			fsys: fstest.MapFS{},
+			want: "failed to read migration files",
+		},
+		{
+			name: "unexpected file name",
+			fsys: sqlFS("0001_create.up.sql", "0001_create.down.sql", "README.md"),
+			want: "unexpected file name 'README.md'",
+		},
+		{
+			name: "file without a direction",
+			fsys: sqlFS("0001_create.sql"),
+			want: "unexpected file name",
+		},
+		{
+			name: "missing down",
+			fsys: sqlFS("0001_create.up.sql", "0002_alter.up.sql", "0002_alter.down.sql"),
+			want: "migration 1_create must have both up and down files",
+		},
+		{
+			name: "missing up",
+			fsys: sqlFS("0001_create.down.sql"),
+			want: "migration 1_create must have both up and down files",
+		},
+		{
+			name: "version used twice",
+			fsys: sqlFS("0001_create.up.sql", "0001_create.down.sql", "0001_other.up.sql", "0001_other.down.sql"),
+			want: "version 1 is used by both",
+		},
+		{
+			name:  "step for an unknown version",
+			fsys:  sqlFS("0001_create.up.sql", "0001_create.down.sql"),
+			steps: map[int64]Step{3: noopStep},
+			want:  "step registered for unknown version 3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(tt.fsys, tt.steps)
+			if err == nil {
+				t.Fatal("load succeeded, want an error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("load error = %q, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := load(files, steps)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if len(migrations) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for i, m := range migrations {
+		// versions are numbered without gaps, so a missing file shows up here.
+		if m.Version != int64(i+1) {
+			t.Fatalf("migration %d has version %d, want %d", i, m.Version, i+1)
+		}
+	}
+	for version := range steps {
+		if migrations[version-1].UpStep == nil {
+			t.Errorf("migration %d lost its step", version)
+		}
+	}
+}
+
+func TestCheckKnown(t *testing.T) {
+	m := &Migrator{migrations: []Migration{{Version: 1, Name: "a"}, {Version: 2, Name: "b"}, {Version: 3, Name: "c"}}}
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		done    map[int64]time.Time
+		wantErr error
+	}{
+		{"fresh database", map[int64]time.Time{}, nil},
+		{"partly applied", map[int64]time.Time{1: now, 2: now}, nil},
+		{"fully applied", map[int64]time.Time{1: now, 2: now, 3: now}, nil},
+		{"database ahead of the binary", map[int64]time.Time{1: now, 2: now, 3: now, 4: now}, ErrDirtyDatabase},
+		{"unknown version in the middle", map[int64]time.Time{1: now, 7: now}, ErrDirtyDatabase},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.checkKnown(tt.done); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("checkKnown = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,4 @@
+-- username_normalized mirrors username.Normalize. It is filled in, checked
+-- for collisions and made NOT NULL and unique by the Go step registered for
+-- this version in steps.go, since SQL cannot reproduce the normalization.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS username_normalized VARCHAR(255);
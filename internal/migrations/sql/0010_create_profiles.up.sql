@@ -0,0 +1,15 @@
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS display_name VARCHAR(50) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS bio VARCHAR(500) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS pronouns VARCHAR(40) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS timezone VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS avatar_updated_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN IF NOT EXISTS profile_updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS user_avatars (
+    user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    size INTEGER NOT NULL,
+    content_type VARCHAR(50) NOT NULL,
+    data BYTEA NOT NULL,
+    PRIMARY KEY (user_id, size)
+);
@@ -0,0 +1,5 @@
+ALTER TABLE login_attempts ADD COLUMN IF NOT EXISTS last_attempt_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE login_attempts SET last_attempt_at = last_failure_at WHERE last_attempt_at IS NULL;
+
+ALTER TABLE login_attempts ALTER COLUMN last_attempt_at SET NOT NULL;
@@ -0,0 +1,101 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/sokolawesome/chat-server/internal/username"
+)
+
+// Step is a data migration written in Go, for values SQL cannot reproduce
+// exactly, such as keys derived with the same code the application uses.
+type Step func(ctx context.Context, tx *sql.Tx) error
+
+// steps maps a migration version to the Step that runs after its up script.
+var steps = map[int64]Step{
+	9: backfillUsernameNormalized,
+}
+
+// backfillUsernameNormalized fills users.username_normalized with
+// username.Normalize. Postgres' lower() depends on the database locale and
+// is not Unicode case folding, so the key is computed here rather than in
+// SQL, and then made mandatory and unique.
+func backfillUsernameNormalized(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, `SELECT id, username FROM users`)
+	if err != nil {
+		return fmt.Errorf("failed to read usernames: %w", err)
+	}
+	usernames := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan username: %w", err)
+		}
+		usernames[id] = name
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read usernames: %w", err)
+	}
+
+	normalized, err := normalizeUsernames(usernames)
+	if err != nil {
+		return err
+	}
+
+	update, err := tx.PrepareContext(ctx, `UPDATE users SET username_normalized = $2 WHERE id = $1`)
+	if err != nil {
+		return err
+	}
+	defer update.Close()
+	for id, key := range normalized {
+		if _, err := update.ExecContext(ctx, id, key); err != nil {
+			return fmt.Errorf("failed to store normalized username of user %d: %w", id, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `ALTER TABLE users ALTER COLUMN username_normalized SET NOT NULL`); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `CREATE UNIQUE INDEX IF NOT EXISTS idx_users_username_normalized ON users(username_normalized)`)
+	return err
+}
+
+// normalizeUsernames returns the normalized key of every username, keyed by
+// user ID. Accounts that only differ by case, compatibility form or
+// look-alike letters cannot be told apart once keys are unique, so it
+// refuses until an operator has renamed all but one of each group.
+func normalizeUsernames(usernames map[int64]string) (map[int64]string, error) {
+	normalized := make(map[int64]string, len(usernames))
+	groups := make(map[string][]int64)
+	for id, name := range usernames {
+		key := username.Normalize(name)
+		normalized[id] = key
+		groups[key] = append(groups[key], id)
+	}
+
+	var collisions []string
+	for key, ids := range groups {
+		if len(ids) < 2 {
+			continue
+		}
+		slices.Sort(ids)
+		idList := make([]string, len(ids))
+		for i, id := range ids {
+			idList[i] = strconv.FormatInt(id, 10)
+		}
+		collisions = append(collisions, fmt.Sprintf("%s (user ids %s)", key, strings.Join(idList, ", ")))
+	}
+	if len(collisions) > 0 {
+		slices.Sort(collisions)
+		return nil, fmt.Errorf("usernames collide after normalization: %s; rename all but one account in each group, then run the migration again", strings.Join(collisions, "; "))
+	}
+
+	return normalized, nil
+}
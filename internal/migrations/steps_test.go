@@ -0,0 +1,41 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalizeUsernames(t *testing.T) {
+	normalized, err := normalizeUsernames(map[int64]string{1: "Alice", 2: "bob", 3: "Straße"})
+	if err != nil {
+		t.Fatalf("normalizeUsernames: %v", err)
+	}
+	want := map[int64]string{1: "alice", 2: "bob", 3: "strasse"}
+	for id, key := range want {
+		if normalized[id] != key {
+			t.Errorf("user %d normalized to %q, want %q", id, normalized[id], key)
+		}
+	}
+}
+
+func TestNormalizeUsernamesReportsCollisions(t *testing.T) {
+	_, err := normalizeUsernames(map[int64]string{
+		7: "alice",
+		3: "ALICE",
+		5: "аlice", // Cyrillic а
+		4: "STRASSE",
+		9: "straße",
+		1: "carol",
+	})
+	if err == nil {
+		t.Fatal("normalizeUsernames accepted colliding usernames")
+	}
+	for _, want := range []string{"alice (user ids 3, 5, 7)", "strasse (user ids 4, 9)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not list %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "carol") {
+		t.Errorf("error %q lists a name without collisions", err)
+	}
+}
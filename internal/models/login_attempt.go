@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+type LoginAttempts struct {
+	Key           string
+	Failures      int
+	LastAttemptAt time.Time
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+type LoginLockout struct {
+	Key         string
+	Failures    int
+	LockedUntil time.Time
+	CreatedAt   time.Time
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// TOTP is a user's authenticator enrollment. EnabledAt stays nil until the
+// user confirms the secret with a first valid code.
+type TOTP struct {
+	UserID       int64
+	Secret       string
+	EnabledAt    *time.Time
+	LastUsedStep int64
+	CreatedAt    time.Time
+}
+
+func (t *TOTP) Enabled() bool {
+	return t != nil && t.EnabledAt != nil
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Profile is the public face of a user, visible to every signed-in user.
+// Fields carry msgpack tags as well because profiles are pushed over /ws.
+type Profile struct {
+	UserID      int64  `json:"id" msgpack:"id"`
+	Username    string `json:"username" msgpack:"username"`
+	DisplayName string `json:"display_name" msgpack:"display_name"`
+	Bio         string `json:"bio" msgpack:"bio"`
+	Pronouns    string `json:"pronouns" msgpack:"pronouns"`
+	// Timezone is an IANA name such as "Europe/Berlin", or empty.
+	Timezone string `json:"timezone" msgpack:"timezone"`
+	// Avatars maps each stored size to its URL; it is empty without an avatar.
+	Avatars         map[string]string `json:"avatars" msgpack:"avatars"`
+	AvatarUpdatedAt *time.Time        `json:"-" msgpack:"-"`
+	CreatedAt       time.Time         `json:"created_at" msgpack:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at" msgpack:"updated_at"`
+}
+
+// ProfileUpdate holds the profile fields to change; nil fields are kept.
+type ProfileUpdate struct {
+	DisplayName *string
+	Bio         *string
+	Pronouns    *string
+	Timezone    *string
+}
+
+// Avatar is one stored size of a user's avatar.
+type Avatar struct {
+	ContentType string
+	Data        []byte
+	UpdatedAt   time.Time
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// Session is one sign-in of a user. Every access token carries the ID of
+// the session it was issued for, so deleting the session revokes the token.
+type Session struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"-"`
+	DeviceName string    `json:"device_name"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
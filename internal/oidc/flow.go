@@ -0,0 +1,99 @@
+package oidc
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var ErrInvalidFlow = errors.New("oidc login flow state is missing, invalid or expired")
+
+// Flow is the per-login state kept in the browser between the redirect to
+// the provider and the callback.
+type Flow struct {
+	State     string `json:"state"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func NewFlow(ttl time.Duration) (*Flow, error) {
+	state, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomString()
+	if err != nil {
+		return nil, err
+	}
+	return &Flow{
+		State:     state,
+		Nonce:     nonce,
+		Verifier:  oauth2.GenerateVerifier(),
+		ExpiresAt: time.Now().Add(ttl).Unix(),
+	}, nil
+}
+
+// FlowCodec signs flows so they can be stored in a cookie without the
+// browser being able to alter them.
+type FlowCodec struct {
+	key []byte
+}
+
+func NewFlowCodec(secret string) *FlowCodec {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("oidc-flow"))
+	return &FlowCodec{key: mac.Sum(nil)}
+}
+
+func (c *FlowCodec) Encode(flow *Flow) (string, error) {
+	body, err := json.Marshal(flow)
+	if err != nil {
+		return "", fmt.Errorf("oidc: failed to encode flow: %w", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(body)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(c.sign(encoded)), nil
+}
+
+func (c *FlowCodec) Decode(value string) (*Flow, error) {
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, ErrInvalidFlow
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, c.sign(encoded)) {
+		return nil, ErrInvalidFlow
+	}
+	body, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidFlow
+	}
+
+	var flow Flow
+	if err := json.Unmarshal(body, &flow); err != nil || time.Now().Unix() > flow.ExpiresAt {
+		return nil, ErrInvalidFlow
+	}
+	return &flow, nil
+}
+
+func (c *FlowCodec) sign(encoded string) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(encoded))
+	return mac.Sum(nil)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("oidc: failed to generate random value: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
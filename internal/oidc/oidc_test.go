@@ -0,0 +1,217 @@
+package oidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sokolawesome/chat-server/internal/oidc/oidctest"
+)
+
+const testRedirectURL = "http://chat.test/api/auth/oidc/callback"
+
+func newTestProvider(t *testing.T) (*Provider, *oidctest.Provider) {
+	t.Helper()
+
+	idp := oidctest.New(t)
+	return NewProvider(Config{
+		IssuerURL:    idp.Issuer(),
+		ClientID:     oidctest.ClientID,
+		ClientSecret: oidctest.ClientSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+	}), idp
+}
+
+func TestAuthCodeURLSendsOnlyTheChallenge(t *testing.T) {
+	provider, _ := newTestProvider(t)
+	flow, err := NewFlow(time.Minute)
+	if err != nil {
+		t.Fatalf("NewFlow: %v", err)
+	}
+
+	authURL, err := provider.AuthCodeURL(context.Background(), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parse auth url: %v", err)
+	}
+	query := parsed.Query()
+
+	sum := sha256.Sum256([]byte(flow.Verifier))
+	if got, want := query.Get("code_challenge"), base64.RawURLEncoding.EncodeToString(sum[:]); got != want {
+		t.Errorf("code_challenge = %q, want %q", got, want)
+	}
+	if query.Get("code_challenge_method") != "S256" {
+		t.Errorf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+	}
+	if query.Get("state") != flow.State || query.Get("nonce") != flow.Nonce {
+		t.Errorf("state/nonce = %q/%q, want %q/%q", query.Get("state"), query.Get("nonce"), flow.State, flow.Nonce)
+	}
+	if strings.Contains(authURL, flow.Verifier) {
+		t.Error("auth url contains the PKCE verifier")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name     string
+		verifier func(flow *Flow) string
+		nonce    func(flow *Flow) string
+		idpNonce string
+		wantErr  error
+	}{
+		{
+			name:     "valid",
+			verifier: func(flow *Flow) string { return flow.Verifier },
+			nonce:    func(flow *Flow) string { return flow.Nonce },
+		},
+		{
+			name:     "wrong pkce verifier",
+			verifier: func(*Flow) string { return "a-different-verifier-that-is-long-enough-to-pass-43" },
+			nonce:    func(flow *Flow) string { return flow.Nonce },
+			wantErr:  errAny,
+		},
+		{
+			name:     "nonce from another login",
+			verifier: func(flow *Flow) string { return flow.Verifier },
+			nonce:    func(*Flow) string { return "another-nonce" },
+			wantErr:  ErrNonceMismatch,
+		},
+		{
+			name:     "replayed id token",
+			verifier: func(flow *Flow) string { return flow.Verifier },
+			nonce:    func(flow *Flow) string { return flow.Nonce },
+			idpNonce: "stale-nonce",
+			wantErr:  ErrNonceMismatch,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, idp := newTestProvider(t)
+			idp.SignIn(oidctest.User{Subject: "sub-42", Email: "bob@example.com", EmailVerified: true, PreferredUsername: "bob"})
+			if tt.idpNonce != "" {
+				idp.OverrideNonce(tt.idpNonce)
+			}
+
+			flow, err := NewFlow(time.Minute)
+			if err != nil {
+				t.Fatalf("NewFlow: %v", err)
+			}
+			authURL, err := provider.AuthCodeURL(context.Background(), flow.State, flow.Nonce, flow.Verifier)
+			if err != nil {
+				t.Fatalf("AuthCodeURL: %v", err)
+			}
+			code, _ := idp.Authorize(t, authURL)
+
+			identity, err := provider.Exchange(context.Background(), code, tt.verifier(flow), tt.nonce(flow))
+			switch {
+			case tt.wantErr == errAny:
+				if err == nil {
+					t.Fatal("Exchange succeeded, want error")
+				}
+				return
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Exchange error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case err != nil:
+				t.Fatalf("Exchange: %v", err)
+			}
+
+			want := Identity{Issuer: idp.Issuer(), Subject: "sub-42", Email: "bob@example.com", EmailVerified: true, PreferredUsername: "bob"}
+			if *identity != want {
+				t.Errorf("identity = %+v, want %+v", *identity, want)
+			}
+		})
+	}
+}
+
+func TestExchangeCodeIsSingleUse(t *testing.T) {
+	provider, idp := newTestProvider(t)
+	flow, err := NewFlow(time.Minute)
+	if err != nil {
+		t.Fatalf("NewFlow: %v", err)
+	}
+	authURL, err := provider.AuthCodeURL(context.Background(), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		t.Fatalf("AuthCodeURL: %v", err)
+	}
+	code, _ := idp.Authorize(t, authURL)
+
+	if _, err := provider.Exchange(context.Background(), code, flow.Verifier, flow.Nonce); err != nil {
+		t.Fatalf("first Exchange: %v", err)
+	}
+	if _, err := provider.Exchange(context.Background(), code, flow.Verifier, flow.Nonce); err == nil {
+		t.Fatal("second Exchange of the same code succeeded")
+	}
+}
+
+func TestFlowCodec(t *testing.T) {
+	codec := NewFlowCodec("secret")
+	flow, err := NewFlow(time.Minute)
+	if err != nil {
+		t.Fatalf("NewFlow: %v", err)
+	}
+	value, err := codec.Encode(flow)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+
+	decoded, err := codec.Decode(value)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if *decoded != *flow {
+		t.Errorf("decoded flow = %+v, want %+v", *decoded, *flow)
+	}
+
+	expired := *flow
+	expired.ExpiresAt = time.Now().Add(-time.Second).Unix()
+	expiredValue, err := codec.Encode(&expired)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	encoded, signature, _ := strings.Cut(value, ".")
+	tampered := *flow
+	tampered.State = "attacker-state"
+	tamperedValue, err := codec.Encode(&tampered)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	tamperedEncoded, _, _ := strings.Cut(tamperedValue, ".")
+
+	invalid := map[string]string{
+		"expired":           expiredValue,
+		"other secret":      mustEncode(t, NewFlowCodec("other"), flow),
+		"swapped body":      tamperedEncoded + "." + signature,
+		"missing signature": encoded,
+		"empty":             "",
+	}
+	for name, value := range invalid {
+		if _, err := codec.Decode(value); !errors.Is(err, ErrInvalidFlow) {
+			t.Errorf("%s: Decode error = %v, want ErrInvalidFlow", name, err)
+		}
+	}
+}
+
+// errAny marks test cases that only expect some error.
+var errAny = errors.New("any error")
+
+func mustEncode(t *testing.T, codec *FlowCodec, flow *Flow) string {
+	t.Helper()
+	value, err := codec.Encode(flow)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	return value
+}
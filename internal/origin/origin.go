@@ -0,0 +1,84 @@
+package origin
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Matcher decides whether a browser Origin is allowed. It is shared by the
+// CORS middleware and the WebSocket upgrader so both enforce the same list.
+type Matcher struct {
+	allowAll bool
+	exact    map[string]struct{}
+	wildcard []wildcardPattern
+}
+
+type wildcardPattern struct {
+	scheme string
+	suffix string
+}
+
+// NewMatcher builds a Matcher from patterns of the form "https://app.example.com",
+// "https://*.example.com" (any subdomain, not the apex) or "*" (anything).
+func NewMatcher(patterns []string) (*Matcher, error) {
+	m := &Matcher{exact: make(map[string]struct{})}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern == "" {
+			continue
+		}
+		if pattern == "*" {
+			m.allowAll = true
+			continue
+		}
+
+		scheme, host, ok := strings.Cut(pattern, "://")
+		if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#") {
+			return nil, fmt.Errorf("origin.NewMatcher: invalid origin pattern '%s'", pattern)
+		}
+
+		if rest, isWildcard := strings.CutPrefix(host, "*."); isWildcard {
+			if rest == "" || strings.Contains(rest, "*") {
+				return nil, fmt.Errorf("origin.NewMatcher: invalid wildcard pattern '%s'", pattern)
+			}
+			m.wildcard = append(m.wildcard, wildcardPattern{scheme: scheme, suffix: "." + rest})
+			continue
+		}
+		if strings.Contains(host, "*") {
+			return nil, fmt.Errorf("origin.NewMatcher: wildcard is only allowed as the leftmost label in '%s'", pattern)
+		}
+
+		m.exact[scheme+"://"+host] = struct{}{}
+	}
+
+	return m, nil
+}
+
+func (m *Matcher) AllowsAll() bool {
+	return m.allowAll
+}
+
+func (m *Matcher) Allowed(origin string) bool {
+	if m.allowAll {
+		return true
+	}
+
+	u, err := url.Parse(strings.ToLower(origin))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return false
+	}
+
+	if _, ok := m.exact[u.Scheme+"://"+u.Host]; ok {
+		return true
+	}
+	for _, w := range m.wildcard {
+		// the wildcard stands for at least one character, so ".example.com"
+		// itself is not a subdomain.
+		if u.Scheme == w.scheme && len(u.Host) > len(w.suffix) && strings.HasSuffix(u.Host, w.suffix) {
+			return true
+		}
+	}
+	return false
+}
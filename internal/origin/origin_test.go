@@ -0,0 +1,102 @@
+package origin
+
+import "testing"
+
+func TestMatcherAllowed(t *testing.T) {
+	matcher, err := NewMatcher([]string{
+		"https://app.example.com",
+		"http://localhost:3000",
+		" HTTPS://*.Example.com ",
+		"https://*.staging.example.org:8443",
+	})
+	if err != nil {
+		t.Fatalf("NewMatcher: %v", err)
+	}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		// exact
+		{"https://app.example.com", true},
+		{"HTTPS://APP.EXAMPLE.COM", true},
+		{"https://app.example.org", false},
+		// scheme
+		{"http://app.example.com", false},
+		{"https://localhost:3000", false},
+		{"wss://app.example.com", false},
+		// port
+		{"http://localhost:3000", true},
+		{"http://localhost", false},
+		{"http://localhost:30000", false},
+		{"https://a.staging.example.org:8443", true},
+		{"https://a.staging.example.org", false},
+		{"https://a.staging.example.org:9443", false},
+		{"https://a.example.com:8443", false},
+		// wildcard subdomain
+		{"https://chat.example.com", true},
+		{"https://a.b.example.com", true},
+		{"https://example.com", false},
+		{"https://.example.com", false},
+		{"http://chat.example.com", false},
+		// look-alike hosts
+		{"https://evil-example.com", false},
+		{"https://evilexample.com", false},
+		{"https://example.com.evil.com", false},
+		{"https://chat.example.com.evil.com", false},
+		{"https://app.example.com@evil.com", false},
+		// not an origin
+		{"", false},
+		{"null", false},
+		{"app.example.com", false},
+		{"https://", false},
+		{"https://evil.com:.example.com", false},
+	}
+	for _, tt := range tests {
+		if got := matcher.Allowed(tt.origin); got != tt.want {
+			t.Errorf("Allowed(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+	if matcher.AllowsAll() {
+		t.Error("AllowsAll() = true without a '*' pattern")
+	}
+}
+
+func TestMatcherAllowAll(t *testing.T) {
+	matcher, err := NewMatcher([]string{"https://app.example.com", "*"})
+	if err != nil {
+		t.Fatalf("NewMatcher: %v", err)
+	}
+	if !matcher.AllowsAll() || !matcher.Allowed("https://anything.test") {
+		t.Error("'*' pattern does not allow every origin")
+	}
+}
+
+func TestMatcherEmpty(t *testing.T) {
+	matcher, err := NewMatcher([]string{"", "  "})
+	if err != nil {
+		t.Fatalf("NewMatcher: %v", err)
+	}
+	if matcher.Allowed("https://app.example.com") {
+		t.Error("empty allow-list allowed an origin")
+	}
+}
+
+func TestNewMatcherRejectsInvalidPatterns(t *testing.T) {
+	for _, pattern := range []string{
+		"app.example.com",
+		"https://",
+		"://app.example.com",
+		"https://app.example.com/",
+		"https://app.example.com/path",
+		"https://app.example.com?x=1",
+		"https://*.",
+		"https://*.*.example.com",
+		"https://app.*.example.com",
+		"https://*example.com",
+	} {
+		if _, err := NewMatcher([]string{pattern}); err == nil {
+			t.Errorf("NewMatcher(%q) succeeded, want an error", pattern)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package password
+
+import (
+	"errors"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BcryptMaxBytes is the longest password bcrypt hashes; it rejects longer
+// ones, and older implementations silently ignored the excess.
+const BcryptMaxBytes = 72
+
+type bcryptScheme struct {
+	cost int
+}
+
+func (s *bcryptScheme) name() string {
+	return AlgorithmBcrypt
+}
+
+func (s *bcryptScheme) owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (s *bcryptScheme) hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), s.cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+func (s *bcryptScheme) verify(encoded string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *bcryptScheme) current(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err == nil && cost == s.cost
+}
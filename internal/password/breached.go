@@ -0,0 +1,90 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// BreachedList reports how often a password appeared in known breaches.
+type BreachedList interface {
+	Count(password string) (int, error)
+}
+
+// rangeDir reads the k-anonymity layout of the Have I Been Pwned password
+// list: one file per 5-character SHA-1 prefix, named after the prefix, with
+// "SUFFIX:COUNT" lines. Only the file for the password's prefix is read.
+// This is what "haveibeenpwned-downloader --single false" produces.
+type rangeDir struct {
+	dir      string
+	minCount int
+}
+
+// NewBreachedList opens the breached password list at path, which must be a
+// directory of range files as described on rangeDir. Passwords seen fewer
+// than minCount times are not reported.
+func NewBreachedList(path string, minCount int) (BreachedList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached password list: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("breached password list %s must be a directory of hash range files", path)
+	}
+	return &rangeDir{dir: path, minCount: minCount}, nil
+}
+
+func (d *rangeDir) Count(password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	f, err := d.open(prefix)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	count, err := findSuffix(f, suffix)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read hash range %s: %w", prefix, err)
+	}
+	if count < d.minCount {
+		return 0, nil
+	}
+	return count, nil
+}
+
+func (d *rangeDir) open(prefix string) (*os.File, error) {
+	f, err := os.Open(filepath.Join(d.dir, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		f, err = os.Open(filepath.Join(d.dir, prefix))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open hash range %s: %w", prefix, err)
+	}
+	return f, nil
+}
+
+func findSuffix(r io.Reader, suffix string) (int, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		lineSuffix, countText, ok := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if !ok || !strings.EqualFold(lineSuffix, suffix) {
+			continue
+		}
+		count, err := strconv.Atoi(countText)
+		if err != nil {
+			return 0, fmt.Errorf("malformed count %q", countText)
+		}
+		return count, nil
+	}
+	return 0, scanner.Err()
+}
@@ -0,0 +1,76 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeRange adds password to dir's range file for its hash prefix, as
+// seen count times.
+func writeRange(t *testing.T, dir string, name func(prefix string) string, password string, count string) {
+	t.Helper()
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	writeFile(t, filepath.Join(dir, name(hash[:5])),
+		"0000000000000000000000000000000000A:3\r\n"+strings.ToLower(hash[5:])+":"+count+"\r\n")
+}
+
+func TestBreachedListCount(t *testing.T) {
+	dir := t.TempDir()
+	writeRange(t, dir, func(prefix string) string { return prefix + ".txt" }, "leaked-often", "120")
+	writeRange(t, dir, func(prefix string) string { return prefix }, "leaked-once", "1")
+
+	tests := []struct {
+		name     string
+		minCount int
+		password string
+		want     int
+	}{
+		{"found", 1, "leaked-often", 120},
+		{"found in a range file without extension", 1, "leaked-once", 1},
+		{"at the threshold", 120, "leaked-often", 120},
+		{"below the threshold", 2, "leaked-once", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			list, err := NewBreachedList(dir, tt.minCount)
+			if err != nil {
+				t.Fatalf("NewBreachedList: %v", err)
+			}
+			got, err := list.Count(tt.password)
+			if err != nil {
+				t.Fatalf("Count: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("Count(%q) = %d, want %d", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreachedListErrors(t *testing.T) {
+	dir := t.TempDir()
+	writeRange(t, dir, func(prefix string) string { return prefix + ".txt" }, "garbled", "lots")
+
+	list, err := NewBreachedList(dir, 1)
+	if err != nil {
+		t.Fatalf("NewBreachedList: %v", err)
+	}
+	if _, err := list.Count("garbled"); err == nil {
+		t.Error("Count accepted a malformed count")
+	}
+	if _, err := list.Count("no range file for this one"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Count with a missing range file = %v, want os.ErrNotExist", err)
+	}
+
+	file := filepath.Join(dir, "list.txt")
+	writeFile(t, file, "")
+	if _, err := NewBreachedList(file, 1); err == nil {
+		t.Error("NewBreachedList accepted a plain file")
+	}
+}
@@ -0,0 +1,98 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sokolawesome/chat-server/internal/metrics"
+)
+
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+var ErrUnknownHash = errors.New("password hash format is not recognised")
+
+// Hasher hashes new passwords with one preferred algorithm and verifies
+// hashes produced by any supported one. Every hash encodes its algorithm
+// and parameters, so changing the configuration never breaks old hashes;
+// NeedsRehash tells callers when a hash should be upgraded.
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded. A mismatch is not an
+	// error; an unreadable hash is.
+	Verify(encoded string, password string) (bool, error)
+	// NeedsRehash reports whether encoded was produced by another algorithm
+	// or with other parameters than the ones currently configured.
+	NeedsRehash(encoded string) bool
+}
+
+// scheme is one hash encoding the Hasher understands.
+type scheme interface {
+	name() string
+	owns(encoded string) bool
+	hash(password string) (string, error)
+	verify(encoded string, password string) (bool, error)
+	current(encoded string) bool
+}
+
+type hasher struct {
+	preferred scheme
+	schemes   []scheme
+}
+
+// New returns a Hasher that hashes with algorithm and verifies both bcrypt
+// and Argon2id hashes.
+func New(algorithm string, bcryptCost int, argon2Params Argon2Params) (Hasher, error) {
+	bcryptScheme := &bcryptScheme{cost: bcryptCost}
+	argon2Scheme := &argon2idScheme{params: argon2Params}
+
+	h := &hasher{schemes: []scheme{bcryptScheme, argon2Scheme}}
+	switch algorithm {
+	case AlgorithmBcrypt:
+		h.preferred = bcryptScheme
+	case AlgorithmArgon2id:
+		h.preferred = argon2Scheme
+	default:
+		return nil, fmt.Errorf("unknown password hash algorithm %q", algorithm)
+	}
+	return h, nil
+}
+
+func (h *hasher) Hash(password string) (string, error) {
+	start := time.Now()
+	encoded, err := h.preferred.hash(password)
+	metrics.PasswordHashDuration.WithLabelValues(h.preferred.name(), "hash").Observe(time.Since(start).Seconds())
+	return encoded, err
+}
+
+func (h *hasher) Verify(encoded string, password string) (bool, error) {
+	s := h.schemeFor(encoded)
+	if s == nil {
+		return false, ErrUnknownHash
+	}
+
+	start := time.Now()
+	ok, err := s.verify(encoded, password)
+	metrics.PasswordHashDuration.WithLabelValues(s.name(), "compare").Observe(time.Since(start).Seconds())
+	return ok, err
+}
+
+func (h *hasher) NeedsRehash(encoded string) bool {
+	if !h.preferred.owns(encoded) {
+		// unknown formats cannot be verified, so there is nothing to upgrade.
+		return h.schemeFor(encoded) != nil
+	}
+	return !h.preferred.current(encoded)
+}
+
+func (h *hasher) schemeFor(encoded string) scheme {
+	for _, s := range h.schemes {
+		if s.owns(encoded) {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2 keeps hashing fast; the parameters still round-trip like the
+// production ones.
+var testArgon2 = Argon2Params{Memory: 64, Iterations: 1, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+
+func newTestHasher(t *testing.T, algorithm string, bcryptCost int, params Argon2Params) Hasher {
+	t.Helper()
+	h, err := New(algorithm, bcryptCost, params)
+	if err != nil {
+		t.Fatalf("New(%s): %v", algorithm, err)
+	}
+	return h
+}
+
+func TestHashVerify(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmBcrypt, AlgorithmArgon2id} {
+		t.Run(algorithm, func(t *testing.T) {
+			h := newTestHasher(t, algorithm, bcrypt.MinCost, testArgon2)
+
+			encoded, err := h.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("Hash: %v", err)
+			}
+			if ok, err := h.Verify(encoded, "correct horse"); err != nil || !ok {
+				t.Fatalf("Verify(right password) = %v, %v, want true", ok, err)
+			}
+			if ok, err := h.Verify(encoded, "correct horsE"); err != nil || ok {
+				t.Fatalf("Verify(wrong password) = %v, %v, want false", ok, err)
+			}
+			if h.NeedsRehash(encoded) {
+				t.Fatal("fresh hash needs a rehash")
+			}
+
+			again, _ := h.Hash("correct horse")
+			if again == encoded {
+				t.Fatal("two hashes of one password are equal; salt is not random")
+			}
+		})
+	}
+}
+
+func TestNewRejectsUnknownAlgorithm(t *testing.T) {
+	if _, err := New("scrypt", bcrypt.MinCost, testArgon2); err == nil {
+		t.Fatal("New accepted an unknown algorithm")
+	}
+}
+
+func TestVerifyUnknownHash(t *testing.T) {
+	h := newTestHasher(t, AlgorithmArgon2id, bcrypt.MinCost, testArgon2)
+	for _, encoded := range []string{
+		"",
+		"plaintext",
+		"$1$abc$def",
+		"$argon2id$v=19$m=64,t=1,p=2$c2FsdA",
+		"$argon2id$v=16$m=64,t=1,p=2$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1$c2FsdA$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=2$not base64!$a2V5",
+		"$argon2id$v=19$m=64,t=1,p=2$c2FsdA$not base64!",
+	} {
+		if ok, err := h.Verify(encoded, "password"); ok || !errors.Is(err, ErrUnknownHash) {
+			t.Errorf("Verify(%q) = %v, %v, want ErrUnknownHash", encoded, ok, err)
+		}
+	}
+}
+
+func TestArgon2idPHCRoundTrip(t *testing.T) {
+	params := Argon2Params{Memory: 96, Iterations: 3, Parallelism: 4, SaltLength: 12, KeyLength: 24}
+	s := &argon2idScheme{params: params}
+
+	encoded, err := s.hash("hunter22")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	if want := "$argon2id$v=19$m=96,t=3,p=4$"; !strings.HasPrefix(encoded, want) {
+		t.Fatalf("hash = %q, want prefix %q", encoded, want)
+	}
+
+	decoded, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		t.Fatalf("decodeArgon2id(%q): %v", encoded, err)
+	}
+	if decoded != params {
+		t.Fatalf("decoded params = %+v, want %+v", decoded, params)
+	}
+	if len(salt) != int(params.SaltLength) || len(key) != int(params.KeyLength) {
+		t.Fatalf("decoded %d byte salt and %d byte key, want %d and %d", len(salt), len(key), params.SaltLength, params.KeyLength)
+	}
+	if !s.current(encoded) {
+		t.Fatal("hash is not current for the parameters that produced it")
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	bcryptHash := func(cost int) string {
+		encoded, err := (&bcryptScheme{cost: cost}).hash("password")
+		if err != nil {
+			t.Fatalf("bcrypt hash: %v", err)
+		}
+		return encoded
+	}
+	argon2Hash := func(params Argon2Params) string {
+		encoded, err := (&argon2idScheme{params: params}).hash("password")
+		if err != nil {
+			t.Fatalf("argon2id hash: %v", err)
+		}
+		return encoded
+	}
+	withMemory, withIterations, withKeyLength := testArgon2, testArgon2, testArgon2
+	withMemory.Memory *= 2
+	withIterations.Iterations++
+	withKeyLength.KeyLength = 16
+
+	tests := []struct {
+		name      string
+		algorithm string
+		encoded   string
+		want      bool
+	}{
+		{"bcrypt current", AlgorithmBcrypt, bcryptHash(bcrypt.MinCost), false},
+		{"bcrypt cost raised", AlgorithmBcrypt, bcryptHash(bcrypt.MinCost + 1), true},
+		{"argon2id current", AlgorithmArgon2id, argon2Hash(testArgon2), false},
+		{"argon2id memory changed", AlgorithmArgon2id, argon2Hash(withMemory), true},
+		{"argon2id iterations changed", AlgorithmArgon2id, argon2Hash(withIterations), true},
+		{"argon2id key length changed", AlgorithmArgon2id, argon2Hash(withKeyLength), true},
+		{"bcrypt to argon2id", AlgorithmArgon2id, bcryptHash(bcrypt.MinCost), true},
+		{"argon2id to bcrypt", AlgorithmBcrypt, argon2Hash(testArgon2), true},
+		{"unknown format", AlgorithmArgon2id, "plaintext", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHasher(t, tt.algorithm, bcrypt.MinCost, testArgon2)
+			if got := h.NeedsRehash(tt.encoded); got != tt.want {
+				t.Fatalf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBcryptMaxBytes(t *testing.T) {
+	h := newTestHasher(t, AlgorithmBcrypt, bcrypt.MinCost, testArgon2)
+
+	longest := strings.Repeat("a", BcryptMaxBytes)
+	encoded, err := h.Hash(longest)
+	if err != nil {
+		t.Fatalf("Hash(%d bytes): %v", BcryptMaxBytes, err)
+	}
+	// the last byte takes part in the hash.
+	if ok, _ := h.Verify(encoded, longest[:BcryptMaxBytes-1]+"b"); ok {
+		t.Fatalf("byte %d of the password is ignored", BcryptMaxBytes)
+	}
+
+	if _, err := h.Hash(longest + "a"); err == nil {
+		t.Fatalf("Hash(%d bytes) succeeded; BcryptMaxBytes is out of date", BcryptMaxBytes+1)
+	}
+}
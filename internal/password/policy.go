@@ -0,0 +1,117 @@
+package password
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sokolawesome/chat-server/internal/logger"
+)
+
+//go:embed common_passwords.txt
+var commonPasswords string
+
+// Rules reported in Violation.Rule.
+const (
+	RuleMinLength = "min_length"
+	RuleMaxBytes  = "max_bytes"
+	RuleCommon    = "common"
+	RuleBreached  = "breached"
+)
+
+// Violation is one policy rule a password failed.
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// Policy decides which passwords users may choose. It applies to new
+// passwords only; existing ones keep working until they are changed.
+type Policy struct {
+	minLength int
+	maxBytes  int
+	banned    map[string]struct{}
+	breached  BreachedList
+}
+
+// NewPolicy builds a policy from the built-in list of common passwords plus
+// the optional bannedFile (one password per line, '#' starts a comment).
+// breached may be nil to skip the breached-password check.
+func NewPolicy(minLength int, maxBytes int, bannedFile string, breached BreachedList) (*Policy, error) {
+	banned := make(map[string]struct{})
+	if err := addPasswords(banned, strings.NewReader(commonPasswords)); err != nil {
+		return nil, err
+	}
+
+	if bannedFile != "" {
+		f, err := os.Open(bannedFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open banned password list: %w", err)
+		}
+		defer f.Close()
+		if err := addPasswords(banned, f); err != nil {
+			return nil, fmt.Errorf("failed to read banned password list: %w", err)
+		}
+	}
+
+	return &Policy{minLength: minLength, maxBytes: maxBytes, banned: banned, breached: breached}, nil
+}
+
+func addPasswords(set map[string]struct{}, r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		set[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
+
+// Check returns every rule password breaks, or nil if it is acceptable.
+// Length is counted in characters, the upper bound in bytes because that
+// is what bcrypt truncates at. The breached check is skipped when the list
+// cannot be read, so an unavailable file never blocks sign-ups.
+func (p *Policy) Check(ctx context.Context, password string) []Violation {
+	var violations []Violation
+
+	if utf8.RuneCountInString(password) < p.minLength {
+		violations = append(violations, Violation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("must be at least %d characters long", p.minLength),
+		})
+	}
+	if len(password) > p.maxBytes {
+		violations = append(violations, Violation{
+			Rule:    RuleMaxBytes,
+			Message: fmt.Sprintf("must be at most %d bytes long", p.maxBytes),
+		})
+	}
+	if _, banned := p.banned[strings.ToLower(password)]; banned {
+		violations = append(violations, Violation{
+			Rule:    RuleCommon,
+			Message: "is too common, choose a less predictable password",
+		})
+	}
+
+	if p.breached != nil {
+		count, err := p.breached.Count(password)
+		if err != nil {
+			slog.WarnContext(ctx, "breached password check unavailable", logger.Err(err))
+		} else if count > 0 {
+			violations = append(violations, Violation{
+				Rule:    RuleBreached,
+				Message: "has appeared in a data breach, choose a different password",
+			})
+		}
+	}
+
+	return violations
+}
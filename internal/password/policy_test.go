@@ -0,0 +1,98 @@
+package password
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func rules(violations []Violation) []string {
+	var got []string
+	for _, v := range violations {
+		got = append(got, v.Rule)
+	}
+	return got
+}
+
+func TestPolicyCheck(t *testing.T) {
+	banned := filepath.Join(t.TempDir(), "banned.txt")
+	writeFile(t, banned, "# house rules\n\n  Chat-Server-2024  \nacmecorp!\n")
+
+	policy, err := NewPolicy(8, BcryptMaxBytes, banned, nil)
+	if err != nil {
+		t.Fatalf("NewPolicy: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"acceptable", "tr0ub4dor&3", nil},
+		{"too short", "s3cr3t", []string{RuleMinLength}},
+		{"length counts characters", "пароль12", nil},
+		{"short in characters, long in bytes", "日本語のパス", []string{RuleMinLength}},
+		{"at the byte limit", strings.Repeat("x", BcryptMaxBytes), nil},
+		{"over the byte limit", strings.Repeat("x", BcryptMaxBytes+1), []string{RuleMaxBytes}},
+		{"multi-byte characters over the byte limit", strings.Repeat("é", BcryptMaxBytes/2+1), []string{RuleMaxBytes}},
+		{"built-in common password", "Password123", []string{RuleCommon}},
+		{"banned file entry", "chat-server-2024", []string{RuleCommon}},
+		{"banned file ignores case", "ACMECORP!", []string{RuleCommon}},
+		{"banned file comment is not a password", "# house rules", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules(policy.Check(context.Background(), tt.password)); !slices.Equal(got, tt.want) {
+				t.Fatalf("Check(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPolicyMissingBannedFile(t *testing.T) {
+	if _, err := NewPolicy(8, BcryptMaxBytes, filepath.Join(t.TempDir(), "missing.txt"), nil); err == nil {
+		t.Fatal("NewPolicy accepted a missing banned password file")
+	}
+}
+
+type fakeBreachedList struct {
+	count int
+	err   error
+}
+
+func (l fakeBreachedList) Count(string) (int, error) {
+	return l.count, l.err
+}
+
+func TestPolicyBreachedCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		list BreachedList
+		want []string
+	}{
+		{"breached", fakeBreachedList{count: 3}, []string{RuleBreached}},
+		{"not breached", fakeBreachedList{}, nil},
+		{"list unavailable", fakeBreachedList{err: os.ErrNotExist}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewPolicy(8, BcryptMaxBytes, "", tt.list)
+			if err != nil {
+				t.Fatalf("NewPolicy: %v", err)
+			}
+			if got := rules(policy.Check(context.Background(), "tr0ub4dor&3")); !slices.Equal(got, tt.want) {
+				t.Fatalf("Check = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
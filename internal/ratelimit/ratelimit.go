@@ -0,0 +1,108 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate allows Requests events per Per on average, with bursts of up to
+// Requests events.
+type Rate struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseRate parses "<requests>/<duration>", e.g. "60/1m" or "5/s". A bare
+// unit is read as one of that unit.
+func ParseRate(s string) (Rate, error) {
+	requests, per, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Rate{}, fmt.Errorf("rate '%s' must have the form <requests>/<duration>", s)
+	}
+	n, err := strconv.Atoi(requests)
+	if err != nil || n < 1 {
+		return Rate{}, fmt.Errorf("rate '%s' must allow a positive number of requests", s)
+	}
+	if per != "" && (per[0] < '0' || per[0] > '9') {
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Rate{}, fmt.Errorf("rate '%s' must have a positive duration", s)
+	}
+	return Rate{Requests: n, Per: d}, nil
+}
+
+func (r Rate) String() string {
+	return fmt.Sprintf("%d/%s", r.Requests, r.Per)
+}
+
+// bucket is a token bucket refilled continuously at Requests/Per tokens.
+type bucket struct {
+	tokens   float64
+	last     time.Time
+	lastSeen time.Time
+}
+
+// Limiter keeps one token bucket per key. Buckets idle long enough to have
+// refilled completely are forgotten, since a fresh bucket behaves the same.
+type Limiter struct {
+	rate Rate
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewLimiter(rate Rate) *Limiter {
+	return &Limiter{rate: rate, buckets: make(map[string]*bucket), lastSweep: time.Now(), now: time.Now}
+}
+
+func (l *Limiter) Rate() Rate {
+	return l.rate
+}
+
+// Allow takes a token from key's bucket. When the bucket is empty it returns
+// false and how long until the next token is available.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	now := l.now()
+	burst := float64(l.rate.Requests)
+	perToken := l.rate.Per / time.Duration(l.rate.Requests)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweepLocked(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	b.tokens = min(burst, b.tokens+float64(now.Sub(b.last))/float64(perToken))
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) * float64(perToken))
+}
+
+func (l *Limiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < l.rate.Per {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) >= l.rate.Per {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		s    string
+		want Rate
+	}{
+		{"60/1m", Rate{Requests: 60, Per: time.Minute}},
+		{"5/s", Rate{Requests: 5, Per: time.Second}},
+		{"1/h", Rate{Requests: 1, Per: time.Hour}},
+		{" 10/30s ", Rate{Requests: 10, Per: 30 * time.Second}},
+		{"20/1500ms", Rate{Requests: 20, Per: 1500 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRate(tt.s)
+		if err != nil {
+			t.Errorf("ParseRate(%q): %v", tt.s, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRate(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestParseRateRejectsInvalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"60",
+		"60/",
+		"/1m",
+		"0/1m",
+		"-5/1m",
+		"1.5/s",
+		"x/1m",
+		"60/0s",
+		"60/-1m",
+		"60/1x",
+		"60/fortnight",
+		"60/1m/1s",
+	} {
+		if rate, err := ParseRate(s); err == nil {
+			t.Errorf("ParseRate(%q) = %v, want an error", s, rate)
+		}
+	}
+}
+
+// fakeClock drives a Limiter's notion of time.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(rate Rate) (*Limiter, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := NewLimiter(rate)
+	l.now = clock.now
+	l.lastSweep = clock.t
+	return l, clock
+}
+
+func allowN(t *testing.T, l *Limiter, key string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if ok, wait := l.Allow(key); !ok {
+			t.Fatalf("request %d for %s denied, retry in %v", i+1, key, wait)
+		}
+	}
+}
+
+func assertDenied(t *testing.T, l *Limiter, key string, wantWait time.Duration) {
+	t.Helper()
+	ok, wait := l.Allow(key)
+	if ok {
+		t.Fatalf("request for %s allowed, want denied", key)
+	}
+	if wait != wantWait {
+		t.Fatalf("retry after = %v, want %v", wait, wantWait)
+	}
+}
+
+func TestLimiterBurst(t *testing.T) {
+	l, _ := newTestLimiter(Rate{Requests: 3, Per: 3 * time.Second})
+
+	allowN(t, l, "a", 3)
+	assertDenied(t, l, "a", time.Second)
+
+	// buckets are per key.
+	allowN(t, l, "b", 3)
+}
+
+func TestLimiterRefill(t *testing.T) {
+	l, clock := newTestLimiter(Rate{Requests: 3, Per: 3 * time.Second})
+	allowN(t, l, "a", 3)
+
+	clock.advance(400 * time.Millisecond)
+	assertDenied(t, l, "a", 600*time.Millisecond)
+
+	clock.advance(600 * time.Millisecond)
+	allowN(t, l, "a", 1)
+	assertDenied(t, l, "a", time.Second)
+
+	clock.advance(2 * time.Second)
+	allowN(t, l, "a", 2)
+	assertDenied(t, l, "a", time.Second)
+}
+
+func TestLimiterRefillCapsAtBurst(t *testing.T) {
+	l, clock := newTestLimiter(Rate{Requests: 3, Per: 3 * time.Second})
+	allowN(t, l, "a", 1)
+
+	// the bucket holds at most Requests tokens however long it sits idle.
+	clock.advance(time.Hour)
+	allowN(t, l, "a", 3)
+	assertDenied(t, l, "a", time.Second)
+}
+
+func TestLimiterForgetsIdleBuckets(t *testing.T) {
+	l, clock := newTestLimiter(Rate{Requests: 2, Per: time.Minute})
+	allowN(t, l, "idle", 2)
+	clock.advance(30 * time.Second)
+	allowN(t, l, "busy", 1)
+
+	clock.advance(30 * time.Second)
+	allowN(t, l, "busy", 1)
+	if _, ok := l.buckets["idle"]; ok {
+		t.Fatal("bucket idle for a full period was kept")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Fatal("bucket in use was swept")
+	}
+}
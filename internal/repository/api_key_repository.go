@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/tracing"
+)
+
+var (
+	ErrAPIKeyNotFound    = errors.New("api key not found")
+	ErrCreatingAPIKey    = errors.New("failed to create api key")
+	ErrRetrievingAPIKeys = errors.New("failed to retrieve api keys")
+	ErrDeletingAPIKey    = errors.New("failed to delete api key")
+)
+
+// lastUsedResolution limits how often authenticating with a key writes its
+// last-used timestamp, so a busy bot does not cause a write per request.
+const lastUsedResolution = time.Minute
+
+type APIKeyRepository interface {
+	CreateAPIKey(ctx context.Context, userID int64, name string, prefix string, keyHash string, scopes []string, expiresAt *time.Time) (*models.APIKey, error)
+	ListAPIKeys(ctx context.Context, userID int64) ([]models.APIKey, error)
+	CountAPIKeys(ctx context.Context, userID int64) (int, error)
+	DeleteAPIKey(ctx context.Context, userID int64, id int64) error
+	// AuthenticateAPIKey returns the key with the given hash and records it
+	// as used. Expired keys are returned too; the caller decides.
+	AuthenticateAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error)
+}
+
+type postgresAPIKeyRepository struct {
+	db *sql.DB
+}
+
+func NewAPIKeyRepository(db *sql.DB) APIKeyRepository {
+	return &postgresAPIKeyRepository{db: db}
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, scopes, created_at, last_used_at, expires_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	var scopes string
+	var lastUsedAt, expiresAt sql.NullTime
+	if err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &lastUsedAt, &expiresAt); err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	return key, nil
+}
+
+func (r *postgresAPIKeyRepository) CreateAPIKey(ctx context.Context, userID int64, name string, prefix string, keyHash string, scopes []string, expiresAt *time.Time) (*models.APIKey, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresAPIKeyRepository.CreateAPIKey", "INSERT", "api_keys")
+	defer span.End()
+
+	query := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+    VALUES ($1, $2, $3, $4, $5, $6)
+    RETURNING ` + apiKeyColumns
+
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, query, userID, name, prefix, keyHash, strings.Join(scopes, " "), expiresAt))
+	if err != nil {
+		slog.ErrorContext(ctx, "error inserting api key", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrCreatingAPIKey, err)
+	}
+
+	slog.InfoContext(ctx, "api key created", logger.UserID(userID), slog.Int64("api_key_id", key.ID), slog.String("prefix", prefix))
+	return key, nil
+}
+
+func (r *postgresAPIKeyRepository) ListAPIKeys(ctx context.Context, userID int64) ([]models.APIKey, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresAPIKeyRepository.ListAPIKeys", "SELECT", "api_keys")
+	defer span.End()
+
+	query := `SELECT ` + apiKeyColumns + `
+    FROM api_keys
+    WHERE user_id = $1
+    ORDER BY created_at DESC, id DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error listing api keys", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrRetrievingAPIKeys, err)
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			tracing.RecordError(span, err)
+			return nil, fmt.Errorf("%w: %v", ErrRetrievingAPIKeys, err)
+		}
+		keys = append(keys, *key)
+	}
+	if err := rows.Err(); err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrRetrievingAPIKeys, err)
+	}
+
+	return keys, nil
+}
+
+func (r *postgresAPIKeyRepository) CountAPIKeys(ctx context.Context, userID int64) (int, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresAPIKeyRepository.CountAPIKeys", "SELECT", "api_keys")
+	defer span.End()
+
+	var count int
+	if err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM api_keys WHERE user_id = $1`, userID).Scan(&count); err != nil {
+		slog.ErrorContext(ctx, "error counting api keys", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("%w: %v", ErrRetrievingAPIKeys, err)
+	}
+
+	return count, nil
+}
+
+func (r *postgresAPIKeyRepository) DeleteAPIKey(ctx context.Context, userID int64, id int64) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresAPIKeyRepository.DeleteAPIKey", "DELETE", "api_keys")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error deleting api key", logger.UserID(userID), slog.Int64("api_key_id", id), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrDeletingAPIKey, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDeletingAPIKey, err)
+	}
+	if rows == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	slog.InfoContext(ctx, "api key revoked", logger.UserID(userID), slog.Int64("api_key_id", id))
+	return nil
+}
+
+func (r *postgresAPIKeyRepository) AuthenticateAPIKey(ctx context.Context, keyHash string) (*models.APIKey, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresAPIKeyRepository.AuthenticateAPIKey", "SELECT", "api_keys")
+	defer span.End()
+
+	query := `SELECT ` + apiKeyColumns + `
+    FROM api_keys
+    WHERE key_hash = $1`
+
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, query, keyHash))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAPIKeyNotFound
+		}
+		slog.ErrorContext(ctx, "error retrieving api key", logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrRetrievingAPIKeys, err)
+	}
+
+	now := time.Now()
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedResolution {
+		if _, err := r.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, key.ID, now); err != nil {
+			// failing to record usage must not lock a bot out.
+			slog.WarnContext(ctx, "could not record api key usage", slog.Int64("api_key_id", key.ID), logger.Err(err))
+		} else {
+			key.LastUsedAt = &now
+		}
+	}
+
+	return key, nil
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/tracing"
+)
+
+var (
+	ErrIdentityNotFound   = errors.New("external identity not linked to any user")
+	ErrIdentityLinked     = errors.New("external identity is already linked to a user")
+	ErrRetrievingIdentity = errors.New("failed to retrieve external identity")
+	ErrLinkingIdentity    = errors.New("failed to link external identity")
+)
+
+// IdentityRepository maps identities at external providers, identified by
+// issuer and subject, to local users.
+type IdentityRepository interface {
+	// LoginIdentity returns the user linked to issuer/subject and records the
+	// login time.
+	LoginIdentity(ctx context.Context, issuer string, subject string) (int64, error)
+	LinkIdentity(ctx context.Context, userID int64, issuer string, subject string, email string) error
+}
+
+type postgresIdentityRepository struct {
+	db *sql.DB
+}
+
+func NewIdentityRepository(db *sql.DB) IdentityRepository {
+	return &postgresIdentityRepository{db: db}
+}
+
+func (r *postgresIdentityRepository) LoginIdentity(ctx context.Context, issuer string, subject string) (int64, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresIdentityRepository.LoginIdentity", "UPDATE", "user_identities")
+	defer span.End()
+
+	query := `UPDATE user_identities
+    SET last_login_at = CURRENT_TIMESTAMP
+    WHERE issuer = $1 AND subject = $2
+    RETURNING user_id`
+
+	var userID int64
+	if err := r.db.QueryRowContext(ctx, query, issuer, subject).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrIdentityNotFound
+		}
+		slog.ErrorContext(ctx, "error retrieving external identity", slog.String("issuer", issuer), logger.Err(err))
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("%w: %v", ErrRetrievingIdentity, err)
+	}
+
+	return userID, nil
+}
+
+func (r *postgresIdentityRepository) LinkIdentity(ctx context.Context, userID int64, issuer string, subject string, email string) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresIdentityRepository.LinkIdentity", "INSERT", "user_identities")
+	defer span.End()
+
+	query := `INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at)
+    VALUES ($1, $2, $3, NULLIF($4, ''), CURRENT_TIMESTAMP)`
+
+	if _, err := r.db.ExecContext(ctx, query, userID, issuer, subject, email); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			tracing.RecordError(span, ErrIdentityLinked)
+			return ErrIdentityLinked
+		}
+		slog.ErrorContext(ctx, "error linking external identity", logger.UserID(userID), slog.String("issuer", issuer), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrLinkingIdentity, err)
+	}
+
+	slog.InfoContext(ctx, "external identity linked", logger.UserID(userID), slog.String("issuer", issuer))
+	return nil
+}
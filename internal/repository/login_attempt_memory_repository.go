@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sokolawesome/chat-server/internal/models"
+)
+
+const (
+	// loginAttemptSweepInterval bounds how often stale keys are looked for.
+	loginAttemptSweepInterval = time.Minute
+	// maxInMemoryLockouts caps the lockout audit trail; the oldest entries
+	// are dropped first.
+	maxInMemoryLockouts = 1000
+)
+
+// inMemoryLoginAttemptRepository keeps attempts in process memory. It suits
+// single-replica deployments and development; state is lost on restart and
+// is not shared between replicas.
+type inMemoryLoginAttemptRepository struct {
+	mu       sync.Mutex
+	attempts map[string]models.LoginAttempts
+	lockouts []models.LoginLockout
+
+	// ttl is how long a key is kept after its last attempt once it is no
+	// longer locked; callers pass their failure window, after which the
+	// state no longer affects anything.
+	ttl       time.Duration
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewInMemoryLoginAttemptRepository(ttl time.Duration) LoginAttemptRepository {
+	return &inMemoryLoginAttemptRepository{
+		attempts: make(map[string]models.LoginAttempts),
+		ttl:      ttl,
+		now:      time.Now,
+	}
+}
+
+func (r *inMemoryLoginAttemptRepository) UpdateAttempts(ctx context.Context, keys []string, fn func(attempts []*models.LoginAttempts) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sweep()
+
+	attempts := make([]*models.LoginAttempts, len(keys))
+	for i, key := range keys {
+		a, ok := r.attempts[key]
+		if !ok {
+			a = models.LoginAttempts{Key: key}
+		}
+		attempts[i] = &a
+	}
+
+	if err := fn(attempts); err != nil {
+		return err
+	}
+
+	for _, a := range attempts {
+		if a.Failures == 0 && a.LockedUntil.IsZero() {
+			delete(r.attempts, a.Key)
+			continue
+		}
+		r.attempts[a.Key] = *a
+	}
+	return nil
+}
+
+// sweep drops keys whose last attempt is older than ttl and whose lock, if
+// any, has expired. It runs at most once per loginAttemptSweepInterval.
+func (r *inMemoryLoginAttemptRepository) sweep() {
+	now := r.now()
+	if now.Sub(r.lastSweep) < loginAttemptSweepInterval {
+		return
+	}
+	r.lastSweep = now
+
+	for key, a := range r.attempts {
+		if a.LastAttemptAt.Add(r.ttl).Before(now) && !a.LockedUntil.After(now) {
+			delete(r.attempts, key)
+		}
+	}
+}
+
+func (r *inMemoryLoginAttemptRepository) ResetAttempts(ctx context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, key)
+	return nil
+}
+
+func (r *inMemoryLoginAttemptRepository) RecordLockout(ctx context.Context, lockout *models.LoginLockout) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lockout.CreatedAt = r.now()
+	if len(r.lockouts) >= maxInMemoryLockouts {
+		r.lockouts = append(r.lockouts[:0], r.lockouts[len(r.lockouts)-maxInMemoryLockouts+1:]...)
+	}
+	r.lockouts = append(r.lockouts, *lockout)
+	return nil
+}
@@ -0,0 +1,127 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sokolawesome/chat-server/internal/models"
+)
+
+func newTestMemoryStore(ttl time.Duration, now *time.Time) *inMemoryLoginAttemptRepository {
+	r := NewInMemoryLoginAttemptRepository(ttl).(*inMemoryLoginAttemptRepository)
+	r.now = func() time.Time { return *now }
+	return r
+}
+
+func addFailure(t *testing.T, r LoginAttemptRepository, key string, at time.Time, lockedUntil time.Time) {
+	t.Helper()
+	err := r.UpdateAttempts(context.Background(), []string{key}, func(attempts []*models.LoginAttempts) error {
+		attempts[0].Failures++
+		attempts[0].LastAttemptAt = at
+		attempts[0].LastFailureAt = at
+		attempts[0].LockedUntil = lockedUntil
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateAttempts: %v", err)
+	}
+}
+
+func TestInMemoryUpdateAttempts(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := newTestMemoryStore(time.Hour, &now)
+	ctx := context.Background()
+
+	addFailure(t, r, "user:id:1", now, time.Time{})
+	addFailure(t, r, "user:id:1", now, time.Time{})
+
+	err := r.UpdateAttempts(ctx, []string{"user:id:1", "ip:10.0.0.1"}, func(attempts []*models.LoginAttempts) error {
+		if attempts[0].Key != "user:id:1" || attempts[0].Failures != 2 {
+			t.Errorf("first key state = %+v, want 2 failures", *attempts[0])
+		}
+		if attempts[1].Key != "ip:10.0.0.1" || attempts[1].Failures != 0 {
+			t.Errorf("new key state = %+v, want zero", *attempts[1])
+		}
+		attempts[0].Failures = 99
+		return errors.New("abort")
+	})
+	if err == nil || err.Error() != "abort" {
+		t.Fatalf("UpdateAttempts error = %v, want the callback's error", err)
+	}
+	if got := r.attempts["user:id:1"].Failures; got != 2 {
+		t.Errorf("failures after aborted update = %d, want 2", got)
+	}
+
+	err = r.UpdateAttempts(ctx, []string{"user:id:1"}, func(attempts []*models.LoginAttempts) error {
+		attempts[0].Failures = 0
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("UpdateAttempts: %v", err)
+	}
+	if _, ok := r.attempts["user:id:1"]; ok {
+		t.Error("state with no failures and no lock was kept")
+	}
+}
+
+func TestInMemorySweepsStaleKeys(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := newTestMemoryStore(time.Hour, &now)
+
+	addFailure(t, r, "stale", now, time.Time{})
+	addFailure(t, r, "locked", now, now.Add(3*time.Hour))
+	addFailure(t, r, "recent", now.Add(90*time.Minute), time.Time{})
+
+	now = now.Add(2 * time.Hour)
+	addFailure(t, r, "trigger", now, time.Time{})
+
+	for key, want := range map[string]bool{"stale": false, "locked": true, "recent": true, "trigger": true} {
+		if _, ok := r.attempts[key]; ok != want {
+			t.Errorf("key %q kept = %v, want %v", key, ok, want)
+		}
+	}
+
+	// the lock has expired and the failure is old: now it goes too.
+	now = now.Add(2 * time.Hour)
+	addFailure(t, r, "trigger", now, time.Time{})
+	if _, ok := r.attempts["locked"]; ok {
+		t.Error("key with an expired lock was kept")
+	}
+}
+
+func TestInMemorySweepIsThrottled(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := newTestMemoryStore(time.Second, &now)
+
+	addFailure(t, r, "a", now, time.Time{})
+	now = now.Add(loginAttemptSweepInterval / 2)
+	addFailure(t, r, "b", now, time.Time{})
+	if _, ok := r.attempts["a"]; !ok {
+		t.Fatal("swept again before loginAttemptSweepInterval passed")
+	}
+
+	now = now.Add(loginAttemptSweepInterval)
+	addFailure(t, r, "b", now, time.Time{})
+	if _, ok := r.attempts["a"]; ok {
+		t.Fatal("stale key survived a sweep")
+	}
+}
+
+func TestInMemoryLockoutsAreBounded(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	r := newTestMemoryStore(time.Hour, &now)
+
+	for i := 0; i < maxInMemoryLockouts+10; i++ {
+		if err := r.RecordLockout(context.Background(), &models.LoginLockout{Key: "k", Failures: i}); err != nil {
+			t.Fatalf("RecordLockout: %v", err)
+		}
+	}
+	if len(r.lockouts) != maxInMemoryLockouts {
+		t.Fatalf("kept %d lockouts, want %d", len(r.lockouts), maxInMemoryLockouts)
+	}
+	if r.lockouts[0].Failures != 10 || r.lockouts[len(r.lockouts)-1].Failures != maxInMemoryLockouts+9 {
+		t.Errorf("kept lockouts %d..%d, want the newest", r.lockouts[0].Failures, r.lockouts[len(r.lockouts)-1].Failures)
+	}
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/tracing"
+)
+
+var (
+	ErrRecordingLoginAttempt = errors.New("failed to record login attempt")
+)
+
+// LoginAttemptRepository tracks failed logins per key (an account or client
+// IP) and keeps an audit trail of lockouts.
+type LoginAttemptRepository interface {
+	// UpdateAttempts loads the state of every key, locked against concurrent
+	// updates, lets fn change it in place and stores the result atomically.
+	// Keys without state start from zero values, and state left with no
+	// failures and no lock is deleted. Nothing is stored if fn returns an
+	// error, which UpdateAttempts passes through.
+	UpdateAttempts(ctx context.Context, keys []string, fn func(attempts []*models.LoginAttempts) error) error
+	ResetAttempts(ctx context.Context, key string) error
+	RecordLockout(ctx context.Context, lockout *models.LoginLockout) error
+}
+
+type postgresLoginAttemptRepository struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptRepository(db *sql.DB) LoginAttemptRepository {
+	return &postgresLoginAttemptRepository{db: db}
+}
+
+func (r *postgresLoginAttemptRepository) UpdateAttempts(ctx context.Context, keys []string, fn func(attempts []*models.LoginAttempts) error) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresLoginAttemptRepository.UpdateAttempts", "UPDATE", "login_attempts")
+	defer span.End()
+
+	var fnErr error
+	err := inTx(ctx, r.db, func(tx *sql.Tx) error {
+		// rows are locked in key order so two requests sharing keys cannot
+		// deadlock each other.
+		order := make([]int, len(keys))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(a, b int) bool { return keys[order[a]] < keys[order[b]] })
+
+		attempts := make([]*models.LoginAttempts, len(keys))
+		for _, i := range order {
+			// a placeholder row gives a key seen for the first time something to lock.
+			if _, err := tx.ExecContext(ctx, `INSERT INTO login_attempts (key, failures, last_attempt_at, last_failure_at)
+    VALUES ($1, 0, to_timestamp(0), to_timestamp(0))
+    ON CONFLICT (key) DO NOTHING`, keys[i]); err != nil {
+				return err
+			}
+
+			a := &models.LoginAttempts{Key: keys[i]}
+			var lockedUntil sql.NullTime
+			query := `SELECT failures, last_attempt_at, last_failure_at, locked_until
+    FROM login_attempts
+    WHERE key = $1
+    FOR UPDATE`
+			if err := tx.QueryRowContext(ctx, query, keys[i]).Scan(&a.Failures, &a.LastAttemptAt, &a.LastFailureAt, &lockedUntil); err != nil {
+				return err
+			}
+			a.LockedUntil = lockedUntil.Time
+			attempts[i] = a
+		}
+
+		if err := fn(attempts); err != nil {
+			fnErr = err
+			return err
+		}
+
+		for _, a := range attempts {
+			if a.Failures == 0 && a.LockedUntil.IsZero() {
+				if _, err := tx.ExecContext(ctx, `DELETE FROM login_attempts WHERE key = $1`, a.Key); err != nil {
+					return err
+				}
+				continue
+			}
+
+			lockedUntil := sql.NullTime{Time: a.LockedUntil, Valid: !a.LockedUntil.IsZero()}
+			query := `UPDATE login_attempts
+    SET failures = $2, last_attempt_at = $3, last_failure_at = $4, locked_until = $5
+    WHERE key = $1`
+			if _, err := tx.ExecContext(ctx, query, a.Key, a.Failures, a.LastAttemptAt, a.LastFailureAt, lockedUntil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if fnErr != nil {
+			return fnErr
+		}
+		slog.ErrorContext(ctx, "error updating login attempts", slog.Any("keys", keys), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrRecordingLoginAttempt, err)
+	}
+
+	return nil
+}
+
+func (r *postgresLoginAttemptRepository) ResetAttempts(ctx context.Context, key string) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresLoginAttemptRepository.ResetAttempts", "DELETE", "login_attempts")
+	defer span.End()
+
+	query := `DELETE FROM login_attempts WHERE key = $1`
+
+	if _, err := r.db.ExecContext(ctx, query, key); err != nil {
+		slog.ErrorContext(ctx, "error resetting login attempts", slog.String("key", key), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrRecordingLoginAttempt, err)
+	}
+
+	return nil
+}
+
+func (r *postgresLoginAttemptRepository) RecordLockout(ctx context.Context, lockout *models.LoginLockout) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresLoginAttemptRepository.RecordLockout", "INSERT", "login_lockouts")
+	defer span.End()
+
+	query := `INSERT INTO login_lockouts (key, failures, locked_until)
+    VALUES ($1, $2, $3)
+    RETURNING created_at`
+
+	if err := r.db.QueryRowContext(ctx, query, lockout.Key, lockout.Failures, lockout.LockedUntil).Scan(&lockout.CreatedAt); err != nil {
+		slog.ErrorContext(ctx, "error recording login lockout", slog.String("key", lockout.Key), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrRecordingLoginAttempt, err)
+	}
+
+	return nil
+}
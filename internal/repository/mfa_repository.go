@@ -0,0 +1,280 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/tracing"
+)
+
+var (
+	ErrTOTPNotFound   = errors.New("totp enrollment not found")
+	ErrRetrievingMFA  = errors.New("failed to retrieve mfa settings")
+	ErrUpdatingMFA    = errors.New("failed to update mfa settings")
+	ErrTOTPNotPending = errors.New("no pending totp enrollment to confirm")
+)
+
+type MFARepository interface {
+	GetTOTP(ctx context.Context, userID int64) (*models.TOTP, error)
+	// SaveTOTPSecret starts or restarts an enrollment with a new secret. It
+	// never touches an enrollment that is already enabled.
+	SaveTOTPSecret(ctx context.Context, userID int64, secret string) error
+	// EnableTOTP confirms the pending enrollment and replaces the user's
+	// recovery codes, atomically.
+	EnableTOTP(ctx context.Context, userID int64, step int64, recoveryCodeHashes []string) error
+	// UseTOTPStep records step as used, returning false if it (or a later
+	// step) was already used, so each code is accepted only once.
+	UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error)
+	// UseRecoveryCode spends a recovery code, returning false if it does not
+	// exist or was already used.
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error)
+	DisableTOTP(ctx context.Context, userID int64) error
+
+	// CreateMFAChallenge records a challenge issued after a correct password
+	// and prunes the user's expired ones.
+	CreateMFAChallenge(ctx context.Context, userID int64, id string, expiresAt time.Time) error
+	// UseMFAChallengeAttempt counts one code attempt against the challenge,
+	// returning false once it is expired, spent or out of attempts.
+	UseMFAChallengeAttempt(ctx context.Context, userID int64, id string, maxAttempts int) (bool, error)
+	// ConsumeMFAChallenge deletes the challenge after a successful sign-in,
+	// returning false if another request already consumed it.
+	ConsumeMFAChallenge(ctx context.Context, userID int64, id string) (bool, error)
+}
+
+type postgresMFARepository struct {
+	db *sql.DB
+}
+
+func NewMFARepository(db *sql.DB) MFARepository {
+	return &postgresMFARepository{db: db}
+}
+
+func (r *postgresMFARepository) GetTOTP(ctx context.Context, userID int64) (*models.TOTP, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresMFARepository.GetTOTP", "SELECT", "user_totp")
+	defer span.End()
+
+	totp := &models.TOTP{UserID: userID}
+	var enabledAt sql.NullTime
+	var lastUsedStep sql.NullInt64
+	query := `SELECT secret, enabled_at, last_used_step, created_at
+    FROM user_totp
+    WHERE user_id = $1`
+
+	if err := r.db.QueryRowContext(ctx, query, userID).Scan(&totp.Secret, &enabledAt, &lastUsedStep, &totp.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrTOTPNotFound
+		}
+		slog.ErrorContext(ctx, "error retrieving totp enrollment", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrRetrievingMFA, err)
+	}
+	if enabledAt.Valid {
+		totp.EnabledAt = &enabledAt.Time
+	}
+	totp.LastUsedStep = lastUsedStep.Int64
+
+	return totp, nil
+}
+
+func (r *postgresMFARepository) SaveTOTPSecret(ctx context.Context, userID int64, secret string) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresMFARepository.SaveTOTPSecret", "INSERT", "user_totp")
+	defer span.End()
+
+	query := `INSERT INTO user_totp (user_id, secret)
+    VALUES ($1, $2)
+    ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = CURRENT_TIMESTAMP
+    WHERE user_totp.enabled_at IS NULL`
+
+	if _, err := r.db.ExecContext(ctx, query, userID, secret); err != nil {
+		slog.ErrorContext(ctx, "error saving totp secret", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrUpdatingMFA, err)
+	}
+
+	return nil
+}
+
+func (r *postgresMFARepository) EnableTOTP(ctx context.Context, userID int64, step int64, recoveryCodeHashes []string) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresMFARepository.EnableTOTP", "UPDATE", "user_totp")
+	defer span.End()
+
+	err := inTx(ctx, r.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `UPDATE user_totp
+        SET enabled_at = CURRENT_TIMESTAMP, last_used_step = $2
+        WHERE user_id = $1 AND enabled_at IS NULL`, userID, step)
+		if err != nil {
+			return err
+		}
+		if rows, err := result.RowsAffected(); err != nil {
+			return err
+		} else if rows == 0 {
+			return ErrTOTPNotPending
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		for _, codeHash := range recoveryCodeHashes {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, codeHash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrTOTPNotPending) {
+			return err
+		}
+		slog.ErrorContext(ctx, "error enabling totp", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrUpdatingMFA, err)
+	}
+
+	slog.InfoContext(ctx, "totp enabled", logger.UserID(userID))
+	return nil
+}
+
+func (r *postgresMFARepository) UseTOTPStep(ctx context.Context, userID int64, step int64) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresMFARepository.UseTOTPStep", "UPDATE", "user_totp")
+	defer span.End()
+
+	query := `UPDATE user_totp
+    SET last_used_step = $2
+    WHERE user_id = $1 AND enabled_at IS NOT NULL AND (last_used_step IS NULL OR last_used_step < $2)`
+
+	result, err := r.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		slog.ErrorContext(ctx, "error recording totp step", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return false, fmt.Errorf("%w: %v", ErrUpdatingMFA, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrUpdatingMFA, err)
+	}
+
+	return rows == 1, nil
+}
+
+func (r *postgresMFARepository) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresMFARepository.UseRecoveryCode", "UPDATE", "mfa_recovery_codes")
+	defer span.End()
+
+	query := `UPDATE mfa_recovery_codes
+    SET used_at = CURRENT_TIMESTAMP
+    WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	result, err := r.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		slog.ErrorContext(ctx, "error spending recovery code", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return false, fmt.Errorf("%w: %v", ErrUpdatingMFA, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrUpdatingMFA, err)
+	}
+
+	if rows == 1 {
+		slog.InfoContext(ctx, "recovery code used", logger.UserID(userID))
+	}
+	return rows == 1, nil
+}
+
+func (r *postgresMFARepository) DisableTOTP(ctx context.Context, userID int64) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresMFARepository.DisableTOTP", "DELETE", "user_totp")
+	defer span.End()
+
+	err := inTx(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID)
+		return err
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "error disabling totp", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrUpdatingMFA, err)
+	}
+
+	slog.InfoContext(ctx, "totp disabled", logger.UserID(userID))
+	return nil
+}
+
+func (r *postgresMFARepository) CreateMFAChallenge(ctx context.Context, userID int64, id string, expiresAt time.Time) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresMFARepository.CreateMFAChallenge", "INSERT", "mfa_challenges")
+	defer span.End()
+
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM mfa_challenges WHERE user_id = $1 AND expires_at <= CURRENT_TIMESTAMP`, userID); err != nil {
+		slog.WarnContext(ctx, "could not prune expired mfa challenges", logger.UserID(userID), logger.Err(err))
+	}
+
+	query := `INSERT INTO mfa_challenges (id, user_id, expires_at) VALUES ($1, $2, $3)`
+	if _, err := r.db.ExecContext(ctx, query, id, userID, expiresAt); err != nil {
+		slog.ErrorContext(ctx, "error storing mfa challenge", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrUpdatingMFA, err)
+	}
+
+	return nil
+}
+
+func (r *postgresMFARepository) UseMFAChallengeAttempt(ctx context.Context, userID int64, id string, maxAttempts int) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresMFARepository.UseMFAChallengeAttempt", "UPDATE", "mfa_challenges")
+	defer span.End()
+
+	query := `UPDATE mfa_challenges
+    SET attempts = attempts + 1
+    WHERE id = $1 AND user_id = $2 AND expires_at > CURRENT_TIMESTAMP AND attempts < $3`
+
+	result, err := r.db.ExecContext(ctx, query, id, userID, maxAttempts)
+	if err != nil {
+		slog.ErrorContext(ctx, "error counting mfa challenge attempt", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return false, fmt.Errorf("%w: %v", ErrUpdatingMFA, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrUpdatingMFA, err)
+	}
+
+	return rows == 1, nil
+}
+
+func (r *postgresMFARepository) ConsumeMFAChallenge(ctx context.Context, userID int64, id string) (bool, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresMFARepository.ConsumeMFAChallenge", "DELETE", "mfa_challenges")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM mfa_challenges WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error consuming mfa challenge", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return false, fmt.Errorf("%w: %v", ErrUpdatingMFA, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrUpdatingMFA, err)
+	}
+
+	return rows == 1, nil
+}
+
+// inTx runs fn in a transaction that is committed only if fn succeeds.
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/tracing"
+)
+
+var (
+	ErrResetTokenInvalid   = errors.New("password reset token is invalid, expired or already used")
+	ErrCreatingResetToken  = errors.New("failed to create password reset token")
+	ErrConsumingResetToken = errors.New("failed to consume password reset token")
+)
+
+// PasswordResetRepository stores SHA-256 hashes of password reset tokens;
+// the raw token only ever exists in the email sent to the user.
+type PasswordResetRepository interface {
+	CreateToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error
+	// ConsumeToken marks the token as used and returns its owner. Every other
+	// outstanding token of that user is invalidated at the same time.
+	ConsumeToken(ctx context.Context, tokenHash string) (int64, error)
+}
+
+type postgresPasswordResetRepository struct {
+	db *sql.DB
+}
+
+func NewPasswordResetRepository(db *sql.DB) PasswordResetRepository {
+	return &postgresPasswordResetRepository{db: db}
+}
+
+func (r *postgresPasswordResetRepository) CreateToken(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresPasswordResetRepository.CreateToken", "INSERT", "password_reset_tokens")
+	defer span.End()
+
+	query := `INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+    VALUES ($1, $2, $3)`
+
+	if _, err := r.db.ExecContext(ctx, query, userID, tokenHash, expiresAt); err != nil {
+		slog.ErrorContext(ctx, "error inserting password reset token", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrCreatingResetToken, err)
+	}
+
+	return nil
+}
+
+func (r *postgresPasswordResetRepository) ConsumeToken(ctx context.Context, tokenHash string) (int64, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresPasswordResetRepository.ConsumeToken", "UPDATE", "password_reset_tokens")
+	defer span.End()
+
+	query := `WITH consumed AS (
+        UPDATE password_reset_tokens
+        SET used_at = CURRENT_TIMESTAMP
+        WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+        RETURNING user_id
+    ), invalidated AS (
+        UPDATE password_reset_tokens
+        SET used_at = CURRENT_TIMESTAMP
+        WHERE user_id IN (SELECT user_id FROM consumed) AND used_at IS NULL AND token_hash <> $1
+    )
+    SELECT user_id FROM consumed`
+
+	var userID int64
+	if err := r.db.QueryRowContext(ctx, query, tokenHash).Scan(&userID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrResetTokenInvalid
+		}
+		slog.ErrorContext(ctx, "error consuming password reset token", logger.Err(err))
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("%w: %v", ErrConsumingResetToken, err)
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/tracing"
+)
+
+var (
+	ErrAvatarNotFound    = errors.New("avatar not found")
+	ErrRetrievingProfile = errors.New("failed to retrieve profile")
+	ErrUpdatingProfile   = errors.New("failed to update profile")
+)
+
+type ProfileRepository interface {
+	GetProfile(ctx context.Context, userID int64) (*models.Profile, error)
+	UpdateProfile(ctx context.Context, userID int64, update models.ProfileUpdate) (*models.Profile, error)
+	// SetAvatar replaces every stored size of the user's avatar at once.
+	SetAvatar(ctx context.Context, userID int64, contentType string, images map[int][]byte) (*models.Profile, error)
+	DeleteAvatar(ctx context.Context, userID int64) (*models.Profile, error)
+	GetAvatar(ctx context.Context, userID int64, size int) (*models.Avatar, error)
+}
+
+type postgresProfileRepository struct {
+	db *sql.DB
+}
+
+func NewProfileRepository(db *sql.DB) ProfileRepository {
+	return &postgresProfileRepository{db: db}
+}
+
+const profileColumns = `id, username, display_name, bio, pronouns, timezone, avatar_updated_at, created_at, profile_updated_at`
+
+func scanProfile(row rowScanner) (*models.Profile, error) {
+	profile := &models.Profile{}
+	var avatarUpdatedAt sql.NullTime
+	if err := row.Scan(&profile.UserID, &profile.Username, &profile.DisplayName, &profile.Bio, &profile.Pronouns, &profile.Timezone, &avatarUpdatedAt, &profile.CreatedAt, &profile.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if avatarUpdatedAt.Valid {
+		profile.AvatarUpdatedAt = &avatarUpdatedAt.Time
+	}
+	return profile, nil
+}
+
+func (r *postgresProfileRepository) GetProfile(ctx context.Context, userID int64) (*models.Profile, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresProfileRepository.GetProfile", "SELECT", "users")
+	defer span.End()
+
+	query := `SELECT ` + profileColumns + `
+    FROM users
+    WHERE id = $1`
+
+	profile, err := scanProfile(r.db.QueryRowContext(ctx, query, userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		slog.ErrorContext(ctx, "error retrieving profile", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrRetrievingProfile, err)
+	}
+
+	return profile, nil
+}
+
+func (r *postgresProfileRepository) UpdateProfile(ctx context.Context, userID int64, update models.ProfileUpdate) (*models.Profile, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresProfileRepository.UpdateProfile", "UPDATE", "users")
+	defer span.End()
+
+	query := `UPDATE users
+    SET display_name = COALESCE($2, display_name),
+        bio = COALESCE($3, bio),
+        pronouns = COALESCE($4, pronouns),
+        timezone = COALESCE($5, timezone),
+        profile_updated_at = CURRENT_TIMESTAMP
+    WHERE id = $1
+    RETURNING ` + profileColumns
+
+	profile, err := scanProfile(r.db.QueryRowContext(ctx, query, userID, update.DisplayName, update.Bio, update.Pronouns, update.Timezone))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrUserNotFound
+		}
+		slog.ErrorContext(ctx, "error updating profile", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrUpdatingProfile, err)
+	}
+
+	slog.InfoContext(ctx, "profile updated", logger.UserID(userID))
+	return profile, nil
+}
+
+func (r *postgresProfileRepository) SetAvatar(ctx context.Context, userID int64, contentType string, images map[int][]byte) (*models.Profile, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresProfileRepository.SetAvatar", "INSERT", "user_avatars")
+	defer span.End()
+
+	var profile *models.Profile
+	err := inTx(ctx, r.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM user_avatars WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		for size, data := range images {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO user_avatars (user_id, size, content_type, data) VALUES ($1, $2, $3, $4)`, userID, size, contentType, data); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		profile, err = touchAvatar(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		}
+		slog.ErrorContext(ctx, "error storing avatar", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrUpdatingProfile, err)
+	}
+
+	slog.InfoContext(ctx, "avatar updated", logger.UserID(userID))
+	return profile, nil
+}
+
+func (r *postgresProfileRepository) DeleteAvatar(ctx context.Context, userID int64) (*models.Profile, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresProfileRepository.DeleteAvatar", "DELETE", "user_avatars")
+	defer span.End()
+
+	var profile *models.Profile
+	err := inTx(ctx, r.db, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, `DELETE FROM user_avatars WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+		if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+			return ErrAvatarNotFound
+		}
+
+		profile, err = touchAvatar(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, ErrAvatarNotFound) || errors.Is(err, ErrUserNotFound) {
+			return nil, err
+		}
+		slog.ErrorContext(ctx, "error deleting avatar", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrUpdatingProfile, err)
+	}
+
+	slog.InfoContext(ctx, "avatar removed", logger.UserID(userID))
+	return profile, nil
+}
+
+// touchAvatar records that the user's avatar changed. avatar_updated_at is
+// cleared when no sizes are left, so profiles only link avatars that exist.
+func touchAvatar(ctx context.Context, tx *sql.Tx, userID int64) (*models.Profile, error) {
+	query := `UPDATE users
+    SET avatar_updated_at = CASE WHEN EXISTS (SELECT 1 FROM user_avatars WHERE user_id = $1) THEN CURRENT_TIMESTAMP END,
+        profile_updated_at = CURRENT_TIMESTAMP
+    WHERE id = $1
+    RETURNING ` + profileColumns
+
+	profile, err := scanProfile(tx.QueryRowContext(ctx, query, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	return profile, err
+}
+
+func (r *postgresProfileRepository) GetAvatar(ctx context.Context, userID int64, size int) (*models.Avatar, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresProfileRepository.GetAvatar", "SELECT", "user_avatars")
+	defer span.End()
+
+	query := `SELECT a.content_type, a.data, u.avatar_updated_at
+    FROM user_avatars a
+    JOIN users u ON u.id = a.user_id
+    WHERE a.user_id = $1 AND a.size = $2`
+
+	avatar := &models.Avatar{}
+	if err := r.db.QueryRowContext(ctx, query, userID, size).Scan(&avatar.ContentType, &avatar.Data, &avatar.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrAvatarNotFound
+		}
+		slog.ErrorContext(ctx, "error retrieving avatar", logger.UserID(userID), slog.Int("size", size), logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrRetrievingProfile, err)
+	}
+
+	return avatar, nil
+}
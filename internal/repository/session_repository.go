@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/tracing"
+)
+
+var (
+	ErrSessionNotFound    = errors.New("session not found or expired")
+	ErrCreatingSession    = errors.New("failed to create session")
+	ErrRetrievingSessions = errors.New("failed to retrieve sessions")
+	ErrUpdatingSession    = errors.New("failed to update session")
+)
+
+type SessionRepository interface {
+	// CreateSession records a sign-in and prunes the user's expired sessions.
+	CreateSession(ctx context.Context, userID int64, deviceName string, userAgent string, ipAddress string, expiresAt time.Time) (*models.Session, error)
+	ListSessions(ctx context.Context, userID int64) ([]models.Session, error)
+	// TouchSession checks that the session is still live and records it as
+	// used, at most once per lastUsedResolution.
+	TouchSession(ctx context.Context, userID int64, id int64) error
+	ExtendSession(ctx context.Context, userID int64, id int64, expiresAt time.Time) error
+	DeleteSession(ctx context.Context, userID int64, id int64) error
+	// DeleteOtherSessions deletes every session of the user except keepID
+	// (all of them when keepID is 0) and returns how many were deleted.
+	DeleteOtherSessions(ctx context.Context, userID int64, keepID int64) (int, error)
+}
+
+type postgresSessionRepository struct {
+	db *sql.DB
+}
+
+func NewSessionRepository(db *sql.DB) SessionRepository {
+	return &postgresSessionRepository{db: db}
+}
+
+const sessionColumns = `id, user_id, device_name, user_agent, ip_address, created_at, last_used_at, expires_at`
+
+func scanSession(row rowScanner) (*models.Session, error) {
+	session := &models.Session{}
+	err := row.Scan(&session.ID, &session.UserID, &session.DeviceName, &session.UserAgent, &session.IPAddress, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+	return session, err
+}
+
+func (r *postgresSessionRepository) CreateSession(ctx context.Context, userID int64, deviceName string, userAgent string, ipAddress string, expiresAt time.Time) (*models.Session, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresSessionRepository.CreateSession", "INSERT", "sessions")
+	defer span.End()
+
+	if _, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1 AND expires_at <= CURRENT_TIMESTAMP`, userID); err != nil {
+		slog.WarnContext(ctx, "could not prune expired sessions", logger.UserID(userID), logger.Err(err))
+	}
+
+	query := `INSERT INTO sessions (user_id, device_name, user_agent, ip_address, expires_at)
+    VALUES ($1, $2, $3, $4, $5)
+    RETURNING ` + sessionColumns
+
+	session, err := scanSession(r.db.QueryRowContext(ctx, query, userID, deviceName, userAgent, ipAddress, expiresAt))
+	if err != nil {
+		slog.ErrorContext(ctx, "error inserting session", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrCreatingSession, err)
+	}
+
+	return session, nil
+}
+
+func (r *postgresSessionRepository) ListSessions(ctx context.Context, userID int64) ([]models.Session, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresSessionRepository.ListSessions", "SELECT", "sessions")
+	defer span.End()
+
+	query := `SELECT ` + sessionColumns + `
+    FROM sessions
+    WHERE user_id = $1 AND expires_at > CURRENT_TIMESTAMP
+    ORDER BY last_used_at DESC, id DESC`
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error listing sessions", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrRetrievingSessions, err)
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			tracing.RecordError(span, err)
+			return nil, fmt.Errorf("%w: %v", ErrRetrievingSessions, err)
+		}
+		sessions = append(sessions, *session)
+	}
+	if err := rows.Err(); err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrRetrievingSessions, err)
+	}
+
+	return sessions, nil
+}
+
+func (r *postgresSessionRepository) TouchSession(ctx context.Context, userID int64, id int64) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresSessionRepository.TouchSession", "SELECT", "sessions")
+	defer span.End()
+
+	query := `SELECT last_used_at
+    FROM sessions
+    WHERE id = $1 AND user_id = $2 AND expires_at > CURRENT_TIMESTAMP`
+
+	var lastUsedAt time.Time
+	if err := r.db.QueryRowContext(ctx, query, id, userID).Scan(&lastUsedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSessionNotFound
+		}
+		slog.ErrorContext(ctx, "error retrieving session", logger.UserID(userID), slog.Int64("session_id", id), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrRetrievingSessions, err)
+	}
+
+	if time.Since(lastUsedAt) >= lastUsedResolution {
+		if _, err := r.db.ExecContext(ctx, `UPDATE sessions SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1`, id); err != nil {
+			slog.WarnContext(ctx, "could not record session usage", logger.UserID(userID), slog.Int64("session_id", id), logger.Err(err))
+		}
+	}
+
+	return nil
+}
+
+func (r *postgresSessionRepository) ExtendSession(ctx context.Context, userID int64, id int64, expiresAt time.Time) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresSessionRepository.ExtendSession", "UPDATE", "sessions")
+	defer span.End()
+
+	query := `UPDATE sessions
+    SET expires_at = $3, last_used_at = CURRENT_TIMESTAMP
+    WHERE id = $1 AND user_id = $2`
+
+	result, err := r.db.ExecContext(ctx, query, id, userID, expiresAt)
+	if err != nil {
+		slog.ErrorContext(ctx, "error extending session", logger.UserID(userID), slog.Int64("session_id", id), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrUpdatingSession, err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("%w: %v", ErrUpdatingSession, err)
+	} else if rows == 0 {
+		return ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (r *postgresSessionRepository) DeleteSession(ctx context.Context, userID int64, id int64) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresSessionRepository.DeleteSession", "DELETE", "sessions")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error deleting session", logger.UserID(userID), slog.Int64("session_id", id), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrUpdatingSession, err)
+	}
+	if rows, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("%w: %v", ErrUpdatingSession, err)
+	} else if rows == 0 {
+		return ErrSessionNotFound
+	}
+
+	slog.InfoContext(ctx, "session revoked", logger.UserID(userID), slog.Int64("session_id", id))
+	return nil
+}
+
+func (r *postgresSessionRepository) DeleteOtherSessions(ctx context.Context, userID int64, keepID int64) (int, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresSessionRepository.DeleteOtherSessions", "DELETE", "sessions")
+	defer span.End()
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = $1 AND id <> $2`, userID, keepID)
+	if err != nil {
+		slog.ErrorContext(ctx, "error deleting sessions", logger.UserID(userID), logger.Err(err))
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("%w: %v", ErrUpdatingSession, err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrUpdatingSession, err)
+	}
+
+	slog.InfoContext(ctx, "other sessions revoked", logger.UserID(userID), slog.Int64("kept_session_id", keepID), slog.Int64("revoked", rows))
+	return int(rows), nil
+}
@@ -5,86 +5,284 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/sokolawesome/chat-server/internal/logger"
 	"github.com/sokolawesome/chat-server/internal/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/sokolawesome/chat-server/internal/password"
+	"github.com/sokolawesome/chat-server/internal/tracing"
+	"github.com/sokolawesome/chat-server/internal/username"
 )
 
 var (
 	ErrUserNotFound    = errors.New("user not found")
 	ErrUsernameTaken   = errors.New("username is already taken")
+	ErrEmailTaken      = errors.New("email is already in use")
 	ErrHashingPassword = errors.New("failed to hash password")
 	ErrCreatingUser    = errors.New("failed to create user in database")
 	ErrRetrievingUser  = errors.New("failed to retrieve user from database")
+	ErrUpdatingUser    = errors.New("failed to update user in database")
 )
 
 type UserRepository interface {
-	CreateUser(ctx context.Context, username string, password string) (*models.User, error)
+	CreateUser(ctx context.Context, username string, email string, password string) (*models.User, error)
 	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	// GetUserByEmail matches email case-insensitively.
+	GetUserByEmail(ctx context.Context, email string) (*models.User, error)
+	GetUserByID(ctx context.Context, id int64) (*models.User, error)
+	// MarkEmailVerified confirms email for the user, provided it is still the
+	// user's address; ErrUserNotFound means the user or address changed.
+	MarkEmailVerified(ctx context.Context, id int64, email string) error
+	// GetTokenVersion returns the counter embedded in issued JWTs; tokens
+	// carrying an older version have been revoked.
+	GetTokenVersion(ctx context.Context, id int64) (int, error)
+	// UpdatePassword stores a new password and bumps the token version,
+	// revoking every token issued before the change. It returns the new version.
+	UpdatePassword(ctx context.Context, id int64, password string) (int, error)
+	// RehashPassword re-encodes the same password with the current hasher
+	// settings. It leaves tokens alone and does nothing if the stored hash
+	// is no longer oldHash, e.g. because the password changed meanwhile.
+	RehashPassword(ctx context.Context, id int64, oldHash string, password string) error
 }
 
 type postgresUserRepository struct {
-	db         *sql.DB
-	bcryptCost int
+	db     *sql.DB
+	hasher password.Hasher
 }
 
-func NewUserRepository(db *sql.DB, bcryptCost int) UserRepository {
-	return &postgresUserRepository{db: db, bcryptCost: bcryptCost}
+func NewUserRepository(db *sql.DB, hasher password.Hasher) UserRepository {
+	return &postgresUserRepository{db: db, hasher: hasher}
 }
 
-func (r *postgresUserRepository) CreateUser(ctx context.Context, username string, password string) (*models.User, error) {
-	hashedPasswordBytes, err := bcrypt.GenerateFromPassword([]byte(password), r.bcryptCost)
+func (r *postgresUserRepository) hashPassword(password string) (string, error) {
+	hashedPassword, err := r.hasher.Hash(password)
 	if err != nil {
-		log.Printf("error hashing password for user %s: %v", username, err)
-		return nil, fmt.Errorf("%w: %v", ErrHashingPassword, err)
+		return "", fmt.Errorf("%w: %v", ErrHashingPassword, err)
 	}
+	return hashedPassword, nil
+}
+
+func (r *postgresUserRepository) CreateUser(ctx context.Context, name string, email string, password string) (*models.User, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresUserRepository.CreateUser", "INSERT", "users")
+	defer span.End()
 
-	hashedPassword := string(hashedPasswordBytes)
+	hashedPassword, err := r.hashPassword(password)
+	if err != nil {
+		slog.ErrorContext(ctx, "error hashing password", slog.String("username", name), logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, err
+	}
 
 	user := &models.User{
-		Username:       username,
+		Username:       username.Canonical(name),
+		Email:          email,
 		HashedPassword: hashedPassword,
 	}
 
-	query := `INSERT INTO users (username, hashed_password)
-    VALUES ($1, $2)
+	query := `INSERT INTO users (username, username_normalized, email, hashed_password)
+    VALUES ($1, $2, NULLIF($3, ''), $4)
     RETURNING id, created_at`
 
-	if err = r.db.QueryRowContext(ctx, query, username, hashedPassword).Scan(&user.ID, &user.CreatedAt); err != nil {
+	if err = r.db.QueryRowContext(ctx, query, user.Username, username.Normalize(name), email, hashedPassword).Scan(&user.ID, &user.CreatedAt); err != nil {
 		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "idx_users_email_lower" {
+			slog.InfoContext(ctx, "attempt to create user with existing email", slog.String("username", name))
+			tracing.RecordError(span, ErrEmailTaken)
+			return nil, ErrEmailTaken
+		}
 		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
-			log.Printf("attempt to create user with existing username '%s'", username)
+			slog.InfoContext(ctx, "attempt to create user with existing username", slog.String("username", name))
+			tracing.RecordError(span, ErrUsernameTaken)
 			return nil, ErrUsernameTaken
 		}
-		log.Printf("error inserting user '%s' into database: %v", username, err)
+		slog.ErrorContext(ctx, "error inserting user into database", slog.String("username", name), logger.Err(err))
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("%w: %v", ErrCreatingUser, err)
 	}
 
-	log.Printf("user created successfully with id: %d, username: %s", user.ID, user.Username)
+	slog.InfoContext(ctx, "user created successfully", logger.UserID(user.ID), slog.String("username", user.Username))
 	return user, nil
 }
 
-func (r *postgresUserRepository) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
-	user := &models.User{}
-	query := `SELECT id, username, hashed_password, created_at
+func (r *postgresUserRepository) GetUserByUsername(ctx context.Context, name string) (*models.User, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresUserRepository.GetUserByUsername", "SELECT", "users")
+	defer span.End()
+
+	query := `SELECT id, username, email, email_verified_at, hashed_password, token_version, created_at
     FROM users
-    WHERE username = $1`
+    WHERE username_normalized = $1`
 
-	if err := r.db.QueryRowContext(ctx, query, username).Scan(
-		&user.ID,
-		&user.Username,
-		&user.HashedPassword,
-		&user.CreatedAt,
-	); err != nil {
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, username.Normalize(name)))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			log.Printf("user not found by username '%s'", username)
+			slog.DebugContext(ctx, "user not found by username", slog.String("username", name))
 			return nil, ErrUserNotFound
 		}
-		log.Printf("error retrieving user by username '%s' from database: %v", username, err)
+		slog.ErrorContext(ctx, "error retrieving user by username from database", slog.String("username", name), logger.Err(err))
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("%w: %v", ErrRetrievingUser, err)
 	}
 
 	return user, nil
 }
+
+func (r *postgresUserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresUserRepository.GetUserByEmail", "SELECT", "users")
+	defer span.End()
+
+	query := `SELECT id, username, email, email_verified_at, hashed_password, token_version, created_at
+    FROM users
+    WHERE LOWER(email) = LOWER($1)`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, email))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			slog.DebugContext(ctx, "user not found by email")
+			return nil, ErrUserNotFound
+		}
+		slog.ErrorContext(ctx, "error retrieving user by email from database", logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrRetrievingUser, err)
+	}
+
+	return user, nil
+}
+
+func (r *postgresUserRepository) MarkEmailVerified(ctx context.Context, id int64, email string) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresUserRepository.MarkEmailVerified", "UPDATE", "users")
+	defer span.End()
+
+	query := `UPDATE users
+    SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP)
+    WHERE id = $1 AND LOWER(email) = LOWER($2)`
+
+	result, err := r.db.ExecContext(ctx, query, id, email)
+	if err != nil {
+		slog.ErrorContext(ctx, "error marking email verified", logger.UserID(id), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrUpdatingUser, err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrUserNotFound
+	}
+
+	slog.InfoContext(ctx, "email verified", logger.UserID(id))
+	return nil
+}
+
+func (r *postgresUserRepository) GetUserByID(ctx context.Context, id int64) (*models.User, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresUserRepository.GetUserByID", "SELECT", "users")
+	defer span.End()
+
+	query := `SELECT id, username, email, email_verified_at, hashed_password, token_version, created_at
+    FROM users
+    WHERE id = $1`
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			slog.DebugContext(ctx, "user not found by id", logger.UserID(id))
+			return nil, ErrUserNotFound
+		}
+		slog.ErrorContext(ctx, "error retrieving user by id from database", logger.UserID(id), logger.Err(err))
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("%w: %v", ErrRetrievingUser, err)
+	}
+
+	return user, nil
+}
+
+func (r *postgresUserRepository) GetTokenVersion(ctx context.Context, id int64) (int, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresUserRepository.GetTokenVersion", "SELECT", "users")
+	defer span.End()
+
+	var version int
+	if err := r.db.QueryRowContext(ctx, `SELECT token_version FROM users WHERE id = $1`, id).Scan(&version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+		slog.ErrorContext(ctx, "error retrieving token version from database", logger.UserID(id), logger.Err(err))
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("%w: %v", ErrRetrievingUser, err)
+	}
+
+	return version, nil
+}
+
+func (r *postgresUserRepository) UpdatePassword(ctx context.Context, id int64, password string) (int, error) {
+	ctx, span := tracing.StartQuery(ctx, "postgresUserRepository.UpdatePassword", "UPDATE", "users")
+	defer span.End()
+
+	hashedPassword, err := r.hashPassword(password)
+	if err != nil {
+		slog.ErrorContext(ctx, "error hashing password", logger.UserID(id), logger.Err(err))
+		tracing.RecordError(span, err)
+		return 0, err
+	}
+
+	query := `UPDATE users
+    SET hashed_password = $2, token_version = token_version + 1
+    WHERE id = $1
+    RETURNING token_version`
+
+	var version int
+	if err := r.db.QueryRowContext(ctx, query, id, hashedPassword).Scan(&version); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrUserNotFound
+		}
+		slog.ErrorContext(ctx, "error updating password in database", logger.UserID(id), logger.Err(err))
+		tracing.RecordError(span, err)
+		return 0, fmt.Errorf("%w: %v", ErrUpdatingUser, err)
+	}
+
+	slog.InfoContext(ctx, "password updated", logger.UserID(id))
+	return version, nil
+}
+
+func (r *postgresUserRepository) RehashPassword(ctx context.Context, id int64, oldHash string, password string) error {
+	ctx, span := tracing.StartQuery(ctx, "postgresUserRepository.RehashPassword", "UPDATE", "users")
+	defer span.End()
+
+	hashedPassword, err := r.hashPassword(password)
+	if err != nil {
+		slog.ErrorContext(ctx, "error hashing password", logger.UserID(id), logger.Err(err))
+		tracing.RecordError(span, err)
+		return err
+	}
+
+	query := `UPDATE users
+    SET hashed_password = $3
+    WHERE id = $1 AND hashed_password = $2`
+
+	if _, err := r.db.ExecContext(ctx, query, id, oldHash, hashedPassword); err != nil {
+		slog.ErrorContext(ctx, "error storing rehashed password", logger.UserID(id), logger.Err(err))
+		tracing.RecordError(span, err)
+		return fmt.Errorf("%w: %v", ErrUpdatingUser, err)
+	}
+
+	slog.InfoContext(ctx, "password hash upgraded", logger.UserID(id))
+	return nil
+}
+
+func scanUser(row *sql.Row) (*models.User, error) {
+	user := &models.User{}
+	var email sql.NullString
+	var emailVerifiedAt sql.NullTime
+	if err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&email,
+		&emailVerifiedAt,
+		&user.HashedPassword,
+		&user.TokenVersion,
+		&user.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+	user.Email = email.String
+	if emailVerifiedAt.Valid {
+		user.EmailVerifiedAt = &emailVerifiedAt.Time
+	}
+	return user, nil
+}
@@ -1,61 +1,138 @@
 package router
 
 import (
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
-	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/sokolawesome/chat-server/config"
+	"github.com/sokolawesome/chat-server/internal/apierror"
 	"github.com/sokolawesome/chat-server/internal/handlers"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/metrics"
 	"github.com/sokolawesome/chat-server/internal/middleware"
+	"github.com/sokolawesome/chat-server/internal/origin"
+	"github.com/sokolawesome/chat-server/internal/ratelimit"
+	"github.com/sokolawesome/chat-server/internal/repository"
+	"github.com/sokolawesome/chat-server/internal/requestid"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-func SetupRouter(cfg *config.Config, AuthHandler *handlers.AuthHandler) *gin.Engine {
-	router := gin.Default()
+func SetupRouter(cfg *config.Config, originMatcher *origin.Matcher, userRepository repository.UserRepository, apiKeyRepository repository.APIKeyRepository, sessionRepository repository.SessionRepository, AuthHandler *handlers.AuthHandler, PasswordResetHandler *handlers.PasswordResetHandler, EmailVerificationHandler *handlers.EmailVerificationHandler, MFAHandler *handlers.MFAHandler, APIKeyHandler *handlers.APIKeyHandler, SessionHandler *handlers.SessionHandler, ProfileHandler *handlers.ProfileHandler, OIDCHandler *handlers.OIDCHandler, WsHandler *handlers.WsHandler, HealthHandler *handlers.HealthHandler) (*gin.Engine, error) {
+	if cfg.AppEnv == "production" {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	apierror.UseJSONFieldNames()
+
+	router := gin.New()
+	// gin trusts every proxy by default; with none configured ClientIP is
+	// the peer address and forwarding headers are ignored.
+	if err := router.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	router.Use(gin.Recovery())
+	router.Use(otelgin.Middleware(cfg.TracingServiceName))
+	router.Use(metrics.Middleware())
+	router.Use(middleware.RequestID())
+	router.Use(middleware.AccessLog())
+	router.Use(middleware.ErrorHandler())
 
 	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Length", "Content-Type", "Authorization"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
+		AllowOriginFunc:  originMatcher.Allowed,
+		AllowMethods:     cfg.CorsAllowMethods,
+		AllowHeaders:     cfg.CorsAllowHeaders,
+		ExposeHeaders:    []string{requestid.HeaderKey},
+		AllowCredentials: cfg.CorsAllowCredentials,
+		MaxAge:           cfg.CorsMaxAge,
 	}))
 
+	// each route group gets its own limiter so a burst of logins does not
+	// eat into a user's API budget; rateLimit is a no-op when disabled.
+	// Routes sharing a budget must share the handler rateLimit returns.
+	rateLimit := func(rate ratelimit.Rate, scope string) gin.HandlerFunc {
+		if !cfg.RateLimitEnabled {
+			return func(ctx *gin.Context) { ctx.Next() }
+		}
+		return middleware.RateLimit(ratelimit.NewLimiter(rate), scope)
+	}
+
 	router.GET("/", func(ctx *gin.Context) {
 		ctx.JSON(http.StatusOK, gin.H{
 			"message": "Chat server is running!",
 		})
 	})
 
+	router.GET("/healthz", HealthHandler.Liveness)
+	router.GET("/readyz", HealthHandler.Readiness)
+	router.GET("/ws", rateLimit(cfg.RateLimitWsConnect, "ws_connect"), WsHandler.Handle)
+
+	apiRateLimit := rateLimit(cfg.RateLimitAPI, "api")
+
 	api := router.Group("/api")
 	{
 		auth := api.Group("/auth")
+		auth.Use(rateLimit(cfg.RateLimitAuth, "auth"))
 		{
 			auth.POST("/register", AuthHandler.Register)
 			auth.POST("/login", AuthHandler.Login)
+			auth.POST("/login/mfa", AuthHandler.LoginMFA)
+			auth.POST("/password/forgot", PasswordResetHandler.Forgot)
+			auth.POST("/password/reset", PasswordResetHandler.Reset)
+			auth.POST("/email/verify", EmailVerificationHandler.Verify)
+			if OIDCHandler != nil {
+				auth.GET("/oidc/login", OIDCHandler.Login)
+				auth.GET("/oidc/callback", OIDCHandler.Callback)
+			}
 		}
 
+		// avatars are public so their URLs work in plain <img> tags.
+		api.GET("/users/:id/avatar/:size", apiRateLimit, ProfileHandler.Avatar)
+
 		authorized := api.Group("/")
-		authorized.Use(middleware.AuthMiddleware(cfg.JwtSecret))
+		authorized.Use(middleware.AuthMiddleware(cfg.JwtSecret, userRepository, apiKeyRepository, sessionRepository), apiRateLimit)
 		{
+			// account management needs a real sign-in, never an API key.
+			account := authorized.Group("/me")
+			account.Use(middleware.RequireSession())
+			{
+				account.POST("/password", AuthHandler.ChangePassword)
+				account.POST("/email/verification", EmailVerificationHandler.Resend)
+				account.POST("/mfa/totp", MFAHandler.Enroll)
+				account.POST("/mfa/totp/confirm", MFAHandler.Confirm)
+				account.POST("/mfa/totp/disable", MFAHandler.Disable)
+				account.GET("/api-keys", APIKeyHandler.List)
+				account.POST("/api-keys", APIKeyHandler.Create)
+				account.DELETE("/api-keys/:id", APIKeyHandler.Revoke)
+				account.GET("/sessions", SessionHandler.List)
+				account.DELETE("/sessions", SessionHandler.RevokeOthers)
+				account.DELETE("/sessions/:id", SessionHandler.Revoke)
+			}
+
+			authorized.GET("/me/profile", ProfileHandler.GetOwn)
+			authorized.PATCH("/me/profile", ProfileHandler.Update)
+			authorized.PUT("/me/profile/avatar", ProfileHandler.UploadAvatar)
+			authorized.DELETE("/me/profile/avatar", ProfileHandler.DeleteAvatar)
+			authorized.GET("/users/:id", ProfileHandler.Get)
+
 			authorized.GET("/me", func(ctx *gin.Context) {
 				userIDAny, exist := ctx.Get(middleware.AuthorizationPayloadKey)
 				if !exist {
-					log.Println("userid not found in context")
-					ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Could not identify user"})
+					slog.ErrorContext(ctx.Request.Context(), "userid not found in context")
+					_ = ctx.Error(apierror.ErrInternal)
 					return
 				}
 
 				userID, ok := userIDAny.(int64)
 				if !ok {
-					log.Printf("userid in context is not int64 (%T)", userIDAny)
-					ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Could not identify user"})
+					slog.ErrorContext(ctx.Request.Context(), "userid in context is not int64", slog.String("type", fmt.Sprintf("%T", userIDAny)))
+					_ = ctx.Error(apierror.ErrInternal)
 					return
 				}
 
-				log.Printf("/me endpoint accessed by user %d", userID)
+				slog.DebugContext(ctx.Request.Context(), "/me endpoint accessed", logger.UserID(userID))
 				ctx.JSON(http.StatusOK, gin.H{
 					"message": "Authentication successful",
 					"user_id": userID,
@@ -64,5 +141,5 @@ func SetupRouter(cfg *config.Config, AuthHandler *handlers.AuthHandler) *gin.Eng
 		}
 	}
 
-	return router
+	return router, nil
 }
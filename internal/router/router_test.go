@@ -0,0 +1,116 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sokolawesome/chat-server/config"
+	"github.com/sokolawesome/chat-server/internal/handlers"
+	"github.com/sokolawesome/chat-server/internal/loginguard"
+	"github.com/sokolawesome/chat-server/internal/models"
+	"github.com/sokolawesome/chat-server/internal/origin"
+	"github.com/sokolawesome/chat-server/internal/ratelimit"
+	"github.com/sokolawesome/chat-server/internal/repository"
+)
+
+// noUsers answers every lookup with ErrUserNotFound, so each login is a
+// failed attempt on an unknown account.
+type noUsers struct {
+	repository.UserRepository
+}
+
+func (noUsers) GetUserByUsername(ctx context.Context, name string) (*models.User, error) {
+	return nil, repository.ErrUserNotFound
+}
+
+const loginMaxAttemptsPerIP = 3
+
+func newTestRouter(t *testing.T, cfg *config.Config) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	originMatcher, err := origin.NewMatcher(nil)
+	if err != nil {
+		t.Fatalf("NewMatcher: %v", err)
+	}
+	guard := loginguard.New(repository.NewInMemoryLoginAttemptRepository(time.Hour), loginguard.Options{
+		MaxAttemptsPerIP: loginMaxAttemptsPerIP,
+		LockoutDuration:  time.Minute,
+		Window:           time.Hour,
+	})
+	auth := handlers.NewAuthHandler(noUsers{}, nil, nil, nil, nil, nil, nil, guard, nil, false, cfg.JwtSecret, time.Hour, "chat-server")
+
+	router, err := SetupRouter(cfg, originMatcher, noUsers{}, nil, nil, auth, nil, nil, nil, nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatalf("SetupRouter: %v", err)
+	}
+	return router
+}
+
+// login sends a login for name from the peer 203.0.113.7, claiming to
+// forward forwardedFor.
+func login(router *gin.Engine, name string, forwardedFor string) int {
+	request := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username":"`+name+`","password":"guess"}`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Forwarded-For", forwardedFor)
+	request.RemoteAddr = "203.0.113.7:40000"
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestLoginThrottlingIgnoresSpoofedForwardedFor(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		wantLocked     bool
+	}{
+		// the client picks a fresh X-Forwarded-For for every guess, but the
+		// IP key stays the peer address.
+		{"no trusted proxies", nil, true},
+		// behind a trusted proxy the forwarded addresses are real clients.
+		{"peer is a trusted proxy", []string{"203.0.113.0/24"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t, &config.Config{JwtSecret: "jwt-secret", TrustedProxies: tt.trustedProxies})
+
+			for i := 0; i < loginMaxAttemptsPerIP; i++ {
+				if code := login(router, fmt.Sprintf("user%d", i), fmt.Sprintf("198.51.100.%d", i)); code != http.StatusUnauthorized {
+					t.Fatalf("guess %d returned %d, want %d", i+1, code, http.StatusUnauthorized)
+				}
+			}
+
+			code := login(router, "another", "198.51.100.99")
+			if locked := code == http.StatusTooManyRequests; locked != tt.wantLocked {
+				t.Fatalf("guess past the IP limit returned %d, want locked = %v", code, tt.wantLocked)
+			}
+		})
+	}
+}
+
+func TestRateLimitIgnoresSpoofedForwardedFor(t *testing.T) {
+	router := newTestRouter(t, &config.Config{
+		JwtSecret:        "jwt-secret",
+		RateLimitEnabled: true,
+		RateLimitAuth:    ratelimit.Rate{Requests: 2, Per: time.Minute},
+	})
+
+	// three requests stay within the login guard's IP limit, so a 429 here
+	// comes from the rate limiter.
+	for i := 0; i < 2; i++ {
+		if code := login(router, "alice", fmt.Sprintf("198.51.100.%d", i)); code == http.StatusTooManyRequests {
+			t.Fatalf("request %d was rate limited", i+1)
+		}
+	}
+	if code := login(router, "alice", "198.51.100.99"); code != http.StatusTooManyRequests {
+		t.Fatalf("request with a new X-Forwarded-For returned %d, want %d", code, http.StatusTooManyRequests)
+	}
+}
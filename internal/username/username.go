@@ -0,0 +1,189 @@
+package username
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	MinLength = 3
+	MaxLength = 20
+)
+
+// Confusable letters from other scripts and the Latin letter each one is
+// indistinguishable from in common fonts. Changing them changes stored
+// keys, so it needs a migration that recomputes username_normalized.
+const (
+	confusableFrom = "аеорсухіјѕһԁԛԝӏүαορνικυχ"
+	confusableTo   = "aeopcyxijshdqwlyaopvikux"
+)
+
+var skeleton = func() *strings.Replacer {
+	from, to := []rune(confusableFrom), []rune(confusableTo)
+	if len(from) != len(to) {
+		panic("username: confusable tables differ in length")
+	}
+	pairs := make([]string, 0, 2*len(from))
+	for i := range from {
+		pairs = append(pairs, string(from[i]), string(to[i]))
+	}
+	return strings.NewReplacer(pairs...)
+}()
+
+// Canonical returns the form a username is stored and displayed in: NFKC,
+// so full-width and other compatibility variants collapse to one spelling.
+func Canonical(name string) string {
+	return norm.NFKC.String(strings.TrimSpace(name))
+}
+
+// Normalize returns the key usernames are compared by: the canonical form,
+// case folded and with look-alike letters from other scripts mapped to their
+// Latin twins, so "Alice", "ALICE" and a Cyrillic "Аlice" are one name, as
+// are "Straße" and "STRASSE". Folding can leave text that is no longer in
+// NFKC, so it is normalized again before mapping look-alikes.
+func Normalize(name string) string {
+	// a Caser keeps state, so each call gets its own.
+	folded := cases.Fold().String(Canonical(name))
+	return skeleton.Replace(norm.NFKC.String(folded))
+}
+
+// Rules reported in Violation.Rule.
+const (
+	RuleLength      = "length"
+	RuleCharset     = "charset"
+	RuleEdges       = "edges"
+	RuleMixedScript = "mixed_script"
+	RuleReserved    = "reserved"
+)
+
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// defaultReserved are names that could pass for the service or its staff.
+var defaultReserved = []string{
+	"admin", "administrator", "root", "system", "sysadmin", "support",
+	"help", "helpdesk", "info", "security", "moderator", "mod", "staff",
+	"official", "owner", "team", "server", "bot", "api", "www", "mail",
+	"webmaster", "postmaster", "hostmaster", "abuse", "noreply", "no-reply",
+	"null", "undefined", "anonymous", "everyone", "here", "me", "you",
+	"chat", "guest", "user", "users",
+}
+
+// Policy decides which usernames can be registered. Existing accounts are
+// never re-validated.
+type Policy struct {
+	reserved map[string]struct{}
+}
+
+// NewPolicy reserves the built-in names plus extraReserved.
+func NewPolicy(extraReserved []string) *Policy {
+	reserved := make(map[string]struct{}, len(defaultReserved)+len(extraReserved))
+	for _, name := range slices.Concat(defaultReserved, extraReserved) {
+		if name = strings.TrimSpace(name); name != "" {
+			reserved[Normalize(name)] = struct{}{}
+		}
+	}
+	return &Policy{reserved: reserved}
+}
+
+// Check returns every rule name breaks, or nil if it can be registered.
+// Allowed are letters, digits and combining marks of a single script, plus
+// '_', '.' and '-' anywhere but at the start or end.
+func (p *Policy) Check(name string) []Violation {
+	name = Canonical(name)
+	var violations []Violation
+
+	if n := utf8.RuneCountInString(name); n < MinLength || n > MaxLength {
+		violations = append(violations, Violation{
+			Rule:    RuleLength,
+			Message: fmt.Sprintf("must be between %d and %d characters long", MinLength, MaxLength),
+		})
+	}
+
+	for _, r := range name {
+		if !allowed(r) {
+			violations = append(violations, Violation{
+				Rule:    RuleCharset,
+				Message: "may only contain letters, digits, '_', '.' and '-'",
+			})
+			break
+		}
+	}
+
+	if first, _ := utf8.DecodeRuneInString(name); isPunct(first) {
+		violations = append(violations, Violation{Rule: RuleEdges, Message: "must start and end with a letter or digit"})
+	} else if last, _ := utf8.DecodeLastRuneInString(name); isPunct(last) {
+		violations = append(violations, Violation{Rule: RuleEdges, Message: "must start and end with a letter or digit"})
+	}
+
+	if mixedScript(name) {
+		violations = append(violations, Violation{
+			Rule:    RuleMixedScript,
+			Message: "must not mix letters from different alphabets",
+		})
+	}
+
+	if _, reserved := p.reserved[Normalize(name)]; reserved {
+		violations = append(violations, Violation{Rule: RuleReserved, Message: "is reserved"})
+	}
+
+	return violations
+}
+
+func allowed(r rune) bool {
+	return unicode.IsLetter(r) || unicode.Is(unicode.Nd, r) || unicode.IsMark(r) || isPunct(r)
+}
+
+func isPunct(r rune) bool {
+	return r == '_' || r == '.' || r == '-'
+}
+
+// scriptGroups lets scripts that are legitimately written together count
+// as one, e.g. Japanese mixes Han, Hiragana and Katakana.
+var scriptGroups = map[string]string{
+	"Han":      "Japanese/Chinese",
+	"Hiragana": "Japanese/Chinese",
+	"Katakana": "Japanese/Chinese",
+}
+
+func mixedScript(name string) bool {
+	seen := ""
+	for _, r := range name {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		script := scriptOf(r)
+		if group, ok := scriptGroups[script]; ok {
+			script = group
+		}
+		if script == "" || script == "Common" || script == "Inherited" {
+			continue
+		}
+		if seen == "" {
+			seen = script
+		} else if script != seen {
+			return true
+		}
+	}
+	return false
+}
+
+func scriptOf(r rune) string {
+	if r < utf8.RuneSelf {
+		return "Latin"
+	}
+	for name, table := range unicode.Scripts {
+		if unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
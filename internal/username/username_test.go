@@ -0,0 +1,127 @@
+package username
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"alice", "alice"},
+		{"  Alice\t", "Alice"},
+		{"ａｌｉｃｅ", "alice"},          // full-width
+		{"ﬁona", "fiona"},           // ligature
+		{"Cafe\u0301", "Caf\u00e9"}, // combining accent composes
+		{"x²", "x2"},
+	}
+	for _, tt := range tests {
+		if got := Canonical(tt.name); got != tt.want {
+			t.Errorf("Canonical(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"case", "Alice", "aLICE", true},
+		{"full-width", "ａｌｉｃｅ", "alice", true},
+		{"cyrillic a", "аlice", "alice", true},
+		{"cyrillic capital a", "Аlice", "alice", true},
+		{"cyrillic o and e", "bоbе", "bobe", true},
+		{"greek omicron", "bοb", "bob", true},
+		{"greek nu", "νova", "vova", true},
+		{"sharp s folds", "Straße", "STRASSE", true},
+		{"final sigma folds", "ΟΔΟΣ", "οδος", true},
+		{"kelvin sign", "\u212Aate", "kate", true},
+		{"composed and decomposed", "Jos\u00e9", "Jose\u0301", true},
+		{"surrounding space", " alice ", "alice", true},
+		{"different names", "alice", "alicia", false},
+		{"accents matter", "jose", "josé", false},
+		{"digits are not letters", "b0b", "bob", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := Normalize(tt.a), Normalize(tt.b)
+			if (a == b) != tt.same {
+				t.Errorf("Normalize(%q) = %q, Normalize(%q) = %q, want same = %v", tt.a, a, tt.b, b, tt.same)
+			}
+		})
+	}
+}
+
+func TestNormalizeIsIdempotent(t *testing.T) {
+	for _, name := range []string{"Straße", "ＡＤＭＩＮ", "Аlice", "ΟΔΟΣ", "ǅemal", "ﬃ"} {
+		once := Normalize(name)
+		if twice := Normalize(once); twice != once {
+			t.Errorf("Normalize(Normalize(%q)) = %q, want %q", name, twice, once)
+		}
+	}
+}
+
+func TestConfusableTables(t *testing.T) {
+	from, to := []rune(confusableFrom), []rune(confusableTo)
+	if len(from) != len(to) {
+		t.Fatalf("confusable tables have %d and %d letters", len(from), len(to))
+	}
+	for i, r := range to {
+		if r < 'a' || r > 'z' {
+			t.Errorf("%q maps to %q, want a lowercase Latin letter", from[i], r)
+		}
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	policy := NewPolicy([]string{"acme", " ", "Chat-Ops"})
+
+	tests := []struct {
+		name  string
+		rules []string
+	}{
+		{"alice", nil},
+		{"bob_smith", nil},
+		{"j.doe-99", nil},
+		{"Ярослав", nil},
+		{"ΑλέξηςΠ", nil},
+		{"山田たろう", nil},
+		{"José", nil},
+		{"ab", []string{RuleLength}},
+		{"a234567890123456789012", []string{RuleLength}},
+		{"ｍｅ", []string{RuleLength, RuleReserved}},
+		{"bob smith", []string{RuleCharset}},
+		{"bob@home", []string{RuleCharset}},
+		{"bob\u200bsmith", []string{RuleCharset}},
+		{"_bob", []string{RuleEdges}},
+		{"bob.", []string{RuleEdges}},
+		{"pаypal", []string{RuleMixedScript}},
+		{"bobα", []string{RuleMixedScript}},
+		{"admin", []string{RuleReserved}},
+		{"ADMIN", []string{RuleReserved}},
+		{"аdmin", []string{RuleMixedScript, RuleReserved}},
+		{"ａｄｍｉｎ", []string{RuleReserved}},
+		{"acme", []string{RuleReserved}},
+		{"chat-ops", []string{RuleReserved}},
+		{"-admin-", []string{RuleEdges}},
+		{"x", []string{RuleLength}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules []string
+			for _, v := range policy.Check(tt.name) {
+				if v.Message == "" {
+					t.Errorf("rule %s has no message", v.Rule)
+				}
+				rules = append(rules, v.Rule)
+			}
+			if !slices.Equal(rules, tt.rules) {
+				t.Errorf("Check(%q) = %v, want %v", tt.name, rules, tt.rules)
+			}
+		})
+	}
+}
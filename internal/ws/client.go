@@ -0,0 +1,285 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sokolawesome/chat-server/internal/logger"
+	"github.com/sokolawesome/chat-server/internal/metrics"
+	"github.com/sokolawesome/chat-server/internal/ratelimit"
+	"github.com/sokolawesome/chat-server/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Options struct {
+	SendQueueSize int
+	Policy        Policy
+	WriteTimeout  time.Duration
+
+	// CompressionMinSize is the smallest payload that is deflated when
+	// permessage-deflate was negotiated; smaller frames are sent as-is since
+	// the CPU cost outweighs the few bytes saved.
+	CompressionMinSize int
+	CompressionLevel   int
+
+	// MessageLimiter, when set, caps inbound frames per user across all of
+	// the user's connections. A client exceeding it MaxRateLimitStrikes
+	// times in a row is disconnected.
+	MessageLimiter      *ratelimit.Limiter
+	MaxRateLimitStrikes int
+}
+
+type Client struct {
+	ConnID string
+	UserID int64
+	// SessionID is the sign-in session the connection was opened under, or 0
+	// for connections authenticated without one, such as API keys.
+	SessionID int64
+	// APIKeyID is the API key the connection was opened with, or 0.
+	APIKeyID int64
+
+	ctx        context.Context
+	conn       *websocket.Conn
+	log        *slog.Logger
+	codec      Codec
+	queue      *sendQueue
+	opts       Options
+	done       chan struct{}
+	writerDone chan struct{}
+	closeOnce  sync.Once
+
+	// strikes counts consecutive rate-limited frames; only readPump uses it.
+	strikes int
+}
+
+// NewClient wraps an upgraded connection. ctx is the upgrade request's
+// context; it is detached from the request's cancellation and tagged with
+// the connection ID so every log line and trace for the session can be
+// correlated.
+func NewClient(ctx context.Context, conn *websocket.Conn, connID string, userID int64, sessionID int64, apiKeyID int64, opts Options) *Client {
+	ctx = logger.WithAttrs(context.WithoutCancel(ctx), slog.String(logger.KeyConnID, connID))
+	clientLog := slog.Default().With(logger.UserID(userID), slog.String("remote_addr", conn.RemoteAddr().String()))
+	if err := conn.SetCompressionLevel(opts.CompressionLevel); err != nil {
+		clientLog.WarnContext(ctx, "invalid websocket compression level", slog.Int("level", opts.CompressionLevel), logger.Err(err))
+	}
+	return &Client{
+		ConnID:    connID,
+		UserID:    userID,
+		SessionID: sessionID,
+		APIKeyID:  apiKeyID,
+		ctx:       ctx,
+		conn:      conn,
+		log:       clientLog,
+		codec:     CodecFor(conn.Subprotocol()),
+		queue:     newSendQueue(opts.SendQueueSize, opts.Policy),
+		opts:      opts,
+		done:      make(chan struct{}),
+
+		writerDone: make(chan struct{}),
+	}
+}
+
+// Send enqueues msg for delivery without blocking the caller. A slow client
+// whose queue overflows is disconnected with CloseTryAgainLater.
+func (c *Client) Send(msg Outbound) error {
+	err := c.queue.push(msg)
+	if errors.Is(err, ErrQueueOverflow) {
+		metrics.WsOverflowDisconnects.Inc()
+		c.log.WarnContext(c.ctx, "outbound queue overflow, disconnecting slow client")
+		c.Close(websocket.CloseTryAgainLater, "client too slow")
+	}
+	return err
+}
+
+// SendFrame encodes frame with the client's negotiated codec and enqueues it.
+func (c *Client) SendFrame(ctx context.Context, frame *Frame) error {
+	_, span := tracing.Tracer().Start(ctx, "ws.send "+string(frame.Type), trace.WithAttributes(
+		attribute.Int64("user.id", c.UserID),
+	))
+	defer span.End()
+
+	data, err := c.codec.Encode(frame)
+	if err != nil {
+		c.log.ErrorContext(ctx, "failed to encode frame", slog.String("frame_type", string(frame.Type)), logger.Err(err))
+		tracing.RecordError(span, err)
+		return err
+	}
+	if err := c.Send(Outbound{MessageType: c.codec.MessageType(), Data: data, Droppable: frame.droppable()}); err != nil {
+		tracing.RecordError(span, err)
+		return err
+	}
+	return nil
+}
+
+// Shutdown queues a going_away frame carrying the reconnect hint behind any
+// frames already pending, then closes with CloseGoingAway once they are written.
+func (c *Client) Shutdown(retryAfter time.Duration) {
+	if err := c.SendFrame(c.ctx, &Frame{Type: FrameTypeGoingAway, RetryAfter: int(retryAfter.Seconds())}); err != nil {
+		c.Close(websocket.CloseGoingAway, "server shutting down")
+		return
+	}
+	c.queue.seal()
+}
+
+// Close sends a close frame with the given code and tears the connection down.
+// It is safe to call more than once and from any goroutine.
+func (c *Client) Close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.queue.close()
+		deadline := time.Now().Add(c.opts.WriteTimeout)
+		if err := c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline); err != nil && !errors.Is(err, websocket.ErrCloseSent) {
+			c.log.WarnContext(c.ctx, "error sending close frame", logger.Err(err))
+		}
+		if err := c.conn.Close(); err != nil {
+			c.log.WarnContext(c.ctx, "error closing websocket connection", logger.Err(err))
+		} else {
+			c.log.DebugContext(c.ctx, "websocket connection closed", slog.Int("close_code", code))
+		}
+	})
+}
+
+// Serve runs the write loop in the background and the read loop on the
+// calling goroutine, returning once the connection is closed and the writer
+// has stopped.
+func (c *Client) Serve() {
+	go c.writePump()
+	c.readPump()
+	c.Close(websocket.CloseNormalClosure, "")
+	<-c.writerDone
+}
+
+func (c *Client) readPump() {
+	for {
+		messageType, p, err := c.conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-c.done:
+			default:
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+					c.log.WarnContext(c.ctx, "websocket read error", logger.Err(err))
+				} else {
+					c.log.InfoContext(c.ctx, "websocket client disconnected")
+				}
+			}
+			return
+		}
+
+		metrics.WsMessagesReceived.Inc()
+
+		if c.rateLimited() {
+			continue
+		}
+
+		// a sealed queue means the server is draining; keep reading so the
+		// close handshake can complete once pending frames are flushed.
+		if err := c.handleFrame(messageType, p); err != nil && !errors.Is(err, ErrQueueClosed) {
+			return
+		}
+	}
+}
+
+// rateLimited reports whether an inbound frame exceeds the message rate and
+// must be discarded. The client is told with a rate_limited frame, or
+// disconnected with ClosePolicyViolation once it has ignored enough of them.
+func (c *Client) rateLimited() bool {
+	if c.opts.MessageLimiter == nil {
+		return false
+	}
+
+	allowed, retryAfter := c.opts.MessageLimiter.Allow(strconv.FormatInt(c.UserID, 10))
+	if allowed {
+		c.strikes = 0
+		return false
+	}
+
+	c.strikes++
+	metrics.RateLimited.WithLabelValues("ws").Inc()
+	if c.opts.MaxRateLimitStrikes > 0 && c.strikes >= c.opts.MaxRateLimitStrikes {
+		metrics.WsRateLimitDisconnects.Inc()
+		c.log.WarnContext(c.ctx, "disconnecting client for exceeding message rate limit", slog.Int("strikes", c.strikes))
+		c.Close(websocket.ClosePolicyViolation, "rate limit exceeded")
+		return true
+	}
+
+	c.log.DebugContext(c.ctx, "frame rate limited", slog.Int("strikes", c.strikes), slog.Duration("retry_after", retryAfter))
+	_ = c.SendFrame(c.ctx, &Frame{Type: FrameTypeRateLimited, RetryAfter: int(math.Ceil(retryAfter.Seconds()))})
+	return true
+}
+
+// handleFrame processes one inbound frame in its own trace, linked to the
+// upgrade request's span rather than nested under it.
+func (c *Client) handleFrame(messageType int, p []byte) error {
+	ctx, span := tracing.Tracer().Start(c.ctx, "ws.frame",
+		trace.WithNewRoot(),
+		trace.WithLinks(trace.LinkFromContext(c.ctx)),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.Int64("user.id", c.UserID),
+			attribute.String("ws.conn_id", c.ConnID),
+			attribute.String("ws.subprotocol", c.codec.Subprotocol()),
+			attribute.Int("ws.frame.size", len(p)),
+		),
+	)
+	defer span.End()
+
+	var frame Frame
+	if err := c.codec.Decode(p, &frame); err != nil {
+		c.log.WarnContext(ctx, "invalid frame", slog.String("subprotocol", c.codec.Subprotocol()), slog.Int("message_type", messageType), logger.Err(err))
+		tracing.RecordError(span, err)
+		return c.SendFrame(ctx, &Frame{Type: FrameTypeError, Error: "invalid frame"})
+	}
+	span.SetName("ws.frame " + string(frame.Type))
+	span.SetAttributes(attribute.String("ws.frame.type", string(frame.Type)))
+
+	if !frame.Type.clientSendable() {
+		c.log.WarnContext(ctx, "unsupported frame type", slog.String("frame_type", string(frame.Type)))
+		tracing.RecordError(span, ErrUnsupportedFrame)
+		return c.SendFrame(ctx, &Frame{Type: FrameTypeError, Error: "unsupported frame type"})
+	}
+
+	c.log.DebugContext(ctx, "received frame", slog.String("frame_type", string(frame.Type)), slog.Int("size", len(p)))
+
+	return c.SendFrame(ctx, &frame)
+}
+
+func (c *Client) writePump() {
+	defer close(c.writerDone)
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.queue.ready:
+		}
+
+		for {
+			msg, ok := c.queue.pop()
+			if !ok {
+				break
+			}
+			if err := c.conn.SetWriteDeadline(time.Now().Add(c.opts.WriteTimeout)); err != nil {
+				c.log.WarnContext(c.ctx, "failed to set write deadline", logger.Err(err))
+			}
+			c.conn.EnableWriteCompression(len(msg.Data) >= c.opts.CompressionMinSize)
+			if err := c.conn.WriteMessage(msg.MessageType, msg.Data); err != nil {
+				c.log.WarnContext(c.ctx, "failed to write message", logger.Err(err))
+				c.Close(websocket.CloseInternalServerErr, "")
+				return
+			}
+			metrics.WsMessagesSent.Inc()
+		}
+
+		if c.queue.drained() {
+			c.Close(websocket.CloseGoingAway, "server shutting down")
+			return
+		}
+	}
+}
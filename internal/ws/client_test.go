@@ -0,0 +1,37 @@
+package ws
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientRejectsServerFrameTypes(t *testing.T) {
+	_, peer, _ := serveTestClient(t, Options{SendQueueSize: 8, Policy: PolicyDisconnect, WriteTimeout: time.Second})
+
+	tests := []struct {
+		sent Frame
+		want Frame
+	}{
+		{Frame{Type: FrameTypeMessage, Content: "hi"}, Frame{Type: FrameTypeMessage, Content: "hi"}},
+		{Frame{Type: FrameTypeTyping}, Frame{Type: FrameTypeTyping}},
+		{Frame{Type: FrameTypeProfileChanged, Content: "forged"}, Frame{Type: FrameTypeError, Error: "unsupported frame type"}},
+		{Frame{Type: FrameTypeGoingAway, RetryAfter: 1}, Frame{Type: FrameTypeError, Error: "unsupported frame type"}},
+		{Frame{Type: FrameTypeRateLimited, RetryAfter: 60}, Frame{Type: FrameTypeError, Error: "unsupported frame type"}},
+		{Frame{Type: FrameTypeError, Error: "forged"}, Frame{Type: FrameTypeError, Error: "unsupported frame type"}},
+		{Frame{Type: "subscribe"}, Frame{Type: FrameTypeError, Error: "unsupported frame type"}},
+		{Frame{}, Frame{Type: FrameTypeError, Error: "unsupported frame type"}},
+	}
+	for _, tt := range tests {
+		if err := peer.WriteJSON(tt.sent); err != nil {
+			t.Fatalf("WriteJSON: %v", err)
+		}
+		_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+		var got Frame
+		if err := peer.ReadJSON(&got); err != nil {
+			t.Fatalf("reading the reply to %q: %v", tt.sent.Type, err)
+		}
+		if got != tt.want {
+			t.Errorf("sending %+v got %+v, want %+v", tt.sent, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package ws
+
+import (
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	SubprotocolJSON    = "chat.v1.json"
+	SubprotocolMsgpack = "chat.v1.msgpack"
+)
+
+// Codec converts frames to and from the wire format of a negotiated subprotocol.
+type Codec interface {
+	Subprotocol() string
+	MessageType() int
+	Encode(frame *Frame) ([]byte, error)
+	Decode(data []byte, frame *Frame) error
+}
+
+var codecs = map[string]Codec{
+	SubprotocolJSON:    jsonCodec{},
+	SubprotocolMsgpack: msgpackCodec{},
+}
+
+// Subprotocols lists the supported subprotocols in order of server preference,
+// suitable for websocket.Upgrader.Subprotocols.
+func Subprotocols() []string {
+	return []string{SubprotocolMsgpack, SubprotocolJSON}
+}
+
+// CodecFor returns the codec for a negotiated subprotocol. Clients that did not
+// request one get JSON, which matches the protocol spoken before negotiation existed.
+func CodecFor(subprotocol string) Codec {
+	if codec, ok := codecs[subprotocol]; ok {
+		return codec
+	}
+	return jsonCodec{}
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string { return SubprotocolJSON }
+
+func (jsonCodec) MessageType() int { return websocket.TextMessage }
+
+func (jsonCodec) Encode(frame *Frame) ([]byte, error) {
+	return json.Marshal(frame)
+}
+
+func (jsonCodec) Decode(data []byte, frame *Frame) error {
+	return json.Unmarshal(data, frame)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Subprotocol() string { return SubprotocolMsgpack }
+
+func (msgpackCodec) MessageType() int { return websocket.BinaryMessage }
+
+func (msgpackCodec) Encode(frame *Frame) ([]byte, error) {
+	return msgpack.Marshal(frame)
+}
+
+func (msgpackCodec) Decode(data []byte, frame *Frame) error {
+	return msgpack.Unmarshal(data, frame)
+}
@@ -0,0 +1,171 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sokolawesome/chat-server/internal/models"
+)
+
+func testFrames() map[string]*Frame {
+	created := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return map[string]*Frame{
+		"message":      {Type: FrameTypeMessage, Content: "hello, 世界"},
+		"empty":        {Type: FrameTypeMessage},
+		"typing":       {Type: FrameTypeTyping},
+		"error":        {Type: FrameTypeError, Error: "invalid frame"},
+		"rate_limited": {Type: FrameTypeRateLimited, RetryAfter: 3},
+		"going_away":   {Type: FrameTypeGoingAway, RetryAfter: 10},
+		"profile_changed": {Type: FrameTypeProfileChanged, Profile: &models.Profile{
+			UserID:      42,
+			Username:    "alice",
+			DisplayName: "Alice",
+			Bio:         "line one\nline two",
+			Pronouns:    "she/her",
+			Timezone:    "Europe/Berlin",
+			Avatars:     map[string]string{"64": "/api/users/42/avatar/64?v=1"},
+			CreatedAt:   created,
+			UpdatedAt:   created.Add(time.Hour),
+		}},
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{jsonCodec{}, msgpackCodec{}} {
+		for name, frame := range testFrames() {
+			t.Run(codec.Subprotocol()+"/"+name, func(t *testing.T) {
+				data, err := codec.Encode(frame)
+				if err != nil {
+					t.Fatalf("Encode: %v", err)
+				}
+
+				var decoded Frame
+				if err := codec.Decode(data, &decoded); err != nil {
+					t.Fatalf("Decode: %v", err)
+				}
+				assertFrameEqual(t, frame, &decoded)
+			})
+		}
+	}
+}
+
+func TestCodecDecodeRejectsGarbage(t *testing.T) {
+	for _, codec := range []Codec{jsonCodec{}, msgpackCodec{}} {
+		var frame Frame
+		if err := codec.Decode([]byte{0xc1, '{', 0xff}, &frame); err == nil {
+			t.Errorf("%s: Decode of garbage succeeded", codec.Subprotocol())
+		}
+	}
+}
+
+func TestCodecFor(t *testing.T) {
+	tests := []struct {
+		subprotocol string
+		want        string
+		messageType int
+	}{
+		{SubprotocolJSON, SubprotocolJSON, websocket.TextMessage},
+		{SubprotocolMsgpack, SubprotocolMsgpack, websocket.BinaryMessage},
+		{"", SubprotocolJSON, websocket.TextMessage},
+		{"chat.v2.protobuf", SubprotocolJSON, websocket.TextMessage},
+	}
+	for _, tt := range tests {
+		codec := CodecFor(tt.subprotocol)
+		if codec.Subprotocol() != tt.want || codec.MessageType() != tt.messageType {
+			t.Errorf("CodecFor(%q) = %s/%d, want %s/%d", tt.subprotocol, codec.Subprotocol(), codec.MessageType(), tt.want, tt.messageType)
+		}
+	}
+}
+
+// TestSubprotocolNegotiation upgrades real connections the way /ws does and
+// checks that the server answers in the codec the client negotiated.
+func TestSubprotocolNegotiation(t *testing.T) {
+	upgrader := websocket.Upgrader{Subprotocols: Subprotocols()}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClient(context.Background(), conn, "conn", 1, 0, 0, Options{SendQueueSize: 8, Policy: PolicyDisconnect, WriteTimeout: time.Second})
+		client.Serve()
+	}))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	tests := []struct {
+		name        string
+		offered     []string
+		negotiated  string
+		messageType int
+	}{
+		{"msgpack", []string{SubprotocolMsgpack}, SubprotocolMsgpack, websocket.BinaryMessage},
+		{"json", []string{SubprotocolJSON}, SubprotocolJSON, websocket.TextMessage},
+		{"server preference wins", []string{SubprotocolJSON, SubprotocolMsgpack}, SubprotocolMsgpack, websocket.BinaryMessage},
+		{"none offered", nil, "", websocket.TextMessage},
+		{"unknown offered", []string{"chat.v2.protobuf"}, "", websocket.TextMessage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := websocket.Dialer{Subprotocols: tt.offered}
+			conn, _, err := dialer.Dial(url, nil)
+			if err != nil {
+				t.Fatalf("Dial: %v", err)
+			}
+			defer conn.Close()
+
+			if conn.Subprotocol() != tt.negotiated {
+				t.Fatalf("negotiated %q, want %q", conn.Subprotocol(), tt.negotiated)
+			}
+
+			codec := CodecFor(conn.Subprotocol())
+			sent := &Frame{Type: FrameTypeMessage, Content: "ping"}
+			data, err := codec.Encode(sent)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if err := conn.WriteMessage(codec.MessageType(), data); err != nil {
+				t.Fatalf("WriteMessage: %v", err)
+			}
+
+			_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			messageType, reply, err := conn.ReadMessage()
+			if err != nil {
+				t.Fatalf("ReadMessage: %v", err)
+			}
+			if messageType != tt.messageType {
+				t.Errorf("reply message type = %d, want %d", messageType, tt.messageType)
+			}
+			var received Frame
+			if err := codec.Decode(reply, &received); err != nil {
+				t.Fatalf("Decode reply: %v", err)
+			}
+			assertFrameEqual(t, sent, &received)
+		})
+	}
+}
+
+func assertFrameEqual(t *testing.T, want *Frame, got *Frame) {
+	t.Helper()
+
+	// codecs may hand back times in another location; compare instants.
+	if want.Profile != nil && got.Profile != nil {
+		if !want.Profile.CreatedAt.Equal(got.Profile.CreatedAt) || !want.Profile.UpdatedAt.Equal(got.Profile.UpdatedAt) {
+			t.Fatalf("profile times = %v/%v, want %v/%v", got.Profile.CreatedAt, got.Profile.UpdatedAt, want.Profile.CreatedAt, want.Profile.UpdatedAt)
+		}
+		wantProfile, gotProfile := *want.Profile, *got.Profile
+		wantProfile.CreatedAt, wantProfile.UpdatedAt = time.Time{}, time.Time{}
+		gotProfile.CreatedAt, gotProfile.UpdatedAt = time.Time{}, time.Time{}
+		wantCopy, gotCopy := *want, *got
+		wantCopy.Profile, gotCopy.Profile = &wantProfile, &gotProfile
+		want, got = &wantCopy, &gotCopy
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("decoded frame = %+v, want %+v", got, want)
+	}
+}
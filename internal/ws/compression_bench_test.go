@@ -0,0 +1,161 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// countingConn counts the bytes the server writes to the socket, i.e. what
+// actually goes over the wire after permessage-deflate.
+type countingConn struct {
+	net.Conn
+	written *atomic.Int64
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	return n, err
+}
+
+type countingListener struct {
+	net.Listener
+	written atomic.Int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, written: &l.written}, nil
+}
+
+func smallFramePayload() string {
+	return "hey, are we still on for lunch at noon?"
+}
+
+// historyReplayPayload mimics a client catching up on the last 100 messages
+// of a conversation in one frame.
+func historyReplayPayload() string {
+	type message struct {
+		ID      int       `json:"id"`
+		From    string    `json:"from"`
+		Content string    `json:"content"`
+		SentAt  time.Time `json:"sent_at"`
+	}
+	base := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	messages := make([]message, 100)
+	for i := range messages {
+		messages[i] = message{
+			ID:      1000 + i,
+			From:    []string{"alice", "bob", "carol"}[i%3],
+			Content: fmt.Sprintf("message number %d in the conversation about the release plan", i),
+			SentAt:  base.Add(time.Duration(i) * time.Minute),
+		}
+	}
+	data, err := json.Marshal(messages)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+// BenchmarkCompression writes representative frames through a real
+// connection at each compression level, with the payload both above and
+// below CompressionMinSize. Compare ns/op (CPU) against wire_bytes/op.
+func BenchmarkCompression(b *testing.B) {
+	payloads := []struct {
+		name    string
+		content string
+	}{
+		{"small", smallFramePayload()},
+		{"history", historyReplayPayload()},
+	}
+	levels := []int{1, 6, 9}
+
+	for _, payload := range payloads {
+		frame := &Frame{Type: FrameTypeMessage, Content: payload.content}
+		encoded, err := jsonCodec{}.Encode(frame)
+		if err != nil {
+			b.Fatalf("Encode: %v", err)
+		}
+		size := len(encoded)
+
+		// below the minimum size compression is skipped, whatever the level.
+		b.Run(fmt.Sprintf("%s/uncompressed", payload.name), func(b *testing.B) {
+			benchmarkCompression(b, frame, 6, size+1)
+		})
+		for _, level := range levels {
+			b.Run(fmt.Sprintf("%s/level=%d", payload.name, level), func(b *testing.B) {
+				benchmarkCompression(b, frame, level, 0)
+			})
+		}
+	}
+}
+
+func benchmarkCompression(b *testing.B, frame *Frame, level int, minSize int) {
+	upgrader := websocket.Upgrader{EnableCompression: true, Subprotocols: Subprotocols()}
+	clients := make(chan *Client, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClient(context.Background(), conn, "bench", 1, 0, 0, Options{
+			SendQueueSize:      16,
+			Policy:             PolicyDisconnect,
+			WriteTimeout:       5 * time.Second,
+			CompressionMinSize: minSize,
+			CompressionLevel:   level,
+		})
+		clients <- client
+		client.Serve()
+	}))
+	listener := &countingListener{Listener: server.Listener}
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	dialer := websocket.Dialer{EnableCompression: true, Subprotocols: []string{SubprotocolJSON}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		b.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+	client := <-clients
+	defer client.Close(websocket.CloseNormalClosure, "")
+
+	data, err := client.codec.Encode(frame)
+	if err != nil {
+		b.Fatalf("Encode: %v", err)
+	}
+
+	listener.written.Store(0)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := client.SendFrame(context.Background(), frame); err != nil {
+			b.Fatalf("SendFrame: %v", err)
+		}
+		if _, _, err := conn.ReadMessage(); err != nil {
+			b.Fatalf("ReadMessage: %v", err)
+		}
+	}
+	b.StopTimer()
+
+	wire := float64(listener.written.Load()) / float64(b.N)
+	b.ReportMetric(float64(len(data)), "payload_bytes/op")
+	b.ReportMetric(wire, "wire_bytes/op")
+	b.ReportMetric(100*(1-wire/float64(len(data))), "saved_%")
+}
@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"errors"
+
+	"github.com/sokolawesome/chat-server/internal/models"
+)
+
+// ErrUnsupportedFrame is recorded when a client sends a frame type reserved
+// for the server.
+var ErrUnsupportedFrame = errors.New("frame type cannot be sent by clients")
+
+type FrameType string
+
+const (
+	FrameTypeMessage FrameType = "message"
+	FrameTypeTyping  FrameType = "typing"
+	FrameTypeError   FrameType = "error"
+
+	// FrameTypeRateLimited tells the client its frame was discarded for
+	// exceeding the message rate; RetryAfter says when to send again.
+	FrameTypeRateLimited FrameType = "rate_limited"
+
+	// FrameTypeGoingAway is sent right before the server closes the
+	// connection for a restart; RetryAfter tells the client when to reconnect.
+	FrameTypeGoingAway FrameType = "going_away"
+
+	// FrameTypeProfileChanged carries the new public profile of a user
+	// after they edit it or change their avatar.
+	FrameTypeProfileChanged FrameType = "profile_changed"
+)
+
+// Frame is the envelope for every message exchanged over /ws. Fields carry
+// both json and msgpack tags so each codec produces the same shape.
+type Frame struct {
+	Type    FrameType `json:"type" msgpack:"type"`
+	Content string    `json:"content,omitempty" msgpack:"content,omitempty"`
+	Error   string    `json:"error,omitempty" msgpack:"error,omitempty"`
+
+	RetryAfter int `json:"retry_after,omitempty" msgpack:"retry_after,omitempty"`
+
+	Profile *models.Profile `json:"profile,omitempty" msgpack:"profile,omitempty"`
+}
+
+// clientSendable reports whether clients may send frames of type t. The
+// rest are server events, and echoing one back would let a client forge
+// them for itself.
+func (t FrameType) clientSendable() bool {
+	return t == FrameTypeMessage || t == FrameTypeTyping
+}
+
+// droppable reports whether the frame is a transient event that may be
+// discarded when the client cannot keep up.
+func (f *Frame) droppable() bool {
+	return f.Type == FrameTypeTyping
+}
@@ -0,0 +1,185 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sokolawesome/chat-server/internal/metrics"
+	"github.com/sokolawesome/chat-server/internal/models"
+)
+
+// Hub tracks every live client so the server can reach all of them at once,
+// e.g. to drain connections on shutdown.
+type Hub struct {
+	mu       sync.Mutex
+	clients  map[*Client]struct{}
+	draining bool
+	wg       sync.WaitGroup
+}
+
+func NewHub() *Hub {
+	return &Hub{clients: make(map[*Client]struct{})}
+}
+
+// Register adds c to the hub. It returns false once the hub is draining, in
+// which case the caller should turn the client away.
+func (h *Hub) Register(c *Client) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.draining {
+		return false
+	}
+	h.clients[c] = struct{}{}
+	h.wg.Add(1)
+	metrics.WsConnections.Inc()
+	return true
+}
+
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		h.wg.Done()
+		metrics.WsConnections.Dec()
+	}
+}
+
+// DisconnectSession closes the user's connections opened under sessionID
+// and returns how many were closed.
+func (h *Hub) DisconnectSession(userID int64, sessionID int64) int {
+	return h.disconnect("session revoked", func(c *Client) bool {
+		return c.UserID == userID && c.SessionID == sessionID
+	})
+}
+
+// DisconnectOtherSessions closes every session-bound connection of the user
+// except those of keepSessionID. Connections without a session are left
+// alone, since no session was revoked for them.
+func (h *Hub) DisconnectOtherSessions(userID int64, keepSessionID int64) int {
+	return h.disconnect("session revoked", func(c *Client) bool {
+		return c.UserID == userID && c.SessionID != 0 && c.SessionID != keepSessionID
+	})
+}
+
+// DisconnectAPIKey closes every connection opened with the API key and
+// returns how many were closed.
+func (h *Hub) DisconnectAPIKey(userID int64, apiKeyID int64) int {
+	return h.disconnect("api key revoked", func(c *Client) bool {
+		return c.UserID == userID && c.APIKeyID == apiKeyID
+	})
+}
+
+func (h *Hub) disconnect(reason string, match func(c *Client) bool) int {
+	h.mu.Lock()
+	matched := make([]*Client, 0)
+	for c := range h.clients {
+		if match(c) {
+			matched = append(matched, c)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, c := range matched {
+		c.log.InfoContext(c.ctx, "closing websocket connection of revoked credential", slog.String("reason", reason), slog.Int64("session_id", c.SessionID), slog.Int64("api_key_id", c.APIKeyID))
+		c.Close(websocket.ClosePolicyViolation, reason)
+	}
+	return len(matched)
+}
+
+// SendProfile sends profile as a profile_changed frame to its owner's
+// connections and returns how many accepted it. Other users are not told:
+// there is no notion of who shares a room or a contact list with the owner
+// yet, and pushing every change to every connection would leak profiles.
+func (h *Hub) SendProfile(ctx context.Context, profile *models.Profile) int {
+	return h.broadcast(ctx, &Frame{Type: FrameTypeProfileChanged, Profile: profile}, func(c *Client) bool {
+		return c.UserID == profile.UserID
+	})
+}
+
+// broadcast queues frame for every client that matches and returns how many
+// accepted it. Clients whose queue is full are handled by their policy.
+func (h *Hub) broadcast(ctx context.Context, frame *Frame, match func(c *Client) bool) int {
+	h.mu.Lock()
+	clients := make([]*Client, 0)
+	for c := range h.clients {
+		if match(c) {
+			clients = append(clients, c)
+		}
+	}
+	h.mu.Unlock()
+
+	delivered := 0
+	for _, c := range clients {
+		if err := c.SendFrame(ctx, frame); err == nil {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// QueueDepth returns the number of frames waiting across all client queues.
+func (h *Hub) QueueDepth() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	depth := 0
+	for c := range h.clients {
+		depth += c.queue.len()
+	}
+	return depth
+}
+
+func (h *Hub) Draining() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.draining
+}
+
+// Shutdown stops accepting clients, asks every connected client to go away
+// with a reconnect hint and waits for their pending writes to flush. Clients
+// still connected when ctx expires are closed immediately.
+func (h *Hub) Shutdown(ctx context.Context, retryAfter time.Duration) error {
+	h.mu.Lock()
+	h.draining = true
+	clients := make([]*Client, 0, len(h.clients))
+	for c := range h.clients {
+		clients = append(clients, c)
+	}
+	h.mu.Unlock()
+
+	slog.Info("draining websocket connections", slog.Int("connections", len(clients)))
+	for _, c := range clients {
+		c.Shutdown(retryAfter)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		slog.Info("all websocket connections drained")
+		return nil
+	case <-ctx.Done():
+		h.mu.Lock()
+		remaining := make([]*Client, 0, len(h.clients))
+		for c := range h.clients {
+			remaining = append(remaining, c)
+		}
+		h.mu.Unlock()
+
+		slog.Warn("drain timeout reached, force closing websocket connections", slog.Int("connections", len(remaining)))
+		for _, c := range remaining {
+			c.Close(websocket.CloseGoingAway, "server shutting down")
+		}
+		return ctx.Err()
+	}
+}
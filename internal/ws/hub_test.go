@@ -0,0 +1,67 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/sokolawesome/chat-server/internal/models"
+)
+
+// queuedClient is a client without a connection; frames sent to it stay in
+// its queue for inspection.
+func queuedClient(userID int64) *Client {
+	return &Client{
+		UserID: userID,
+		ctx:    context.Background(),
+		log:    slog.Default(),
+		codec:  jsonCodec{},
+		queue:  newSendQueue(4, PolicyDisconnect),
+		done:   make(chan struct{}),
+	}
+}
+
+func TestSendProfile(t *testing.T) {
+	owner := queuedClient(1)
+	ownerOtherDevice := queuedClient(1)
+	otherUser := queuedClient(2)
+
+	hub := NewHub()
+	for _, c := range []*Client{owner, ownerOtherDevice, otherUser} {
+		hub.Register(c)
+		defer hub.Unregister(c)
+	}
+
+	delivered := hub.SendProfile(context.Background(), &models.Profile{UserID: 1, DisplayName: "Alice"})
+	if delivered != 2 {
+		t.Fatalf("SendProfile delivered to %d clients, want 2", delivered)
+	}
+
+	tests := []struct {
+		name   string
+		client *Client
+		want   bool
+	}{
+		{"owner", owner, true},
+		{"owner's other connection", ownerOtherDevice, true},
+		{"another user", otherUser, false},
+	}
+	for _, tt := range tests {
+		msg, ok := tt.client.queue.pop()
+		if ok != tt.want {
+			t.Errorf("%s received a frame = %v, want %v", tt.name, ok, tt.want)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		var frame Frame
+		if err := json.Unmarshal(msg.Data, &frame); err != nil {
+			t.Fatalf("%s: decoding %s: %v", tt.name, msg.Data, err)
+		}
+		if frame.Type != FrameTypeProfileChanged || frame.Profile == nil || frame.Profile.UserID != 1 {
+			t.Errorf("%s received %s, want the owner's profile_changed", tt.name, msg.Data)
+		}
+	}
+}
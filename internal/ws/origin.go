@@ -0,0 +1,24 @@
+package ws
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/sokolawesome/chat-server/internal/metrics"
+	"github.com/sokolawesome/chat-server/internal/origin"
+)
+
+// CheckOrigin returns a websocket.Upgrader.CheckOrigin func backed by the
+// shared origin allow-list. Requests without an Origin header come from
+// non-browser clients and are not subject to cross-site hijacking, so they pass.
+func CheckOrigin(matcher *origin.Matcher) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		requestOrigin := r.Header.Get("Origin")
+		if requestOrigin == "" || matcher.Allowed(requestOrigin) {
+			return true
+		}
+		metrics.WsRejectedOrigins.Inc()
+		slog.WarnContext(r.Context(), "rejected websocket upgrade: origin not allowed", slog.String("origin", requestOrigin), slog.String("remote_addr", r.RemoteAddr))
+		return false
+	}
+}
@@ -0,0 +1,35 @@
+package ws
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sokolawesome/chat-server/internal/origin"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	matcher, err := origin.NewMatcher([]string{"https://*.example.com"})
+	if err != nil {
+		t.Fatalf("NewMatcher: %v", err)
+	}
+	check := CheckOrigin(matcher)
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true}, // non-browser client
+		{"https://chat.example.com", true},
+		{"https://evil-example.com", false},
+		{"null", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := check(r); got != tt.want {
+			t.Errorf("CheckOrigin with Origin %q = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package ws
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/sokolawesome/chat-server/internal/metrics"
+)
+
+type Policy string
+
+const (
+	PolicyDropOldest Policy = "drop_oldest"
+	PolicyDisconnect Policy = "disconnect"
+)
+
+var (
+	ErrQueueOverflow = errors.New("outbound queue overflow")
+	ErrQueueClosed   = errors.New("outbound queue closed")
+)
+
+type Outbound struct {
+	MessageType int
+	Data        []byte
+	Droppable   bool
+}
+
+// sendQueue is a bounded FIFO of outbound frames for a single connection.
+// The writer goroutine waits on ready and drains it with pop.
+type sendQueue struct {
+	mu     sync.Mutex
+	items  []Outbound
+	limit  int
+	policy Policy
+	closed bool
+	sealed bool
+	ready  chan struct{}
+}
+
+func newSendQueue(limit int, policy Policy) *sendQueue {
+	return &sendQueue{
+		items:  make([]Outbound, 0, limit),
+		limit:  limit,
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+	}
+}
+
+// push enqueues msg. When the queue is full under PolicyDropOldest the oldest
+// droppable frame is evicted to make room; if nothing can be evicted a
+// droppable msg is discarded and a critical one overflows. Under
+// PolicyDisconnect any overflow is reported so the caller can close the conn.
+func (q *sendQueue) push(msg Outbound) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.closed || q.sealed {
+		return ErrQueueClosed
+	}
+
+	if len(q.items) >= q.limit {
+		if q.policy != PolicyDropOldest {
+			return ErrQueueOverflow
+		}
+		if !q.dropOldestLocked() {
+			if msg.Droppable {
+				metrics.WsDroppedEvents.Inc()
+				return nil
+			}
+			return ErrQueueOverflow
+		}
+		metrics.WsDroppedEvents.Inc()
+	}
+
+	q.items = append(q.items, msg)
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (q *sendQueue) dropOldestLocked() bool {
+	for i, item := range q.items {
+		if item.Droppable {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// pop removes and returns the oldest frame, reporting false when the queue is empty.
+func (q *sendQueue) pop() (Outbound, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.items) == 0 {
+		return Outbound{}, false
+	}
+	msg := q.items[0]
+	q.items[0] = Outbound{}
+	q.items = q.items[1:]
+	return msg, true
+}
+
+func (q *sendQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+// seal refuses further pushes while letting the writer drain what is queued.
+func (q *sendQueue) seal() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.sealed = true
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// drained reports whether the queue is sealed and has nothing left to write.
+func (q *sendQueue) drained() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.sealed && len(q.items) == 0
+}
+
+func (q *sendQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.items = nil
+}
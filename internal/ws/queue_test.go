@@ -0,0 +1,269 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func outbound(data string, droppable bool) Outbound {
+	return Outbound{MessageType: websocket.TextMessage, Data: []byte(data), Droppable: droppable}
+}
+
+func drain(q *sendQueue) []string {
+	var got []string
+	for {
+		msg, ok := q.pop()
+		if !ok {
+			return got
+		}
+		got = append(got, string(msg.Data))
+	}
+}
+
+func TestSendQueueIsFIFO(t *testing.T) {
+	q := newSendQueue(8, PolicyDisconnect)
+	for _, data := range []string{"a", "b", "c"} {
+		if err := q.push(outbound(data, false)); err != nil {
+			t.Fatalf("push(%s): %v", data, err)
+		}
+	}
+	if q.len() != 3 {
+		t.Fatalf("len = %d, want 3", q.len())
+	}
+	if got := strings.Join(drain(q), ""); got != "abc" {
+		t.Fatalf("popped %q, want abc", got)
+	}
+	if _, ok := q.pop(); ok {
+		t.Fatal("pop on an empty queue succeeded")
+	}
+}
+
+func TestSendQueueFull(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  Policy
+		queued  []Outbound
+		push    Outbound
+		wantErr error
+		want    string
+	}{
+		{
+			name:    "disconnect overflows",
+			policy:  PolicyDisconnect,
+			queued:  []Outbound{outbound("a", true), outbound("b", true)},
+			push:    outbound("c", true),
+			wantErr: ErrQueueOverflow,
+			want:    "ab",
+		},
+		{
+			name:   "drop oldest evicts the oldest droppable frame",
+			policy: PolicyDropOldest,
+			queued: []Outbound{outbound("a", false), outbound("b", true)},
+			push:   outbound("c", false),
+			want:   "ac",
+		},
+		{
+			name:   "drop oldest discards a droppable frame when nothing can be evicted",
+			policy: PolicyDropOldest,
+			queued: []Outbound{outbound("a", false), outbound("b", false)},
+			push:   outbound("c", true),
+			want:   "ab",
+		},
+		{
+			name:    "drop oldest overflows on a critical frame when nothing can be evicted",
+			policy:  PolicyDropOldest,
+			queued:  []Outbound{outbound("a", false), outbound("b", false)},
+			push:    outbound("c", false),
+			wantErr: ErrQueueOverflow,
+			want:    "ab",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newSendQueue(len(tt.queued), tt.policy)
+			for _, msg := range tt.queued {
+				if err := q.push(msg); err != nil {
+					t.Fatalf("push(%s): %v", msg.Data, err)
+				}
+			}
+
+			if err := q.push(tt.push); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("push on a full queue = %v, want %v", err, tt.wantErr)
+			}
+			if got := strings.Join(drain(q), ""); got != tt.want {
+				t.Fatalf("queue holds %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSendQueueSignalsReady(t *testing.T) {
+	q := newSendQueue(8, PolicyDisconnect)
+	select {
+	case <-q.ready:
+		t.Fatal("empty queue signalled ready")
+	default:
+	}
+
+	// several pushes before the writer wakes coalesce into one signal.
+	_ = q.push(outbound("a", false))
+	_ = q.push(outbound("b", false))
+	select {
+	case <-q.ready:
+	default:
+		t.Fatal("push did not signal ready")
+	}
+	select {
+	case <-q.ready:
+		t.Fatal("ready signalled twice for one batch")
+	default:
+	}
+}
+
+func TestSendQueueSeal(t *testing.T) {
+	q := newSendQueue(8, PolicyDisconnect)
+	_ = q.push(outbound("a", false))
+	<-q.ready
+
+	q.seal()
+	select {
+	case <-q.ready:
+	default:
+		t.Fatal("seal did not wake the writer")
+	}
+	if err := q.push(outbound("b", false)); !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("push after seal = %v, want ErrQueueClosed", err)
+	}
+	if q.drained() {
+		t.Fatal("sealed queue with a pending frame reports drained")
+	}
+	if got := drain(q); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("sealed queue yielded %q, want the pending frame", got)
+	}
+	if !q.drained() {
+		t.Fatal("empty sealed queue does not report drained")
+	}
+}
+
+func TestSendQueueClose(t *testing.T) {
+	q := newSendQueue(8, PolicyDisconnect)
+	_ = q.push(outbound("a", false))
+
+	q.close()
+	if err := q.push(outbound("b", false)); !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("push after close = %v, want ErrQueueClosed", err)
+	}
+	if _, ok := q.pop(); ok {
+		t.Fatal("closed queue still yields frames")
+	}
+}
+
+// serveTestClient upgrades one connection, serves it with opts and returns
+// the client, the peer's end and a channel closed when Serve returns.
+func serveTestClient(t *testing.T, opts Options) (*Client, *websocket.Conn, <-chan struct{}) {
+	t.Helper()
+
+	clients := make(chan *Client, 1)
+	served := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClient(context.Background(), conn, "conn", 1, 0, 0, opts)
+		clients <- client
+		client.Serve()
+		close(served)
+	}))
+	t.Cleanup(server.Close)
+
+	peer, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { peer.Close() })
+
+	return <-clients, peer, served
+}
+
+func waitServed(t *testing.T, served <-chan struct{}, within time.Duration) {
+	t.Helper()
+	select {
+	case <-served:
+	case <-time.After(within):
+		t.Fatalf("Serve did not return within %v of Close", within)
+	}
+}
+
+func TestCloseWhileWriterWaits(t *testing.T) {
+	client, _, served := serveTestClient(t, Options{SendQueueSize: 4, Policy: PolicyDisconnect, WriteTimeout: time.Second})
+
+	// the writer is parked waiting for frames.
+	client.Close(websocket.CloseNormalClosure, "")
+
+	waitServed(t, served, time.Second)
+	if err := client.Send(outbound("late", false)); !errors.Is(err, ErrQueueClosed) {
+		t.Fatalf("Send after Close = %v, want ErrQueueClosed", err)
+	}
+}
+
+func TestCloseWhileWriteBlocked(t *testing.T) {
+	const writeTimeout = 500 * time.Millisecond
+	client, _, served := serveTestClient(t, Options{SendQueueSize: 8, Policy: PolicyDisconnect, WriteTimeout: writeTimeout})
+
+	// the peer never reads, so after the socket buffers fill up the writer
+	// is stuck inside WriteMessage with frames still queued.
+	frame := bytes.Repeat([]byte("x"), 4<<20)
+	for i := 0; i < 8; i++ {
+		if err := client.Send(Outbound{MessageType: websocket.BinaryMessage, Data: frame}); err != nil {
+			t.Fatalf("Send %d: %v", i, err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if client.queue.len() == 0 {
+		t.Skip("socket buffers absorbed every frame; writer never blocked")
+	}
+
+	client.Close(websocket.CloseGoingAway, "")
+
+	// Close may wait out the write timeout for the blocked writer to let go
+	// of the connection, but no longer.
+	waitServed(t, served, writeTimeout+time.Second)
+	if client.queue.len() != 0 {
+		t.Fatal("closed client kept its queued frames")
+	}
+}
+
+func TestOverflowDisconnectsSlowClient(t *testing.T) {
+	client, peer, served := serveTestClient(t, Options{SendQueueSize: 2, Policy: PolicyDisconnect, WriteTimeout: time.Second})
+
+	// fill the queue without signalling ready, so the writer cannot drain
+	// it before the overflowing Send.
+	client.queue.mu.Lock()
+	client.queue.items = append(client.queue.items, outbound("a", false), outbound("b", false))
+	client.queue.mu.Unlock()
+
+	if err := client.Send(outbound("c", false)); !errors.Is(err, ErrQueueOverflow) {
+		t.Fatalf("Send on a full queue = %v, want ErrQueueOverflow", err)
+	}
+	waitServed(t, served, 2*time.Second)
+
+	_ = peer.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := peer.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+				t.Fatalf("peer read error = %v, want close %d", err, websocket.CloseTryAgainLater)
+			}
+			return
+		}
+	}
+}